@@ -0,0 +1,337 @@
+package fake
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+type cluster struct {
+	types.DBCluster
+	pending int
+}
+
+type clusterSnapshot struct {
+	types.DBClusterSnapshot
+	pending int
+}
+
+// AddCluster adds an Aurora DB cluster in the given status.
+func (f *RDS) AddCluster(id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clusters[id] = &cluster{DBCluster: types.DBCluster{
+		DBClusterIdentifier: aws.String(id),
+		DBClusterArn:        aws.String(f.arn("cluster", id)),
+		Status:              aws.String(status),
+		Engine:              aws.String("aurora-postgresql"),
+	}}
+}
+
+// SetClusterStatus changes the status of an existing DB cluster.
+func (f *RDS) SetClusterStatus(id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cluster, ok := f.clusters[id]; ok {
+		cluster.Status = aws.String(status)
+	}
+}
+
+// AddClusterSnapshot adds a manual snapshot of a DB cluster in the given
+// status.
+func (f *RDS) AddClusterSnapshot(id, clusterID, status string, created time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clusterSnapshots[id] = &clusterSnapshot{DBClusterSnapshot: f.newClusterSnapshot(id, clusterID, status, created)}
+}
+
+func (f *RDS) newClusterSnapshot(id, clusterID, status string, created time.Time) types.DBClusterSnapshot {
+	return types.DBClusterSnapshot{
+		DBClusterSnapshotIdentifier: aws.String(id),
+		DBClusterSnapshotArn:        aws.String(f.arn("cluster-snapshot", id)),
+		DBClusterIdentifier:         aws.String(clusterID),
+		SnapshotType:                aws.String("manual"),
+		Status:                      aws.String(status),
+		SnapshotCreateTime:          aws.Time(created),
+		AllocatedStorage:            aws.Int32(1),
+		Engine:                      aws.String("aurora-postgresql"),
+		EngineVersion:               aws.String("16.4"),
+		StorageEncrypted:            aws.Bool(true),
+	}
+}
+
+func (f *RDS) advanceClusterSnapshot(snap *clusterSnapshot) {
+	if aws.ToString(snap.Status) != "creating" {
+		return
+	}
+	if snap.pending > 0 {
+		snap.pending--
+		return
+	}
+	snap.Status = aws.String("available")
+	snap.PercentProgress = aws.Int32(100)
+	if cluster, ok := f.clusters[aws.ToString(snap.DBClusterIdentifier)]; ok && aws.ToString(cluster.Status) == "backing-up" {
+		cluster.Status = aws.String("available")
+	}
+}
+
+func (f *RDS) advanceCluster(c *cluster) {
+	if aws.ToString(c.Status) != "creating" {
+		return
+	}
+	if c.pending > 0 {
+		c.pending--
+		return
+	}
+	c.Status = aws.String("available")
+}
+
+func (f *RDS) DescribeDBClusters(ctx context.Context, params *rds.DescribeDBClustersInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClustersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeDBClusters"); err != nil {
+		return nil, err
+	}
+
+	output := &rds.DescribeDBClustersOutput{}
+	if id := aws.ToString(params.DBClusterIdentifier); id != "" {
+		cluster, ok := f.clusters[id]
+		if !ok {
+			return nil, &types.DBClusterNotFoundFault{Message: aws.String("DBCluster " + id + " not found.")}
+		}
+		f.advanceCluster(cluster)
+		output.DBClusters = append(output.DBClusters, cluster.DBCluster)
+		return output, nil
+	}
+
+	for _, id := range sortedKeys(f.clusters) {
+		cluster := f.clusters[id]
+		f.advanceCluster(cluster)
+		output.DBClusters = append(output.DBClusters, cluster.DBCluster)
+	}
+	return output, nil
+}
+
+func (f *RDS) DescribeDBClusterSnapshots(ctx context.Context, params *rds.DescribeDBClusterSnapshotsInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClusterSnapshotsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeDBClusterSnapshots"); err != nil {
+		return nil, err
+	}
+
+	output := &rds.DescribeDBClusterSnapshotsOutput{}
+	if id := aws.ToString(params.DBClusterSnapshotIdentifier); id != "" {
+		snap, ok := f.clusterSnapshots[id]
+		if !ok {
+			return nil, &types.DBClusterSnapshotNotFoundFault{Message: aws.String("DBClusterSnapshot " + id + " not found.")}
+		}
+		f.advanceClusterSnapshot(snap)
+		output.DBClusterSnapshots = append(output.DBClusterSnapshots, snap.DBClusterSnapshot)
+		return output, nil
+	}
+
+	clusterID := aws.ToString(params.DBClusterIdentifier)
+	snapshotType := aws.ToString(params.SnapshotType)
+	for _, id := range sortedKeys(f.clusterSnapshots) {
+		snap := f.clusterSnapshots[id]
+		if clusterID != "" && aws.ToString(snap.DBClusterIdentifier) != clusterID {
+			continue
+		}
+		if snapshotType != "" && aws.ToString(snap.SnapshotType) != snapshotType {
+			continue
+		}
+		f.advanceClusterSnapshot(snap)
+		output.DBClusterSnapshots = append(output.DBClusterSnapshots, snap.DBClusterSnapshot)
+	}
+	return output, nil
+}
+
+func (f *RDS) CreateDBClusterSnapshot(ctx context.Context, params *rds.CreateDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.CreateDBClusterSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateDBClusterSnapshot"); err != nil {
+		return nil, err
+	}
+
+	clusterID := aws.ToString(params.DBClusterIdentifier)
+	id := aws.ToString(params.DBClusterSnapshotIdentifier)
+	cluster, ok := f.clusters[clusterID]
+	if !ok {
+		return nil, &types.DBClusterNotFoundFault{Message: aws.String("DBCluster " + clusterID + " not found.")}
+	}
+	if status := aws.ToString(cluster.Status); status != "available" {
+		return nil, &types.InvalidDBClusterStateFault{Message: aws.String("DBCluster " + clusterID + " is not in available state: " + status)}
+	}
+	if _, ok := f.clusterSnapshots[id]; ok {
+		return nil, &types.DBClusterSnapshotAlreadyExistsFault{Message: aws.String("DBClusterSnapshot " + id + " already exists.")}
+	}
+
+	snap := &clusterSnapshot{DBClusterSnapshot: f.newClusterSnapshot(id, clusterID, "creating", f.Now()), pending: f.Pending}
+	snap.PercentProgress = aws.Int32(0)
+	snap.TagList = params.Tags
+	f.clusterSnapshots[id] = snap
+	cluster.Status = aws.String("backing-up")
+	return &rds.CreateDBClusterSnapshotOutput{DBClusterSnapshot: &snap.DBClusterSnapshot}, nil
+}
+
+func (f *RDS) CopyDBClusterSnapshot(ctx context.Context, params *rds.CopyDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.CopyDBClusterSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CopyDBClusterSnapshot"); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkSourceRegion(aws.ToString(params.SourceDBClusterSnapshotIdentifier), params.SourceRegion); err != nil {
+		return nil, err
+	}
+	sourceID := aws.ToString(params.SourceDBClusterSnapshotIdentifier)
+	if i := strings.LastIndex(sourceID, ":cluster-snapshot:"); i >= 0 {
+		sourceID = sourceID[i+len(":cluster-snapshot:"):]
+	}
+	id := aws.ToString(params.TargetDBClusterSnapshotIdentifier)
+	if _, ok := f.clusterSnapshots[id]; ok {
+		return nil, &types.DBClusterSnapshotAlreadyExistsFault{Message: aws.String("DBClusterSnapshot " + id + " already exists.")}
+	}
+
+	clusterID := ""
+	if f.Source != nil {
+		f.Source.mu.Lock()
+		source, ok := f.Source.clusterSnapshots[sourceID]
+		shares := f.Source.shares[sourceID]
+		if ok {
+			clusterID = aws.ToString(source.DBClusterIdentifier)
+		}
+		f.Source.mu.Unlock()
+		notFound := &types.DBClusterSnapshotNotFoundFault{Message: aws.String("DBClusterSnapshot " + sourceID + " not found.")}
+		if !ok {
+			return nil, notFound
+		}
+		if err := f.checkSharedCopy(aws.ToString(params.SourceDBClusterSnapshotIdentifier), shares, notFound); err != nil {
+			return nil, err
+		}
+	}
+
+	snap := &clusterSnapshot{DBClusterSnapshot: f.newClusterSnapshot(id, clusterID, "creating", f.Now()), pending: f.Pending}
+	snap.SourceDBClusterSnapshotArn = params.SourceDBClusterSnapshotIdentifier
+	snap.KmsKeyId = params.KmsKeyId
+	f.clusterSnapshots[id] = snap
+	return &rds.CopyDBClusterSnapshotOutput{DBClusterSnapshot: &snap.DBClusterSnapshot}, nil
+}
+
+func (f *RDS) DeleteDBClusterSnapshot(ctx context.Context, params *rds.DeleteDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.DeleteDBClusterSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDBClusterSnapshot"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBClusterSnapshotIdentifier)
+	snap, ok := f.clusterSnapshots[id]
+	if !ok {
+		return nil, &types.DBClusterSnapshotNotFoundFault{Message: aws.String("DBClusterSnapshot " + id + " not found.")}
+	}
+	delete(f.clusterSnapshots, id)
+	snap.Status = aws.String("deleted")
+	return &rds.DeleteDBClusterSnapshotOutput{DBClusterSnapshot: &snap.DBClusterSnapshot}, nil
+}
+
+func (f *RDS) RestoreDBClusterFromSnapshot(ctx context.Context, params *rds.RestoreDBClusterFromSnapshotInput, optFns ...func(*rds.Options)) (*rds.RestoreDBClusterFromSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("RestoreDBClusterFromSnapshot"); err != nil {
+		return nil, err
+	}
+
+	snapshotID := aws.ToString(params.SnapshotIdentifier)
+	if i := strings.LastIndex(snapshotID, ":cluster-snapshot:"); i >= 0 {
+		snapshotID = snapshotID[i+len(":cluster-snapshot:"):]
+	}
+	snap, ok := f.clusterSnapshots[snapshotID]
+	if !ok {
+		return nil, &types.DBClusterSnapshotNotFoundFault{Message: aws.String("DBClusterSnapshot " + snapshotID + " not found.")}
+	}
+	if aws.ToString(snap.Status) != "available" {
+		return nil, &types.InvalidDBClusterSnapshotStateFault{Message: aws.String("DBClusterSnapshot " + snapshotID + " is not available.")}
+	}
+	id := aws.ToString(params.DBClusterIdentifier)
+	if _, ok := f.clusters[id]; ok {
+		return nil, &types.DBClusterAlreadyExistsFault{Message: aws.String("DBCluster " + id + " already exists.")}
+	}
+
+	c := &cluster{pending: f.Pending, DBCluster: types.DBCluster{
+		DBClusterIdentifier:     params.DBClusterIdentifier,
+		DBClusterArn:            aws.String(f.arn("cluster", id)),
+		Status:                  aws.String("creating"),
+		Engine:                  params.Engine,
+		DBSubnetGroup:           params.DBSubnetGroupName,
+		DBClusterParameterGroup: params.DBClusterParameterGroupName,
+		Endpoint:                aws.String(id + ".cluster-fake." + f.Region + ".rds.amazonaws.com"),
+		Port:                    aws.Int32(5432),
+		VpcSecurityGroups:       securityGroups(params.VpcSecurityGroupIds),
+	}}
+	f.clusters[id] = c
+	return &rds.RestoreDBClusterFromSnapshotOutput{DBCluster: &c.DBCluster}, nil
+}
+
+func (f *RDS) CreateDBInstance(ctx context.Context, params *rds.CreateDBInstanceInput, optFns ...func(*rds.Options)) (*rds.CreateDBInstanceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateDBInstance"); err != nil {
+		return nil, err
+	}
+
+	clusterID := aws.ToString(params.DBClusterIdentifier)
+	if _, ok := f.clusters[clusterID]; clusterID != "" && !ok {
+		return nil, &types.DBClusterNotFoundFault{Message: aws.String("DBCluster " + clusterID + " not found.")}
+	}
+	id := aws.ToString(params.DBInstanceIdentifier)
+	if _, ok := f.instances[id]; ok {
+		return nil, &types.DBInstanceAlreadyExistsFault{Message: aws.String("DBInstance " + id + " already exists.")}
+	}
+
+	inst := &instance{pending: f.Pending, DBInstance: types.DBInstance{
+		DBInstanceIdentifier: params.DBInstanceIdentifier,
+		DBInstanceArn:        aws.String(f.arn("db", id)),
+		DBInstanceClass:      params.DBInstanceClass,
+		DBInstanceStatus:     aws.String("creating"),
+		DBClusterIdentifier:  params.DBClusterIdentifier,
+		Engine:               params.Engine,
+	}}
+	f.instances[id] = inst
+	return &rds.CreateDBInstanceOutput{DBInstance: &inst.DBInstance}, nil
+}
+
+// DeleteDBCluster refuses to delete a cluster that still has instances, as
+// RDS does.
+func (f *RDS) DeleteDBCluster(ctx context.Context, params *rds.DeleteDBClusterInput, optFns ...func(*rds.Options)) (*rds.DeleteDBClusterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDBCluster"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBClusterIdentifier)
+	c, ok := f.clusters[id]
+	if !ok {
+		return nil, &types.DBClusterNotFoundFault{Message: aws.String("DBCluster " + id + " not found.")}
+	}
+	for _, inst := range f.instances {
+		if aws.ToString(inst.DBClusterIdentifier) == id {
+			return nil, &types.InvalidDBClusterStateFault{Message: aws.String("Cluster cannot be deleted, it still contains DB instances.")}
+		}
+	}
+	delete(f.clusters, id)
+	c.Status = aws.String("deleting")
+	return &rds.DeleteDBClusterOutput{DBCluster: &c.DBCluster}, nil
+}
+
+// Clusters returns the identifiers of the DB clusters, sorted.
+func (f *RDS) Clusters() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.clusters)
+}
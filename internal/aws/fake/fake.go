@@ -0,0 +1,295 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+)
+
+// Cloud bundles the fakes of a source and a target region.
+type Cloud struct {
+	SourceRegion string
+	TargetRegion string
+	SourceRDS    *RDS
+	TargetRDS    *RDS
+	SourceS3     *S3
+	TargetS3     *S3
+	SourceKMS    *KMS
+	TargetKMS    *KMS
+	IAM          *IAM
+	SourceSTS    *STS
+	TargetSTS    *STS
+	// StagingRDS and StagingKMS are the source account in the target
+	// region, where snapshots are copied before they are shared with a
+	// vault.
+	StagingRDS *RDS
+	StagingKMS *KMS
+	// TargetAccount, if set with UseVault, makes the target region act as
+	// another account, like a backup vault.
+	TargetAccount string
+}
+
+// New returns empty fakes for both regions. The target RDS resolves copies
+// from the source RDS, and the exports of each region write to its S3.
+func New(sourceRegion, targetRegion string) *Cloud {
+	c := &Cloud{
+		SourceRegion: sourceRegion,
+		TargetRegion: targetRegion,
+		SourceRDS:    NewRDS(sourceRegion),
+		TargetRDS:    NewRDS(targetRegion),
+		SourceS3:     NewS3(),
+		TargetS3:     NewS3(),
+		SourceKMS:    NewKMS(sourceRegion),
+		TargetKMS:    NewKMS(targetRegion),
+		IAM:          NewIAM(),
+		SourceSTS:    NewSTS(),
+		TargetSTS:    NewSTS(),
+		StagingRDS:   NewRDS(targetRegion),
+		StagingKMS:   NewKMS(targetRegion),
+	}
+	c.TargetRDS.Source = c.SourceRDS
+	c.StagingRDS.Source = c.SourceRDS
+	c.SourceRDS.S3 = c.SourceS3
+	c.TargetRDS.S3 = c.TargetS3
+	c.SourceS3.Region = sourceRegion
+	c.TargetS3.Region = targetRegion
+	return c
+}
+
+// UseVault makes the target region act as the vault account account. Its
+// RDS then copies the snapshots shared with it by StagingRDS.
+func (c *Cloud) UseVault(account string) {
+	c.TargetAccount = account
+	c.TargetRDS.Account = account
+	c.TargetSTS.Account = account
+	c.TargetRDS.Source = c.StagingRDS
+}
+
+// Clients returns the fakes as the clients used by the backup pipeline.
+func (c *Cloud) Clients() *awsinternal.AWSClients {
+	clients := &awsinternal.AWSClients{
+		SourceRegion:  c.SourceRegion,
+		TargetRegion:  c.TargetRegion,
+		TargetAccount: c.TargetAccount,
+		SourceRDS:     c.SourceRDS,
+		TargetRDS:     c.TargetRDS,
+		SourceS3:      c.SourceS3,
+		TargetS3:      c.TargetS3,
+		SourceKMS:     c.SourceKMS,
+		TargetKMS:     c.TargetKMS,
+		SourceIAM:     c.IAM,
+		TargetIAM:     c.IAM,
+		SourceSTS:     c.SourceSTS,
+		TargetSTS:     c.TargetSTS,
+	}
+	if c.TargetAccount != "" {
+		clients.StagingRDS = c.StagingRDS
+		clients.StagingKMS = c.StagingKMS
+	}
+	return clients
+}
+
+var (
+	_ awsinternal.KMSAPI = (*KMS)(nil)
+	_ awsinternal.IAMAPI = (*IAM)(nil)
+	_ awsinternal.SESAPI = (*SES)(nil)
+	_ awsinternal.SNSAPI = (*SNS)(nil)
+)
+
+// KMS knows the keys added with AddKey, by key ID or ARN, and the aliases
+// added with AddAlias, by name or ARN. A key's policy is the default key
+// policy, which lets IAM policies of the account grant access to it, unless
+// SetKeyPolicy changes it.
+type KMS struct {
+	Region string
+
+	mu       sync.Mutex
+	keys     map[string]kmstypes.KeyMetadata
+	policies map[string]string
+	grants   map[string][]kmstypes.GrantListEntry
+}
+
+func NewKMS(region string) *KMS {
+	return &KMS{
+		Region:   region,
+		keys:     make(map[string]kmstypes.KeyMetadata),
+		policies: make(map[string]string),
+		grants:   make(map[string][]kmstypes.GrantListEntry),
+	}
+}
+
+// AddKey adds a symmetric key and returns its ARN.
+func (f *KMS) AddKey(id string, enabled bool) string {
+	return f.addKey(id, enabled, kmstypes.KeyManagerTypeCustomer)
+}
+
+// AddManagedKey adds an enabled AWS managed key, such as the aws/rds key of
+// RDS, and returns its ARN.
+func (f *KMS) AddManagedKey(id string) string {
+	return f.addKey(id, true, kmstypes.KeyManagerTypeAws)
+}
+
+func (f *KMS) addKey(id string, enabled bool, manager kmstypes.KeyManagerType) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arn := fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", f.Region, Account, id)
+	metadata := kmstypes.KeyMetadata{
+		KeyId:      aws.String(id),
+		Arn:        aws.String(arn),
+		Enabled:    enabled,
+		KeyState:   kmstypes.KeyStateEnabled,
+		KeyUsage:   kmstypes.KeyUsageTypeEncryptDecrypt,
+		KeySpec:    kmstypes.KeySpecSymmetricDefault,
+		KeyManager: manager,
+	}
+	if !enabled {
+		metadata.KeyState = kmstypes.KeyStateDisabled
+	}
+	f.keys[id] = metadata
+	f.keys[arn] = metadata
+	f.policies[id] = fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Sid":"Enable IAM User Permissions","Effect":"Allow","Principal":{"AWS":"arn:aws:iam::%s:root"},"Action":"kms:*","Resource":"*"}]}`, Account)
+	return arn
+}
+
+// SetKeyPolicy replaces the key policy of the key id added with AddKey.
+func (f *KMS) SetKeyPolicy(id, policy string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policies[id] = policy
+}
+
+// AddGrant grants grantee, a principal ARN, the operations on the key id
+// added with AddKey.
+func (f *KMS) AddGrant(id, grantee string, operations ...kmstypes.GrantOperation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.grants[id] = append(f.grants[id], kmstypes.GrantListEntry{
+		GrantId:          aws.String(fmt.Sprintf("grant-%d", len(f.grants[id])+1)),
+		KeyId:            f.keys[id].Arn,
+		GranteePrincipal: aws.String(grantee),
+		IssuingAccount:   aws.String("arn:aws:iam::" + Account + ":root"),
+		Operations:       operations,
+	})
+}
+
+// AddAlias points alias, such as alias/rds-backup, at the key id added
+// with AddKey.
+func (f *KMS) AddAlias(alias, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[alias] = f.keys[id]
+	f.keys[fmt.Sprintf("arn:aws:kms:%s:%s:%s", f.Region, Account, alias)] = f.keys[id]
+}
+
+// key returns the ID of the key that keyID names.
+func (f *KMS) key(keyID *string) (string, error) {
+	metadata, ok := f.keys[aws.ToString(keyID)]
+	if !ok {
+		return "", &kmstypes.NotFoundException{Message: aws.String("Key '" + aws.ToString(keyID) + "' does not exist")}
+	}
+	return aws.ToString(metadata.KeyId), nil
+}
+
+func (f *KMS) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, err := f.key(params.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	metadata := f.keys[id]
+	return &kms.DescribeKeyOutput{KeyMetadata: &metadata}, nil
+}
+
+func (f *KMS) GetKeyPolicy(ctx context.Context, params *kms.GetKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.GetKeyPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, err := f.key(params.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetKeyPolicyOutput{Policy: aws.String(f.policies[id]), PolicyName: aws.String("default")}, nil
+}
+
+// ListGrants returns every grant of the key in one page.
+func (f *KMS) ListGrants(ctx context.Context, params *kms.ListGrantsInput, optFns ...func(*kms.Options)) (*kms.ListGrantsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, err := f.key(params.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.ListGrantsOutput{Grants: slices.Clone(f.grants[id])}, nil
+}
+
+// SES records the emails it is asked to send.
+type SES struct {
+	SendingDisabled bool
+
+	mu   sync.Mutex
+	sent []*ses.SendRawEmailInput
+}
+
+func (f *SES) SendRawEmail(ctx context.Context, params *ses.SendRawEmailInput, optFns ...func(*ses.Options)) (*ses.SendRawEmailOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, params)
+	return &ses.SendRawEmailOutput{MessageId: aws.String(fmt.Sprintf("message-%d", len(f.sent)))}, nil
+}
+
+// GetAccountSendingEnabled reports sending as enabled unless
+// SendingDisabled is set.
+func (f *SES) GetAccountSendingEnabled(ctx context.Context, params *ses.GetAccountSendingEnabledInput, optFns ...func(*ses.Options)) (*ses.GetAccountSendingEnabledOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &ses.GetAccountSendingEnabledOutput{Enabled: !f.SendingDisabled}, nil
+}
+
+// GetIdentityVerificationAttributes reports every identity as verified.
+func (f *SES) GetIdentityVerificationAttributes(ctx context.Context, params *ses.GetIdentityVerificationAttributesInput, optFns ...func(*ses.Options)) (*ses.GetIdentityVerificationAttributesOutput, error) {
+	attributes := make(map[string]sestypes.IdentityVerificationAttributes)
+	for _, identity := range params.Identities {
+		attributes[identity] = sestypes.IdentityVerificationAttributes{VerificationStatus: sestypes.VerificationStatusSuccess}
+	}
+	return &ses.GetIdentityVerificationAttributesOutput{VerificationAttributes: attributes}, nil
+}
+
+// Sent returns the raw MIME messages of the emails sent so far.
+func (f *SES) Sent() []*ses.SendRawEmailInput {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*ses.SendRawEmailInput(nil), f.sent...)
+}
+
+// SNS records the messages published to it.
+type SNS struct {
+	mu        sync.Mutex
+	published []*sns.PublishInput
+}
+
+func (f *SNS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, params)
+	return &sns.PublishOutput{MessageId: aws.String(fmt.Sprintf("message-%d", len(f.published)))}, nil
+}
+
+func (f *SNS) GetTopicAttributes(ctx context.Context, params *sns.GetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error) {
+	return &sns.GetTopicAttributesOutput{Attributes: map[string]string{"TopicArn": aws.ToString(params.TopicArn)}}, nil
+}
+
+// Published returns the messages published so far.
+func (f *SNS) Published() []*sns.PublishInput {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*sns.PublishInput(nil), f.published...)
+}
@@ -0,0 +1,55 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// IAM knows the roles added with AddRole.
+type IAM struct {
+	mu    sync.Mutex
+	roles map[string]types.Role
+}
+
+func NewIAM() *IAM {
+	return &IAM{roles: make(map[string]types.Role)}
+}
+
+// AddRole adds a role that the given services may assume and returns its
+// ARN.
+func (f *IAM) AddRole(name string, services ...string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	policy, _ := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect":    "Allow",
+			"Principal": map[string]any{"Service": services},
+			"Action":    "sts:AssumeRole",
+		}},
+	})
+	arn := fmt.Sprintf("arn:aws:iam::%s:role/%s", Account, name)
+	f.roles[name] = types.Role{
+		RoleName:                 aws.String(name),
+		Arn:                      aws.String(arn),
+		AssumeRolePolicyDocument: aws.String(url.QueryEscape(string(policy))),
+	}
+	return arn
+}
+
+func (f *IAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	role, ok := f.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{Message: aws.String("The role with name " + aws.ToString(params.RoleName) + " cannot be found.")}
+	}
+	return &iam.GetRoleOutput{Role: &role}, nil
+}
@@ -0,0 +1,649 @@
+// Package fake provides in-memory implementations of the AWS client
+// interfaces in package aws, for tests of the backup pipeline.
+//
+// Instances, Aurora clusters and their snapshots are kept separately, as in
+// RDS. Snapshots, copies and exports start in progress and complete after they
+// have been described Pending times, so that tests can drive the waiting and
+// retry branches without sleeping. Errors can be queued per operation with
+// FailNext.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/smithy-go"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+)
+
+const Account = "123456789012"
+
+var _ awsinternal.RDSAPI = (*RDS)(nil)
+
+// RDS is an in-memory RDS service of one region.
+type RDS struct {
+	Region string
+	// Account owns the snapshots of the service, Account by default.
+	Account string
+	// Pending is the number of describe calls a new snapshot, copy, export or
+	// restored instance stays in progress.
+	Pending int
+	// Now returns the creation time of new snapshots, time.Now by default.
+	Now func() time.Time
+	// Source resolves the source snapshot of CopyDBSnapshot.
+	Source *RDS
+	// S3, if set, receives the files of completed exports laid out as RDS
+	// writes them, with data files for each of ExportTables
+	// ("postgres.public.orders" by default).
+	S3           *S3
+	ExportTables []string
+
+	mu               sync.Mutex
+	instances        map[string]*instance
+	snapshots        map[string]*snapshot
+	clusters         map[string]*cluster
+	clusterSnapshots map[string]*clusterSnapshot
+	exports          map[string]*exportTask
+	shares           map[string][]string
+	failures         map[string][]error
+	calls            []string
+}
+
+type instance struct {
+	types.DBInstance
+	pending int
+}
+
+type snapshot struct {
+	types.DBSnapshot
+	pending int
+}
+
+type exportTask struct {
+	types.ExportTask
+	pending int
+	failure string
+}
+
+func NewRDS(region string) *RDS {
+	return &RDS{
+		Region:           region,
+		Account:          Account,
+		Now:              time.Now,
+		instances:        make(map[string]*instance),
+		snapshots:        make(map[string]*snapshot),
+		clusters:         make(map[string]*cluster),
+		clusterSnapshots: make(map[string]*clusterSnapshot),
+		exports:          make(map[string]*exportTask),
+		shares:           make(map[string][]string),
+		failures:         make(map[string][]error),
+	}
+}
+
+// AddInstance adds a DB instance in the given status.
+func (f *RDS) AddInstance(id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances[id] = &instance{DBInstance: types.DBInstance{
+		DBInstanceIdentifier: aws.String(id),
+		DBInstanceArn:        aws.String(f.arn("db", id)),
+		DBInstanceStatus:     aws.String(status),
+		Engine:               aws.String("postgres"),
+	}}
+}
+
+// SetInstanceStatus changes the status of an existing DB instance.
+func (f *RDS) SetInstanceStatus(id, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inst, ok := f.instances[id]; ok {
+		inst.DBInstanceStatus = aws.String(status)
+	}
+}
+
+// AddSnapshot adds a manual snapshot of instance in the given status.
+func (f *RDS) AddSnapshot(id, instanceID, status string, created time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshots[id] = &snapshot{DBSnapshot: f.newSnapshot(id, instanceID, status, created)}
+}
+
+// TagSnapshot adds a tag to an existing DB or DB cluster snapshot.
+func (f *RDS) TagSnapshot(id, key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tag := types.Tag{Key: aws.String(key), Value: aws.String(value)}
+	if snap, ok := f.snapshots[id]; ok {
+		snap.TagList = append(snap.TagList, tag)
+	}
+	if snap, ok := f.clusterSnapshots[id]; ok {
+		snap.TagList = append(snap.TagList, tag)
+	}
+}
+
+// SnapshotStatus returns the status of a DB or DB cluster snapshot, or "" if
+// it does not exist.
+func (f *RDS) SnapshotStatus(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if snap, ok := f.snapshots[id]; ok {
+		return aws.ToString(snap.Status)
+	}
+	if snap, ok := f.clusterSnapshots[id]; ok {
+		return aws.ToString(snap.Status)
+	}
+	return ""
+}
+
+// SnapshotIDs returns the identifiers of all DB and DB cluster snapshots,
+// sorted.
+func (f *RDS) SnapshotIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := append(sortedKeys(f.snapshots), sortedKeys(f.clusterSnapshots)...)
+	sort.Strings(ids)
+	return ids
+}
+
+// ExportTask returns a copy of an export task and whether it exists.
+func (f *RDS) ExportTask(id string) (types.ExportTask, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if task, ok := f.exports[id]; ok {
+		return task.ExportTask, true
+	}
+	return types.ExportTask{}, false
+}
+
+// FailExport makes an export task fail with cause once it would complete.
+func (f *RDS) FailExport(id, cause string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if task, ok := f.exports[id]; ok {
+		task.failure = cause
+	}
+}
+
+// FailNext queues err to be returned by the next call of operation, for
+// example "CreateDBSnapshot".
+func (f *RDS) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = append(f.failures[operation], err)
+}
+
+// Calls returns the operations called so far, in order.
+func (f *RDS) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *RDS) call(operation string) error {
+	f.calls = append(f.calls, operation)
+	if errs := f.failures[operation]; len(errs) > 0 {
+		f.failures[operation] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (f *RDS) arn(kind, id string) string {
+	return fmt.Sprintf("arn:aws:rds:%s:%s:%s:%s", f.Region, f.Account, kind, id)
+}
+
+// checkSourceRegion fails a copy of a snapshot of another region unless the
+// request names that region, which makes the SDK presign it as RDS requires.
+func (f *RDS) checkSourceRegion(sourceARN string, sourceRegion *string) error {
+	parts := strings.Split(sourceARN, ":")
+	if len(parts) < 4 || parts[3] == f.Region || aws.ToString(sourceRegion) == parts[3] {
+		return nil
+	}
+	return &smithy.GenericAPIError{
+		Code:    "InvalidParameterValue",
+		Message: "PreSignedUrl is required to copy a snapshot from " + parts[3],
+	}
+}
+
+// checkSharedCopy fails a copy of a snapshot of another account unless it
+// is in this region and shared with this account: RDS cannot copy an
+// encrypted snapshot shared from another account across regions. shares
+// are the accounts the source snapshot is shared with.
+func (f *RDS) checkSharedCopy(sourceARN string, shares []string, notFound error) error {
+	parts := strings.Split(sourceARN, ":")
+	if len(parts) < 5 || parts[4] == f.Account {
+		return nil
+	}
+	if parts[3] != f.Region {
+		return &smithy.GenericAPIError{
+			Code:    "InvalidParameterCombination",
+			Message: "Cannot copy an encrypted snapshot shared from account " + parts[4] + " across regions",
+		}
+	}
+	if !slices.Contains(shares, f.Account) {
+		return notFound
+	}
+	return nil
+}
+
+func (f *RDS) newSnapshot(id, instanceID, status string, created time.Time) types.DBSnapshot {
+	return types.DBSnapshot{
+		DBSnapshotIdentifier: aws.String(id),
+		DBSnapshotArn:        aws.String(f.arn("snapshot", id)),
+		DBInstanceIdentifier: aws.String(instanceID),
+		SnapshotType:         aws.String("manual"),
+		Status:               aws.String(status),
+		SnapshotCreateTime:   aws.Time(created),
+		AllocatedStorage:     aws.Int32(20),
+		Engine:               aws.String("postgres"),
+		EngineVersion:        aws.String("16.3"),
+		Encrypted:            aws.Bool(true),
+	}
+}
+
+func (f *RDS) advanceSnapshot(snap *snapshot) {
+	if aws.ToString(snap.Status) != "creating" {
+		return
+	}
+	if snap.pending > 0 {
+		snap.pending--
+		return
+	}
+	snap.Status = aws.String("available")
+	snap.PercentProgress = aws.Int32(100)
+	if inst, ok := f.instances[aws.ToString(snap.DBInstanceIdentifier)]; ok && aws.ToString(inst.DBInstanceStatus) == "backing-up" {
+		inst.DBInstanceStatus = aws.String("available")
+	}
+}
+
+func (f *RDS) advanceExport(task *exportTask) {
+	switch aws.ToString(task.Status) {
+	case "STARTING":
+		task.Status = aws.String("IN_PROGRESS")
+	case "IN_PROGRESS":
+		if task.pending > 0 {
+			task.pending--
+			return
+		}
+		task.TaskEndTime = aws.Time(f.Now())
+		if task.failure != "" {
+			task.Status = aws.String("FAILED")
+			task.FailureCause = aws.String(task.failure)
+			return
+		}
+		task.Status = aws.String("COMPLETE")
+		task.PercentProgress = aws.Int32(100)
+		if f.S3 != nil {
+			f.writeExport(task)
+		}
+	}
+}
+
+func (f *RDS) writeExport(task *exportTask) {
+	id := aws.ToString(task.ExportTaskIdentifier)
+	bucket := aws.ToString(task.S3Bucket)
+	prefix := id + "/"
+	if p := strings.TrimSuffix(aws.ToString(task.S3Prefix), "/"); p != "" {
+		prefix = p + "/" + prefix
+	}
+
+	tables := f.ExportTables
+	if tables == nil {
+		tables = []string{"postgres.public.orders"}
+	}
+	var statuses []string
+	for i, table := range tables {
+		if !exported(table, task.ExportOnly) {
+			continue
+		}
+		database, name, _ := strings.Cut(table, ".")
+		f.S3.Put(bucket, fmt.Sprintf("%s%s/%s/1/part-00000-%d.gz.parquet", prefix, database, name, i), []byte("PAR1 fake data PAR1"))
+		statuses = append(statuses, fmt.Sprintf(`{"target": %q, "status": "COMPLETE"}`, table))
+	}
+	f.S3.Put(bucket, prefix+"export_info_"+id+".json", []byte(fmt.Sprintf(`{"exportTaskIdentifier": %q, "status": "COMPLETE"}`, id)))
+	f.S3.Put(bucket, fmt.Sprintf("%sexport_tables_info_%s_from_1_to_%d.json", prefix, id, len(statuses)),
+		[]byte(`{"perTableStatus": [`+strings.Join(statuses, ", ")+`]}`))
+}
+
+func (f *RDS) advanceInstance(inst *instance) {
+	if aws.ToString(inst.DBInstanceStatus) != "creating" {
+		return
+	}
+	if inst.pending > 0 {
+		inst.pending--
+		return
+	}
+	inst.DBInstanceStatus = aws.String("available")
+}
+
+func (f *RDS) DescribeDBInstances(ctx context.Context, params *rds.DescribeDBInstancesInput, optFns ...func(*rds.Options)) (*rds.DescribeDBInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeDBInstances"); err != nil {
+		return nil, err
+	}
+
+	output := &rds.DescribeDBInstancesOutput{}
+	if id := aws.ToString(params.DBInstanceIdentifier); id != "" {
+		inst, ok := f.instances[id]
+		if !ok {
+			return nil, &types.DBInstanceNotFoundFault{Message: aws.String("DBInstance " + id + " not found.")}
+		}
+		f.advanceInstance(inst)
+		output.DBInstances = append(output.DBInstances, inst.DBInstance)
+		return output, nil
+	}
+
+	for _, id := range sortedKeys(f.instances) {
+		inst := f.instances[id]
+		f.advanceInstance(inst)
+		output.DBInstances = append(output.DBInstances, inst.DBInstance)
+	}
+	return output, nil
+}
+
+func (f *RDS) DescribeDBSnapshots(ctx context.Context, params *rds.DescribeDBSnapshotsInput, optFns ...func(*rds.Options)) (*rds.DescribeDBSnapshotsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeDBSnapshots"); err != nil {
+		return nil, err
+	}
+
+	output := &rds.DescribeDBSnapshotsOutput{}
+	if id := aws.ToString(params.DBSnapshotIdentifier); id != "" {
+		snap, ok := f.snapshots[id]
+		if !ok {
+			return nil, &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + id + " not found.")}
+		}
+		f.advanceSnapshot(snap)
+		output.DBSnapshots = append(output.DBSnapshots, snap.DBSnapshot)
+		return output, nil
+	}
+
+	instanceID := aws.ToString(params.DBInstanceIdentifier)
+	snapshotType := aws.ToString(params.SnapshotType)
+	for _, id := range sortedKeys(f.snapshots) {
+		snap := f.snapshots[id]
+		if instanceID != "" && aws.ToString(snap.DBInstanceIdentifier) != instanceID {
+			continue
+		}
+		if snapshotType != "" && aws.ToString(snap.SnapshotType) != snapshotType {
+			continue
+		}
+		f.advanceSnapshot(snap)
+		output.DBSnapshots = append(output.DBSnapshots, snap.DBSnapshot)
+	}
+	return output, nil
+}
+
+func (f *RDS) CreateDBSnapshot(ctx context.Context, params *rds.CreateDBSnapshotInput, optFns ...func(*rds.Options)) (*rds.CreateDBSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateDBSnapshot"); err != nil {
+		return nil, err
+	}
+
+	instanceID := aws.ToString(params.DBInstanceIdentifier)
+	id := aws.ToString(params.DBSnapshotIdentifier)
+	inst, ok := f.instances[instanceID]
+	if !ok {
+		return nil, &types.DBInstanceNotFoundFault{Message: aws.String("DBInstance " + instanceID + " not found.")}
+	}
+	if status := aws.ToString(inst.DBInstanceStatus); status != "available" {
+		return nil, &types.InvalidDBInstanceStateFault{Message: aws.String("DBInstance " + instanceID + " is not in available state: " + status)}
+	}
+	if _, ok := f.snapshots[id]; ok {
+		return nil, &types.DBSnapshotAlreadyExistsFault{Message: aws.String("DBSnapshot " + id + " already exists.")}
+	}
+
+	snap := &snapshot{DBSnapshot: f.newSnapshot(id, instanceID, "creating", f.Now()), pending: f.Pending}
+	snap.PercentProgress = aws.Int32(0)
+	snap.TagList = params.Tags
+	f.snapshots[id] = snap
+	inst.DBInstanceStatus = aws.String("backing-up")
+	return &rds.CreateDBSnapshotOutput{DBSnapshot: &snap.DBSnapshot}, nil
+}
+
+func (f *RDS) CopyDBSnapshot(ctx context.Context, params *rds.CopyDBSnapshotInput, optFns ...func(*rds.Options)) (*rds.CopyDBSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CopyDBSnapshot"); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkSourceRegion(aws.ToString(params.SourceDBSnapshotIdentifier), params.SourceRegion); err != nil {
+		return nil, err
+	}
+	sourceID := aws.ToString(params.SourceDBSnapshotIdentifier)
+	if i := strings.LastIndex(sourceID, ":snapshot:"); i >= 0 {
+		sourceID = sourceID[i+len(":snapshot:"):]
+	}
+	id := aws.ToString(params.TargetDBSnapshotIdentifier)
+	if _, ok := f.snapshots[id]; ok {
+		return nil, &types.DBSnapshotAlreadyExistsFault{Message: aws.String("DBSnapshot " + id + " already exists.")}
+	}
+
+	instanceID := ""
+	if f.Source != nil {
+		f.Source.mu.Lock()
+		source, ok := f.Source.snapshots[sourceID]
+		shares := f.Source.shares[sourceID]
+		if ok {
+			instanceID = aws.ToString(source.DBInstanceIdentifier)
+		}
+		f.Source.mu.Unlock()
+		notFound := &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + sourceID + " not found.")}
+		if !ok {
+			return nil, notFound
+		}
+		if err := f.checkSharedCopy(aws.ToString(params.SourceDBSnapshotIdentifier), shares, notFound); err != nil {
+			return nil, err
+		}
+	}
+
+	snap := &snapshot{DBSnapshot: f.newSnapshot(id, instanceID, "creating", f.Now()), pending: f.Pending}
+	snap.SourceDBSnapshotIdentifier = params.SourceDBSnapshotIdentifier
+	snap.KmsKeyId = params.KmsKeyId
+	f.snapshots[id] = snap
+	return &rds.CopyDBSnapshotOutput{DBSnapshot: &snap.DBSnapshot}, nil
+}
+
+func (f *RDS) DeleteDBSnapshot(ctx context.Context, params *rds.DeleteDBSnapshotInput, optFns ...func(*rds.Options)) (*rds.DeleteDBSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDBSnapshot"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBSnapshotIdentifier)
+	snap, ok := f.snapshots[id]
+	if !ok {
+		return nil, &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + id + " not found.")}
+	}
+	delete(f.snapshots, id)
+	snap.Status = aws.String("deleted")
+	return &rds.DeleteDBSnapshotOutput{DBSnapshot: &snap.DBSnapshot}, nil
+}
+
+func (f *RDS) StartExportTask(ctx context.Context, params *rds.StartExportTaskInput, optFns ...func(*rds.Options)) (*rds.StartExportTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("StartExportTask"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.ExportTaskIdentifier)
+	if _, ok := f.exports[id]; ok {
+		return nil, &types.ExportTaskAlreadyExistsFault{Message: aws.String("Export task " + id + " already exists.")}
+	}
+
+	sourceStatus := ""
+	for _, snap := range f.snapshots {
+		if aws.ToString(snap.DBSnapshotArn) == aws.ToString(params.SourceArn) {
+			sourceStatus = aws.ToString(snap.Status)
+		}
+	}
+	for _, snap := range f.clusterSnapshots {
+		if aws.ToString(snap.DBClusterSnapshotArn) == aws.ToString(params.SourceArn) {
+			sourceStatus = aws.ToString(snap.Status)
+		}
+	}
+	if sourceStatus == "" {
+		return nil, &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + aws.ToString(params.SourceArn) + " not found.")}
+	}
+	if sourceStatus != "available" {
+		return nil, &types.InvalidExportSourceStateFault{Message: aws.String("snapshot is not available")}
+	}
+
+	task := &exportTask{pending: f.Pending, ExportTask: types.ExportTask{
+		ExportTaskIdentifier: params.ExportTaskIdentifier,
+		SourceArn:            params.SourceArn,
+		S3Bucket:             params.S3BucketName,
+		S3Prefix:             params.S3Prefix,
+		IamRoleArn:           params.IamRoleArn,
+		KmsKeyId:             params.KmsKeyId,
+		ExportOnly:           params.ExportOnly,
+		Status:               aws.String("STARTING"),
+		PercentProgress:      aws.Int32(0),
+		TaskStartTime:        aws.Time(f.Now()),
+	}}
+	f.exports[id] = task
+	return &rds.StartExportTaskOutput{
+		ExportTaskIdentifier: task.ExportTaskIdentifier,
+		SourceArn:            task.SourceArn,
+		S3Bucket:             task.S3Bucket,
+		Status:               task.Status,
+	}, nil
+}
+
+func (f *RDS) DescribeExportTasks(ctx context.Context, params *rds.DescribeExportTasksInput, optFns ...func(*rds.Options)) (*rds.DescribeExportTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DescribeExportTasks"); err != nil {
+		return nil, err
+	}
+
+	output := &rds.DescribeExportTasksOutput{}
+	if id := aws.ToString(params.ExportTaskIdentifier); id != "" {
+		task, ok := f.exports[id]
+		if !ok {
+			return nil, &types.ExportTaskNotFoundFault{Message: aws.String("Export task " + id + " not found.")}
+		}
+		f.advanceExport(task)
+		output.ExportTasks = append(output.ExportTasks, task.ExportTask)
+		return output, nil
+	}
+
+	for _, id := range sortedKeys(f.exports) {
+		task := f.exports[id]
+		f.advanceExport(task)
+		output.ExportTasks = append(output.ExportTasks, task.ExportTask)
+	}
+	return output, nil
+}
+
+func (f *RDS) RestoreDBInstanceFromDBSnapshot(ctx context.Context, params *rds.RestoreDBInstanceFromDBSnapshotInput, optFns ...func(*rds.Options)) (*rds.RestoreDBInstanceFromDBSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("RestoreDBInstanceFromDBSnapshot"); err != nil {
+		return nil, err
+	}
+
+	snapshotID := aws.ToString(params.DBSnapshotIdentifier)
+	snap, ok := f.snapshots[snapshotID]
+	if !ok {
+		return nil, &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + snapshotID + " not found.")}
+	}
+	if aws.ToString(snap.Status) != "available" {
+		return nil, &types.InvalidDBSnapshotStateFault{Message: aws.String("DBSnapshot " + snapshotID + " is not available.")}
+	}
+	id := aws.ToString(params.DBInstanceIdentifier)
+	if _, ok := f.instances[id]; ok {
+		return nil, &types.DBInstanceAlreadyExistsFault{Message: aws.String("DBInstance " + id + " already exists.")}
+	}
+
+	inst := &instance{pending: f.Pending, DBInstance: types.DBInstance{
+		DBInstanceIdentifier: params.DBInstanceIdentifier,
+		DBInstanceArn:        aws.String(f.arn("db", id)),
+		DBInstanceClass:      params.DBInstanceClass,
+		DBInstanceStatus:     aws.String("creating"),
+		Engine:               snap.Engine,
+		EngineVersion:        snap.EngineVersion,
+		VpcSecurityGroups:    securityGroups(params.VpcSecurityGroupIds),
+		Endpoint: &types.Endpoint{
+			Address: aws.String(id + ".fake." + f.Region + ".rds.amazonaws.com"),
+			Port:    aws.Int32(5432),
+		},
+	}}
+	if name := params.DBSubnetGroupName; name != nil {
+		inst.DBSubnetGroup = &types.DBSubnetGroup{DBSubnetGroupName: name}
+	}
+	if name := params.DBParameterGroupName; name != nil {
+		inst.DBParameterGroups = []types.DBParameterGroupStatus{{DBParameterGroupName: name}}
+	}
+	f.instances[id] = inst
+	return &rds.RestoreDBInstanceFromDBSnapshotOutput{DBInstance: &inst.DBInstance}, nil
+}
+
+// DeleteDBInstance deletes the instance at once, so that waiters see it gone.
+func (f *RDS) DeleteDBInstance(ctx context.Context, params *rds.DeleteDBInstanceInput, optFns ...func(*rds.Options)) (*rds.DeleteDBInstanceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDBInstance"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBInstanceIdentifier)
+	inst, ok := f.instances[id]
+	if !ok {
+		return nil, &types.DBInstanceNotFoundFault{Message: aws.String("DBInstance " + id + " not found.")}
+	}
+	delete(f.instances, id)
+	inst.DBInstanceStatus = aws.String("deleting")
+	return &rds.DeleteDBInstanceOutput{DBInstance: &inst.DBInstance}, nil
+}
+
+// Instances returns the identifiers of the DB instances, sorted.
+func (f *RDS) Instances() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.instances)
+}
+
+// exported reports whether an export limited to exportOnly includes table.
+func exported(table string, exportOnly []string) bool {
+	if len(exportOnly) == 0 {
+		return true
+	}
+	for _, entry := range exportOnly {
+		if table == entry || strings.HasPrefix(table, entry+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func securityGroups(ids []string) []types.VpcSecurityGroupMembership {
+	var groups []types.VpcSecurityGroupMembership
+	for _, id := range ids {
+		groups = append(groups, types.VpcSecurityGroupMembership{VpcSecurityGroupId: aws.String(id), Status: aws.String("active")})
+	}
+	return groups
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+)
+
+var _ awsinternal.S3API = (*S3)(nil)
+
+// S3 is an in-memory object store in Region. Buckets are created on first
+// write or with AddBucket.
+type S3 struct {
+	Region string
+
+	mu      sync.Mutex
+	objects map[string]map[string][]byte
+}
+
+func NewS3() *S3 {
+	return &S3{objects: make(map[string]map[string][]byte)}
+}
+
+// AddBucket creates an empty bucket.
+func (f *S3) AddBucket(bucket string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[bucket] == nil {
+		f.objects[bucket] = make(map[string][]byte)
+	}
+}
+
+// Put stores an object directly, for example to simulate export output.
+func (f *S3) Put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[bucket] == nil {
+		f.objects[bucket] = make(map[string][]byte)
+	}
+	f.objects[bucket][key] = data
+}
+
+// Keys returns the sorted keys of a bucket.
+func (f *S3) Keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.objects[bucket])
+}
+
+func (f *S3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+	f.Put(aws.ToString(params.Bucket), aws.ToString(params.Key), data)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *S3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.ToString(params.Bucket)][aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("The specified key does not exist.")}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: aws.Int64(int64(len(data))),
+	}, nil
+}
+
+func (f *S3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket := aws.ToString(params.Bucket)
+	prefix := aws.ToString(params.Prefix)
+
+	output := &s3.ListObjectsV2Output{Name: params.Bucket, Prefix: params.Prefix}
+	for _, key := range sortedKeys(f.objects[bucket]) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		output.Contents = append(output.Contents, types.Object{
+			Key:  aws.String(key),
+			Size: aws.Int64(int64(len(f.objects[bucket][key]))),
+		})
+	}
+	output.KeyCount = aws.Int32(int32(len(output.Contents)))
+	output.IsTruncated = aws.Bool(false)
+	return output, nil
+}
+
+func (f *S3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects[aws.ToString(params.Bucket)], aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *S3) GetBucketLocation(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[aws.ToString(params.Bucket)] == nil {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchBucket", Message: "The specified bucket does not exist"}
+	}
+	// Buckets in us-east-1 have no location constraint
+	output := &s3.GetBucketLocationOutput{}
+	if f.Region != "us-east-1" {
+		output.LocationConstraint = types.BucketLocationConstraint(f.Region)
+	}
+	return output, nil
+}
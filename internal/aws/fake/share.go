@@ -0,0 +1,69 @@
+package fake
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// SharedWith returns the accounts a DB or DB cluster snapshot is shared
+// with.
+func (f *RDS) SharedWith(id string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.shares[id])
+}
+
+// share updates the accounts of the restore attribute of snapshot id.
+func (f *RDS) share(id string, add, remove []string) []string {
+	accounts := f.shares[id]
+	for _, account := range add {
+		if !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+	accounts = slices.DeleteFunc(accounts, func(account string) bool {
+		return slices.Contains(remove, account)
+	})
+	f.shares[id] = accounts
+	return accounts
+}
+
+func (f *RDS) ModifyDBSnapshotAttribute(ctx context.Context, params *rds.ModifyDBSnapshotAttributeInput, optFns ...func(*rds.Options)) (*rds.ModifyDBSnapshotAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ModifyDBSnapshotAttribute"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBSnapshotIdentifier)
+	if _, ok := f.snapshots[id]; !ok {
+		return nil, &types.DBSnapshotNotFoundFault{Message: aws.String("DBSnapshot " + id + " not found.")}
+	}
+	accounts := f.share(id, params.ValuesToAdd, params.ValuesToRemove)
+	return &rds.ModifyDBSnapshotAttributeOutput{DBSnapshotAttributesResult: &types.DBSnapshotAttributesResult{
+		DBSnapshotIdentifier: aws.String(id),
+		DBSnapshotAttributes: []types.DBSnapshotAttribute{{AttributeName: aws.String("restore"), AttributeValues: accounts}},
+	}}, nil
+}
+
+func (f *RDS) ModifyDBClusterSnapshotAttribute(ctx context.Context, params *rds.ModifyDBClusterSnapshotAttributeInput, optFns ...func(*rds.Options)) (*rds.ModifyDBClusterSnapshotAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ModifyDBClusterSnapshotAttribute"); err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.DBClusterSnapshotIdentifier)
+	if _, ok := f.clusterSnapshots[id]; !ok {
+		return nil, &types.DBClusterSnapshotNotFoundFault{Message: aws.String("DBClusterSnapshot " + id + " not found.")}
+	}
+	accounts := f.share(id, params.ValuesToAdd, params.ValuesToRemove)
+	return &rds.ModifyDBClusterSnapshotAttributeOutput{DBClusterSnapshotAttributesResult: &types.DBClusterSnapshotAttributesResult{
+		DBClusterSnapshotIdentifier: aws.String(id),
+		DBClusterSnapshotAttributes: []types.DBClusterSnapshotAttribute{{AttributeName: aws.String("restore"), AttributeValues: accounts}},
+	}}, nil
+}
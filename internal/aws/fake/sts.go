@@ -0,0 +1,97 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+)
+
+var _ awsinternal.STSAPI = (*STS)(nil)
+
+// STS hands out credentials for any role and reports the caller as a role
+// of Account. Errors can be queued per operation with FailNext.
+type STS struct {
+	// Account is the account of the caller, Account by default.
+	Account string
+
+	mu       sync.Mutex
+	assumed  []*sts.AssumeRoleInput
+	failures map[string][]error
+	calls    []string
+}
+
+func NewSTS() *STS {
+	return &STS{Account: Account, failures: make(map[string][]error)}
+}
+
+// FailNext queues err to be returned by the next call of operation, for
+// example "AssumeRole".
+func (f *STS) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = append(f.failures[operation], err)
+}
+
+// Calls returns the operations called so far, in order.
+func (f *STS) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Assumed returns the inputs of the AssumeRole calls so far.
+func (f *STS) Assumed() []*sts.AssumeRoleInput {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*sts.AssumeRoleInput(nil), f.assumed...)
+}
+
+func (f *STS) call(operation string) error {
+	f.calls = append(f.calls, operation)
+	if errs := f.failures[operation]; len(errs) > 0 {
+		f.failures[operation] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// AssumeRole returns credentials that expire in an hour.
+func (f *STS) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("AssumeRole"); err != nil {
+		return nil, err
+	}
+	f.assumed = append(f.assumed, params)
+	return &sts.AssumeRoleOutput{
+		Credentials: &types.Credentials{
+			AccessKeyId:     aws.String(fmt.Sprintf("ASIA%08d", len(f.assumed))),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+		AssumedRoleUser: &types.AssumedRoleUser{
+			Arn:           aws.String(fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s", f.Account, aws.ToString(params.RoleSessionName))),
+			AssumedRoleId: aws.String("AROA" + aws.ToString(params.RoleSessionName)),
+		},
+	}, nil
+}
+
+func (f *STS) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetCallerIdentity"); err != nil {
+		return nil, err
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(f.Account),
+		Arn:     aws.String(fmt.Sprintf("arn:aws:sts::%s:assumed-role/rds-backup/rds-backup", f.Account)),
+		UserId:  aws.String("AROAEXAMPLE:rds-backup"),
+	}, nil
+}
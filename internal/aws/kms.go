@@ -2,51 +2,217 @@ package aws
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 )
 
-func GetKMSKeyARN(ctx context.Context, region, keyID string) (string, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// ResolveKMSKey returns the ARN of the key that keyID names in region.
+// keyID may be a key ID, a key ARN, an alias name such as alias/rds-backup
+// or an alias ARN. A key of another region is an error.
+func ResolveKMSKey(ctx context.Context, kmsClient KMSAPI, region, keyID string) (string, error) {
+	resp, err := kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(keyID),
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to load SDK config: %v", err)
+		return "", fmt.Errorf("failed to describe KMS key %s: %w", keyID, err)
+	}
+
+	arn := aws.ToString(resp.KeyMetadata.Arn)
+	// arn:<partition>:kms:<region>:<account>:key/<id>
+	if parts := strings.Split(arn, ":"); len(parts) < 4 || parts[3] != region {
+		return "", fmt.Errorf("KMS key %s (%s) is not in %s", keyID, arn, region)
+	}
+	return arn, nil
+}
+
+// Service principals of RDS and of its snapshot exports, which assumes the
+// export role.
+const (
+	RDSService    = "rds.amazonaws.com"
+	ExportService = "export.rds.amazonaws.com"
+)
+
+var (
+	// rdsKeyOperations are the operations RDS needs on the key of the
+	// snapshots it creates and copies. It encrypts through the grants it
+	// creates.
+	rdsKeyOperations = []types.GrantOperation{types.GrantOperationDescribeKey, types.GrantOperationCreateGrant}
+	// exportKeyOperations are the operations the export role needs on the
+	// key of a snapshot export.
+	exportKeyOperations = []types.GrantOperation{
+		types.GrantOperationEncrypt, types.GrantOperationDecrypt,
+		types.GrantOperationReEncryptFrom, types.GrantOperationReEncryptTo,
+		types.GrantOperationGenerateDataKey, types.GrantOperationGenerateDataKeyWithoutPlaintext,
+		types.GrantOperationCreateGrant, types.GrantOperationRetireGrant, types.GrantOperationDescribeKey,
 	}
+)
 
-	stsClient := sts.NewFromConfig(cfg)
-	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+// VerifyKMSKey checks that RDS can encrypt snapshots with the key: it must
+// be an enabled symmetric encryption key whose key policy or grants let RDS
+// use it. With exportRoleArn, for snapshot exports, the key must also be
+// customer managed and usable by the export role. What the key policy
+// allows the key's account counts as allowed, since IAM policies of the
+// account then decide.
+func VerifyKMSKey(ctx context.Context, kmsClient KMSAPI, keyArn, exportRoleArn string) error {
+	key, err := verifyKMSKey(ctx, kmsClient, keyArn, exportRoleArn != "")
 	if err != nil {
-		return "", fmt.Errorf("unable to get caller identity: %v", err)
+		return err
+	}
+	// The key policy of AWS managed keys lets RDS use them
+	if key.KeyManager == types.KeyManagerTypeAws {
+		return nil
 	}
 
-	if strings.HasPrefix(keyID, "mrk-") {
-		return fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", region, *identity.Account, keyID), nil
+	account := keyAccount(keyArn)
+	missing, err := missingKeyOperations(ctx, kmsClient, keyArn, rdsKeyOperations, principalOf(account, RDSService))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("neither the key policy nor a grant allows RDS to use the key for %s (expected for account %s or %s)", strings.Join(missing, ", "), account, RDSService)
 	}
 
-	if strings.HasPrefix(keyID, "arn:aws:kms") {
-		return keyID, nil
+	if exportRoleArn == "" {
+		return nil
+	}
+	missing, err = missingKeyOperations(ctx, kmsClient, keyArn, exportKeyOperations, principalOf(account, ExportService, exportRoleArn))
+	if err != nil {
+		return err
 	}
+	if len(missing) > 0 {
+		return fmt.Errorf("neither the key policy nor a grant allows the export role %s to use the key for %s", exportRoleArn, strings.Join(missing, ", "))
+	}
+	return nil
+}
 
-	return fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", region, *identity.Account, keyID), nil
+// keyAccount returns the account ID of a key ARN such as
+// arn:aws:kms:us-east-1:123456789012:key/<id>.
+func keyAccount(keyArn string) string {
+	parts := strings.Split(keyArn, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
 }
 
-func VerifyKMSKey(ctx context.Context, cfg aws.Config, keyArn string) error {
-	kmsClient := kms.NewFromConfig(cfg)
+// principalOf returns a match for missingKeyOperations that accepts any
+// principal ("*"), the account and its root, and the given principals.
+func principalOf(account string, principals ...string) func(principal string) bool {
+	return func(principal string) bool {
+		return principal == "*" || principal == account || strings.HasSuffix(principal, ":iam::"+account+":root") ||
+			slices.Contains(principals, principal)
+	}
+}
 
+func verifyKMSKey(ctx context.Context, kmsClient KMSAPI, keyArn string, export bool) (*types.KeyMetadata, error) {
 	resp, err := kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{
 		KeyId: aws.String(keyArn),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to describe KMS key: %w", err)
+		return nil, fmt.Errorf("failed to describe KMS key: %w", err)
 	}
+	key := resp.KeyMetadata
 
-	if !resp.KeyMetadata.Enabled {
-		return fmt.Errorf("KMS key is disabled")
+	if !key.Enabled {
+		return nil, fmt.Errorf("KMS key is not enabled (%s)", key.KeyState)
+	}
+	if key.KeyUsage != types.KeyUsageTypeEncryptDecrypt || key.KeySpec != types.KeySpecSymmetricDefault {
+		return nil, fmt.Errorf("KMS key is a %s %s key, RDS requires a symmetric encryption key", key.KeySpec, key.KeyUsage)
+	}
+	if export && key.KeyManager == types.KeyManagerTypeAws {
+		return nil, fmt.Errorf("KMS key is AWS managed, snapshot exports require a customer managed key")
 	}
 
+	return key, nil
+}
+
+// sharedKeyOperations are the operations another account needs on the key
+// of a snapshot shared with it to copy the snapshot.
+var sharedKeyOperations = []types.GrantOperation{types.GrantOperationDescribeKey, types.GrantOperationDecrypt, types.GrantOperationCreateGrant}
+
+// VerifySharedKMSKey checks that snapshots encrypted with the key can be
+// shared with account and copied there. On top of the checks of
+// VerifyKMSKey, the key must be customer managed, since the key policy of
+// AWS managed keys such as aws/rds cannot grant other accounts, and its key
+// policy or a grant must let account use it.
+func VerifySharedKMSKey(ctx context.Context, kmsClient KMSAPI, keyArn, account string) error {
+	key, err := verifyKMSKey(ctx, kmsClient, keyArn, false)
+	if err != nil {
+		return err
+	}
+	if key.KeyManager == types.KeyManagerTypeAws {
+		return fmt.Errorf("KMS key is AWS managed and cannot be shared with account %s, use a customer managed key", account)
+	}
+
+	inAccount := func(principal string) bool {
+		return principal == "*" || principal == account || strings.Contains(principal, ":iam::"+account+":")
+	}
+	missing, err := missingKeyOperations(ctx, kmsClient, keyArn, sharedKeyOperations, inAccount)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("neither the key policy nor a grant allows account %s to use the key for %s", account, strings.Join(missing, ", "))
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// missingKeyOperations returns the operations that neither the key policy
+// nor a grant of the key allows to a principal, AWS or service, accepted by
+// match. Conditions of the key policy and grant constraints are not
+// evaluated.
+func missingKeyOperations(ctx context.Context, kmsClient KMSAPI, keyArn string, operations []types.GrantOperation, match func(principal string) bool) ([]string, error) {
+	output, err := kmsClient.GetKeyPolicy(ctx, &kms.GetKeyPolicyInput{
+		KeyId:      aws.String(keyArn),
+		PolicyName: aws.String("default"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key policy: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal([]byte(aws.ToString(output.Policy)), &policy); err != nil {
+		return nil, fmt.Errorf("invalid key policy: %w", err)
+	}
+
+	allowed := make(map[types.GrantOperation]bool)
+	for _, statement := range policy.Statement {
+		if !slices.ContainsFunc(statement.Principals("AWS"), match) && !slices.ContainsFunc(statement.Principals("Service"), match) {
+			continue
+		}
+		for _, operation := range operations {
+			if statement.Allows("kms:" + string(operation)) {
+				allowed[operation] = true
+			}
+		}
+	}
+
+	paginator := kms.NewListGrantsPaginator(kmsClient, &kms.ListGrantsInput{KeyId: aws.String(keyArn)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list grants: %w", err)
+		}
+		for _, grant := range page.Grants {
+			if !match(aws.ToString(grant.GranteePrincipal)) {
+				continue
+			}
+			for _, operation := range grant.Operations {
+				allowed[operation] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, operation := range operations {
+		if !allowed[operation] {
+			missing = append(missing, "kms:"+string(operation))
+		}
+	}
+	return missing, nil
+}
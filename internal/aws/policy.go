@@ -0,0 +1,60 @@
+package aws
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// PolicyList decodes a policy element that is a single value or a list.
+type PolicyList[T any] []T
+
+func (l *PolicyList[T]) UnmarshalJSON(data []byte) error {
+	var one T
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = PolicyList[T]{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]T)(l))
+}
+
+// PolicyStatement is the part of a policy statement that is checked.
+// Conditions are not evaluated.
+type PolicyStatement struct {
+	Effect    string
+	Action    PolicyList[string]
+	Principal json.RawMessage
+}
+
+// Policy is an IAM or resource policy document.
+type Policy struct {
+	Statement PolicyList[PolicyStatement]
+}
+
+// Allows reports whether the statement allows action, such as
+// sts:AssumeRole, matching wildcards in the statement's actions.
+func (s PolicyStatement) Allows(action string) bool {
+	if s.Effect != "Allow" {
+		return false
+	}
+	for _, pattern := range s.Action {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(action)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Principals returns the principals of the statement of a type, such as
+// AWS or Service. A principal of "*" is returned for every type.
+func (s PolicyStatement) Principals(kind string) []string {
+	var everyone string
+	if json.Unmarshal(s.Principal, &everyone) == nil {
+		return []string{everyone}
+	}
+	var principals map[string]PolicyList[string]
+	if json.Unmarshal(s.Principal, &principals) != nil {
+		return nil
+	}
+	return principals[kind]
+}
@@ -3,34 +3,140 @@ package aws
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/unplank/rds-backup-lambda/internal/config"
 )
 
+// DefaultSessionName is the session name of assumed roles without one.
+const DefaultSessionName = "rds-backup"
+
+// AWSClients are the clients of the source and target regions. When the
+// target region clients act in a backup vault account, TargetAccount is its
+// ID, and StagingRDS and StagingKMS act in the source account in the target
+// region, where snapshots are copied before they are shared with the vault.
 type AWSClients struct {
-	SourceRDS *rds.Client
-	TargetRDS *rds.Client
-	SourceS3  *s3.Client
-	TargetS3  *s3.Client
+	SourceRegion  string
+	TargetRegion  string
+	TargetAccount string
+	SourceRDS     RDSAPI
+	TargetRDS     RDSAPI
+	SourceS3      S3API
+	TargetS3      S3API
+	SourceKMS     KMSAPI
+	TargetKMS     KMSAPI
+	SourceIAM     IAMAPI
+	TargetIAM     IAMAPI
+	SourceSTS     STSAPI
+	TargetSTS     STSAPI
+	StagingRDS    RDSAPI
+	StagingKMS    KMSAPI
 }
 
-func NewClients(ctx context.Context, sourceRegion, targetRegion string) (*AWSClients, error) {
-	sourceCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(sourceRegion))
+// NewClients returns the clients of both regions of cfg, with the
+// credentials of cfg.SourceRole and cfg.TargetRole.
+func NewClients(ctx context.Context, cfg *config.Config) (*AWSClients, error) {
+	sourceCfg, err := LoadConfig(ctx, cfg.SourceRegion, cfg.SourceRole)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load source region config: %w", err)
 	}
 
-	targetCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(targetRegion))
+	targetCfg, err := LoadConfig(ctx, cfg.TargetRegion, cfg.TargetRole)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load target region config: %w", err)
 	}
 
-	return &AWSClients{
-		SourceRDS: rds.NewFromConfig(sourceCfg),
-		TargetRDS: rds.NewFromConfig(targetCfg),
-		SourceS3:  s3.NewFromConfig(sourceCfg),
-		TargetS3:  s3.NewFromConfig(targetCfg),
-	}, nil
+	clients := &AWSClients{
+		SourceRegion: cfg.SourceRegion,
+		TargetRegion: cfg.TargetRegion,
+		SourceRDS:    rds.NewFromConfig(sourceCfg),
+		TargetRDS:    rds.NewFromConfig(targetCfg),
+		SourceS3:     s3.NewFromConfig(sourceCfg),
+		TargetS3:     s3.NewFromConfig(targetCfg),
+		SourceKMS:    kms.NewFromConfig(sourceCfg),
+		TargetKMS:    kms.NewFromConfig(targetCfg),
+		SourceIAM:    iam.NewFromConfig(sourceCfg),
+		TargetIAM:    iam.NewFromConfig(targetCfg),
+		SourceSTS:    newSTS(sourceCfg),
+		TargetSTS:    newSTS(targetCfg),
+	}
+
+	if cfg.Vault.Enabled() {
+		stagingCfg, err := LoadConfig(ctx, cfg.TargetRegion, cfg.SourceRole)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load target region config of the source account: %w", err)
+		}
+		clients.TargetAccount = RoleAccount(cfg.Vault.RoleARN)
+		clients.StagingRDS = rds.NewFromConfig(stagingCfg)
+		clients.StagingKMS = kms.NewFromConfig(stagingCfg)
+	}
+	return clients, nil
+}
+
+type configKey struct {
+	region string
+	role   config.Role
+}
+
+var (
+	configsMu sync.Mutex
+	configs   = make(map[configKey]aws.Config)
+
+	// newSTS returns the STS client of cfg. Tests replace it to assume
+	// roles against a fake.
+	newSTS = func(cfg aws.Config) STSAPI { return sts.NewFromConfig(cfg) }
+)
+
+// LoadConfig returns the AWS config of region with the default credentials
+// or, if role is set, with the credentials of role assumed through STS. A
+// config is loaded once per region and role and then shared, so that the
+// credentials of a role are cached and refreshed before they expire rather
+// than assumed again for every client.
+func LoadConfig(ctx context.Context, region string, role config.Role) (aws.Config, error) {
+	configsMu.Lock()
+	defer configsMu.Unlock()
+
+	key := configKey{region: region, role: role}
+	if cfg, ok := configs[key]; ok {
+		return cfg, nil
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if role.ARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(newSTS(cfg), role.ARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = role.SessionName
+			if o.RoleSessionName == "" {
+				o.RoleSessionName = DefaultSessionName
+			}
+			if role.ExternalID != "" {
+				o.ExternalID = aws.String(role.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	configs[key] = cfg
+	return cfg, nil
+}
+
+// RoleAccount returns the account ID of a role ARN such as
+// arn:aws:iam::123456789012:role/rds-backup.
+func RoleAccount(roleArn string) string {
+	parts := strings.Split(roleArn, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
 }
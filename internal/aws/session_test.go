@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// assumer records the roles assumed through it.
+type assumer struct {
+	assumed []*sts.AssumeRoleInput
+}
+
+func (a *assumer) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	a.assumed = append(a.assumed, params)
+	return &sts.AssumeRoleOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("ASIAEXAMPLE"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func (a *assumer) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}, nil
+}
+
+func TestLoadConfigAssumesRole(t *testing.T) {
+	client := &assumer{}
+	original := newSTS
+	newSTS = func(cfg aws.Config) STSAPI { return client }
+	t.Cleanup(func() { newSTS = original })
+
+	tests := []struct {
+		role        config.Role
+		sessionName string
+	}{
+		{config.Role{ARN: "arn:aws:iam::123456789012:role/session-test"}, DefaultSessionName},
+		{config.Role{ARN: "arn:aws:iam::123456789012:role/session-test", ExternalID: "ext-1", SessionName: "nightly"}, "nightly"},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		client.assumed = nil
+		// The second config of a region and role shares the cached
+		// credentials of the first
+		for range 2 {
+			cfg, err := LoadConfig(ctx, "eu-central-1", tt.role)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			creds, err := cfg.Credentials.Retrieve(ctx)
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			if creds.AccessKeyID != "ASIAEXAMPLE" {
+				t.Errorf("role %+v: access key %q", tt.role, creds.AccessKeyID)
+			}
+		}
+
+		if len(client.assumed) != 1 {
+			t.Fatalf("role %+v assumed %d times, want once", tt.role, len(client.assumed))
+		}
+		input := client.assumed[0]
+		if aws.ToString(input.RoleArn) != tt.role.ARN || aws.ToString(input.RoleSessionName) != tt.sessionName {
+			t.Errorf("role %+v: assumed %s as %s", tt.role, aws.ToString(input.RoleArn), aws.ToString(input.RoleSessionName))
+		}
+		if tt.role.ExternalID == "" && input.ExternalId != nil || tt.role.ExternalID != "" && aws.ToString(input.ExternalId) != tt.role.ExternalID {
+			t.Errorf("role %+v: external ID %v", tt.role, input.ExternalId)
+		}
+	}
+}
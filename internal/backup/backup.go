@@ -4,59 +4,158 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/unplank/rds-backup-lambda/internal/aws"
 	"github.com/unplank/rds-backup-lambda/internal/config"
 )
 
+// Result is the outcome of a backup run. RunID identifies the run; the
+// snapshot it takes is tagged with it.
 type Result struct {
-	DBIdentifier     string
-	SnapshotID       string
-	BackupTime       string
-	S3Location       string
-	SourceS3Location *string
-	ErrorMessage     string
+	RunID          string   `json:"runId,omitempty"`
+	DBIdentifier   string   `json:"dbIdentifier"`
+	SnapshotID     string   `json:"snapshotId"`
+	BackupTime     string   `json:"backupTime"`
+	ErrorMessage   string   `json:"errorMessage,omitempty"`
+	DryRun         bool     `json:"dryRun,omitempty"`
+	Interrupted    bool     `json:"interrupted,omitempty"`
+	PlannedActions []Action `json:"plannedActions,omitempty"`
+	Report         Report   `json:"report"`
+	State          State    `json:"state"`
 }
 
-func Perform(ctx context.Context, cfg *config.Config, result *Result) error {
-	clients, err := aws.NewClients(ctx, cfg.SourceRegion, cfg.TargetRegion)
+// Options adjust a single backup run. The zero value runs the full pipeline.
+// DryRun makes every read-only call but records the RDS, S3 and KMS calls
+// that would change something in Result.PlannedActions instead of making them.
+// Steps limits the run to the given stages. NoWait returns ErrPending instead
+// of waiting for a snapshot, copy or export to finish. Checkpoint, if set, is
+// called whenever Result.State changes so that the run can be persisted.
+// CheckNotifiers, if set, is called by the preflight stage to check the
+// notification channels and returns their problems.
+type Options struct {
+	SkipExport     bool
+	DryRun         bool
+	NoWait         bool
+	Steps          []Stage
+	Checkpoint     func(result *Result) error
+	CheckNotifiers func(ctx context.Context) []error
+}
+
+// newClients is replaced in tests to run the pipeline against fakes.
+var newClients = aws.NewClients
+
+func Perform(ctx context.Context, cfg *config.Config, db *config.Database, opts Options, result *Result) error {
+	result.DryRun = opts.DryRun
+
+	clients, err := newClients(ctx, cfg)
 	if err != nil {
 		return err
 	}
+	return PerformWith(ctx, clients, cfg, db, opts, result)
+}
 
-	sourceKMSKeyArn, err := aws.GetKMSKeyARN(ctx, cfg.SourceRegion, cfg.KMSKeyID)
-	if err != nil {
-		return fmt.Errorf("failed to get source KMS key ARN: %w", err)
+// PerformWith is Perform with the given AWS clients.
+func PerformWith(ctx context.Context, clients *aws.AWSClients, cfg *config.Config, db *config.Database, opts Options, result *Result) error {
+	result.DryRun = opts.DryRun
+
+	// The source key is only needed for exports in the source region
+	var sourceKMSKeyArn string
+	var err error
+	if db.SourceKMSKeyID != "" {
+		sourceKMSKeyArn, err = aws.ResolveKMSKey(ctx, clients.SourceKMS, cfg.SourceRegion, db.SourceKMSKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to resolve source KMS key: %w", err)
+		}
+	}
+
+	// target is db as seen by the target region, which encrypts and exports
+	// with the key and export role of the vault account if there is one
+	target := db
+	if cfg.Vault.Enabled() {
+		vaultDB := *db
+		vaultDB.TargetKMSKeyID = cfg.Vault.KMSKeyID
+		vaultDB.ExportRoleARN = cfg.Vault.ExportRoleARN
+		target = &vaultDB
 	}
-	targetKMSKeyArn, err := aws.GetKMSKeyARN(ctx, cfg.TargetRegion, cfg.KMSKeyID)
+	targetKMSKeyArn, err := aws.ResolveKMSKey(ctx, clients.TargetKMS, cfg.TargetRegion, target.TargetKMSKeyID)
 	if err != nil {
-		return fmt.Errorf("failed to get target KMS key ARN: %w", err)
+		return fmt.Errorf("failed to resolve target KMS key: %w", err)
+	}
+	// The copy shared with the vault is encrypted with a key of the source
+	// account in the target region
+	var stagingKMSKeyArn string
+	if cfg.Vault.Enabled() {
+		stagingKMSKeyArn, err = aws.ResolveKMSKey(ctx, clients.StagingKMS, cfg.TargetRegion, cfg.Vault.SourceKMSKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to resolve vault source KMS key: %w", err)
+		}
 	}
 
-	sourceSnapshotID, err := CreateAndExportSnapshotInSourceRegion(ctx, clients, cfg, sourceKMSKeyArn, result)
-	if err != nil {
-		return err
+	if opts.runs(StagePreflight) && !result.State.Done(StagePreflight) {
+		result.startStage(StagePreflight, "")
+		if err := preflight(ctx, clients, db, target, sourceKMSKeyArn, targetKMSKeyArn, stagingKMSKeyArn, opts, result); err != nil {
+			result.finishStage(StagePreflight)
+			return err
+		}
+		result.completeStage(StagePreflight, opts)
 	}
-	result.SnapshotID = sourceSnapshotID
 
-	targetSnapshotID, err := CopyAndExportSnapshotToTargetRegion(ctx, clients, cfg, sourceSnapshotID, targetKMSKeyArn, result)
-	if err != nil {
-		return err
+	sourceSnapshotID := result.State.SourceSnapshotID
+	if opts.runs(StageSnapshot) || opts.runs(StageExportSource) {
+		sourceSnapshotID, err = CreateAndExportSnapshotInSourceRegion(ctx, clients, db, sourceKMSKeyArn, opts, result)
+		if err != nil {
+			return err
+		}
 	}
+	result.SnapshotID = sourceSnapshotID
 
-	if err := CleanupOldSnapshots(ctx, clients, cfg); err != nil {
-		log.Printf("Failed to cleanup old snapshots: %v", err)
+	targetSnapshotID := result.State.TargetSnapshotID
+	if opts.runs(StageCopy) || opts.runs(StageExportTarget) {
+		targetSnapshotID, err = CopyAndExportSnapshotToTargetRegion(ctx, clients, target, sourceSnapshotID, targetKMSKeyArn, stagingKMSKeyArn, opts, result)
+		if err != nil {
+			return err
+		}
 	}
 
-	if !cfg.KeepSourceSnapshot {
-		if err := DeleteSnapshot(ctx, clients.SourceRDS, sourceSnapshotID); err != nil {
-			log.Printf("Warning: Failed to delete source snapshot: %v", err)
+	if opts.runs(StageCleanup) && !result.State.Done(StageCleanup) {
+		result.startStage(StageCleanup, "")
+		if err := CleanupOldSnapshots(ctx, clients, db, opts, result); err != nil {
+			result.warn("failed to cleanup old snapshots: %v", err)
+		}
+
+		// The source snapshot is only removed once it has been copied
+		deleteSource := !db.KeepSourceSnapshot && sourceSnapshotID != "" && result.State.Done(StageCopy)
+		if deleteSource && opts.DryRun {
+			result.plan(Action{
+				Service:   "RDS",
+				Operation: newSnapshotClient(clients.SourceRDS, db).operation("DeleteDBSnapshot"),
+				Region:    cfg.SourceRegion,
+				Resource:  sourceSnapshotID,
+				Detail:    "source snapshot is not kept (KEEP_SOURCE_SNAPSHOT=false)",
+			})
+		} else if deleteSource {
+			if err := DeleteSnapshot(ctx, clients.SourceRDS, db, sourceSnapshotID); err != nil {
+				result.warn("failed to delete source snapshot %s: %v", sourceSnapshotID, err)
+			} else {
+				result.deleted(DeletedSnapshot{
+					SnapshotID: sourceSnapshotID,
+					Region:     cfg.SourceRegion,
+					Reason:     "source snapshot is not kept",
+				})
+			}
 		}
+		result.completeStage(StageCleanup, opts)
+	}
+
+	if opts.DryRun {
+		log.Printf("Dry run completed for %s: %d mutating call(s) planned", db.DBIdentifier, len(result.PlannedActions))
+		return nil
 	}
 
-	log.Println("Backup completed successfully")
+	log.Printf("Backup completed successfully for %s", db.DBIdentifier)
 	log.Printf("Snapshot ID: %s", targetSnapshotID)
-	log.Printf("S3 Location: %s", result.S3Location)
+	log.Printf("S3 Location: %s", strings.Join(result.S3Locations(), ", "))
 
 	return nil
 }
@@ -0,0 +1,18 @@
+package backup
+
+import "log"
+
+// Action is a mutating AWS call that a dry run skipped.
+type Action struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Region    string `json:"region"`
+	Resource  string `json:"resource"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+func (r *Result) plan(action Action) {
+	log.Printf("[dry-run] Would call %s %s in %s on %s: %s",
+		action.Service, action.Operation, action.Region, action.Resource, action.Detail)
+	r.PlannedActions = append(r.PlannedActions, action)
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+type SnapshotInfo struct {
+	ID      string    `json:"id"`
+	Region  string    `json:"region"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+	SizeGB  int32     `json:"sizeGb"`
+	Engine  string    `json:"engine,omitempty"`
+}
+
+type ExportInfo struct {
+	ID       string     `json:"id"`
+	Region   string     `json:"region"`
+	Status   string     `json:"status"`
+	Source   string     `json:"source"`
+	Location string     `json:"location"`
+	Progress int32      `json:"progress"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Failure  string     `json:"failure,omitempty"`
+}
+
+// ListSnapshots returns the backup snapshots of db in the source region and
+// their copies in the target region, newest first.
+func ListSnapshots(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database) ([]SnapshotInfo, error) {
+	var infos []SnapshotInfo
+	for _, side := range []struct {
+		client awsinternal.RDSAPI
+		region string
+		prefix string
+	}{
+		{clients.SourceRDS, clients.SourceRegion, "backup-" + db.DBIdentifier + "-"},
+		{clients.TargetRDS, clients.TargetRegion, "copy-backup-" + db.DBIdentifier + "-"},
+	} {
+		snapshots, err := listBackupSnapshots(ctx, newSnapshotClient(side.client, db), side.prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", side.region, err)
+		}
+		for _, snapshot := range snapshots {
+			info := SnapshotInfo{
+				ID:     snapshot.ID,
+				Region: side.region,
+				Status: snapshot.Status,
+				SizeGB: snapshot.SizeGB,
+				Engine: snapshot.Engine,
+			}
+			if snapshot.Created != nil {
+				info.Created = *snapshot.Created
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos, nil
+}
+
+// ListExports returns the S3 export tasks of db's snapshots in both regions.
+func ListExports(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database) ([]ExportInfo, error) {
+	var infos []ExportInfo
+	for _, side := range []struct {
+		client awsinternal.RDSAPI
+		region string
+		prefix string
+	}{
+		{clients.SourceRDS, clients.SourceRegion, "export-backup-" + db.DBIdentifier + "-"},
+		{clients.TargetRDS, clients.TargetRegion, "export-copy-backup-" + db.DBIdentifier + "-"},
+	} {
+		paginator := rds.NewDescribeExportTasksPaginator(side.client, &rds.DescribeExportTasksInput{})
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to list export tasks: %w", side.region, err)
+			}
+
+			for _, task := range output.ExportTasks {
+				id := aws.ToString(task.ExportTaskIdentifier)
+				if !isBackupSnapshot(id, side.prefix) {
+					continue
+				}
+
+				info := ExportInfo{
+					ID:       id,
+					Region:   side.region,
+					Status:   aws.ToString(task.Status),
+					Source:   aws.ToString(task.SourceArn),
+					Location: "s3://" + aws.ToString(task.S3Bucket) + "/" + exportPrefix(strings.Trim(aws.ToString(task.S3Prefix), "/"), id),
+					Progress: aws.ToInt32(task.PercentProgress),
+					Started:  task.TaskStartTime,
+					Finished: task.TaskEndTime,
+					Failure:  aws.ToString(task.FailureCause),
+				}
+				infos = append(infos, info)
+			}
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].ID > infos[j].ID
+	})
+	return infos, nil
+}
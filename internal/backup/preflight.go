@@ -0,0 +1,252 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// exportEngines are the engines whose snapshots can be exported to S3.
+var exportEngines = []string{"postgres", "mysql", "mariadb", "aurora-postgresql", "aurora-mysql", "aurora"}
+
+// Problem is a preflight check that failed.
+type Problem struct {
+	Check    string `json:"check"`
+	Resource string `json:"resource"`
+	Region   string `json:"region,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	subject := p.Check
+	if p.Resource != "" {
+		subject += " " + p.Resource
+	}
+	if p.Region != "" {
+		subject += " (" + p.Region + ")"
+	}
+	return subject + ": " + p.Message
+}
+
+// PreflightError is returned by Perform when a preflight check failed. No
+// snapshot has been taken.
+type PreflightError struct {
+	Problems []Problem
+}
+
+func (e *PreflightError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.String()
+	}
+	return fmt.Sprintf("preflight failed with %d problem(s): %s", len(e.Problems), strings.Join(messages, "; "))
+}
+
+// preflight checks up front that the resources the run uses exist and are
+// usable: the credentials of both regions, the DB instance or cluster, the buckets and export role of the
+// exports that run, the KMS keys and the notification channels. target is
+// db as seen by the target region and stagingKMSKeyArn, with a vault, the
+// key of the copy shared with the vault. The checks
+// only read, so they run in dry runs too. A check the credentials are not
+// allowed to make is reported as a warning rather than a problem.
+func preflight(ctx context.Context, clients *awsinternal.AWSClients, db, target *config.Database, sourceKMSKeyArn, targetKMSKeyArn, stagingKMSKeyArn string, opts Options, result *Result) error {
+	exportSource := db.StoreToSourceS3 && !opts.SkipExport && opts.runs(StageExportSource)
+	exportTarget := !opts.SkipExport && opts.runs(StageExportTarget)
+
+	var problems []Problem
+	check := func(name, resource, region string, err error) {
+		if err == nil {
+			return
+		}
+		if accessDenied(err) {
+			result.warn("preflight could not check %s %s: %v", name, resource, err)
+			return
+		}
+		problems = append(problems, Problem{Check: name, Resource: resource, Region: region, Message: err.Error()})
+	}
+
+	// A role that cannot be assumed fails every other check, so it is the
+	// only problem reported. GetCallerIdentity needs no permission: an
+	// access denied error is the AssumeRole of the credentials failing.
+	credentials := func(client awsinternal.STSAPI, region, account string) {
+		if err := checkCredentials(ctx, client, account); err != nil {
+			problems = append(problems, Problem{Check: "credentials", Region: region, Message: err.Error()})
+		}
+	}
+	credentials(clients.SourceSTS, clients.SourceRegion, "")
+	credentials(clients.TargetSTS, clients.TargetRegion, clients.TargetAccount)
+	if len(problems) > 0 {
+		result.Report.Preflight = problems
+		return &PreflightError{Problems: problems}
+	}
+
+	if opts.runs(StageSnapshot) {
+		check("database", db.DBIdentifier, clients.SourceRegion,
+			checkDatabase(ctx, newSnapshotClient(clients.SourceRDS, db), db.DBIdentifier, exportSource || exportTarget))
+	}
+	if exportSource {
+		check("bucket", db.SourceBucket, clients.SourceRegion, checkBucket(ctx, clients.SourceS3, db.SourceBucket, clients.SourceRegion))
+		if sourceKMSKeyArn == "" {
+			check("kms-key", "", clients.SourceRegion, fmt.Errorf("no source KMS key is configured for exports in the source region"))
+		} else {
+			check("kms-key", sourceKMSKeyArn, clients.SourceRegion, awsinternal.VerifyKMSKey(ctx, clients.SourceKMS, sourceKMSKeyArn, db.ExportRoleARN))
+		}
+		check("export-role", db.ExportRoleARN, "", checkExportRole(ctx, clients.SourceIAM, db.ExportRoleARN))
+	}
+	if exportTarget {
+		check("bucket", target.TargetBucket, clients.TargetRegion, checkBucket(ctx, clients.TargetS3, target.TargetBucket, clients.TargetRegion))
+		if !exportSource || target.ExportRoleARN != db.ExportRoleARN {
+			check("export-role", target.ExportRoleARN, "", checkExportRole(ctx, clients.TargetIAM, target.ExportRoleARN))
+		}
+	}
+	copying := opts.runs(StageCopy) && !result.State.Done(StageCopy)
+	if exportTarget || copying {
+		var exportRoleArn string
+		if exportTarget {
+			exportRoleArn = target.ExportRoleARN
+		}
+		check("kms-key", targetKMSKeyArn, clients.TargetRegion, awsinternal.VerifyKMSKey(ctx, clients.TargetKMS, targetKMSKeyArn, exportRoleArn))
+	}
+	if copying && clients.TargetAccount != "" {
+		check("kms-key", stagingKMSKeyArn, clients.TargetRegion,
+			awsinternal.VerifySharedKMSKey(ctx, clients.StagingKMS, stagingKMSKeyArn, clients.TargetAccount))
+	}
+	if opts.CheckNotifiers != nil {
+		for _, err := range opts.CheckNotifiers(ctx) {
+			check("notification", "", "", err)
+		}
+	}
+
+	result.Report.Preflight = problems
+	if len(problems) > 0 {
+		return &PreflightError{Problems: problems}
+	}
+	return nil
+}
+
+// checkCredentials checks that the credentials of client work and, if
+// account is set, act in account.
+func checkCredentials(ctx context.Context, client awsinternal.STSAPI, account string) error {
+	identity, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return err
+	}
+	if account != "" && aws.ToString(identity.Account) != account {
+		return fmt.Errorf("the credentials act in account %s as %s, not in the vault account %s",
+			aws.ToString(identity.Account), aws.ToString(identity.Arn), account)
+	}
+	return nil
+}
+
+// checkDatabase checks that the DB instance or cluster exists and, if it is
+// to be exported, that its engine supports exports.
+func checkDatabase(ctx context.Context, c snapshotClient, id string, export bool) error {
+	var engine string
+	if c.cluster {
+		output, err := c.rds.DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{DBClusterIdentifier: aws.String(id)})
+		var notFound *types.DBClusterNotFoundFault
+		if errors.As(err, &notFound) || (err == nil && len(output.DBClusters) == 0) {
+			return fmt.Errorf("DB cluster not found")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe DB cluster: %w", err)
+		}
+		engine = aws.ToString(output.DBClusters[0].Engine)
+	} else {
+		output, err := c.rds.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(id)})
+		var notFound *types.DBInstanceNotFoundFault
+		if errors.As(err, &notFound) || (err == nil && len(output.DBInstances) == 0) {
+			return fmt.Errorf("DB instance not found")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe DB instance: %w", err)
+		}
+		engine = aws.ToString(output.DBInstances[0].Engine)
+	}
+
+	if export && !slices.Contains(exportEngines, engine) {
+		return fmt.Errorf("engine %s does not support exports to S3", engine)
+	}
+	return nil
+}
+
+// checkBucket checks that the bucket exists and is in region.
+func checkBucket(ctx context.Context, s3Client awsinternal.S3API, bucket, region string) error {
+	output, err := s3Client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if errorCode(err) == "NoSuchBucket" {
+			return fmt.Errorf("bucket does not exist")
+		}
+		return fmt.Errorf("failed to get bucket location: %w", err)
+	}
+
+	// Buckets in us-east-1 have no location constraint and old buckets in
+	// eu-west-1 report EU.
+	location := string(output.LocationConstraint)
+	switch location {
+	case "":
+		location = "us-east-1"
+	case "EU":
+		location = "eu-west-1"
+	}
+	if location != region {
+		return fmt.Errorf("bucket is in %s, not %s", location, region)
+	}
+	return nil
+}
+
+// checkExportRole checks that the export role exists and that its trust
+// policy lets the RDS export service assume it.
+func checkExportRole(ctx context.Context, iamClient awsinternal.IAMAPI, roleArn string) error {
+	name := roleArn[strings.LastIndex(roleArn, "/")+1:]
+	output, err := iamClient.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		if errorCode(err) == "NoSuchEntity" {
+			return fmt.Errorf("role does not exist")
+		}
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+
+	document, err := url.QueryUnescape(aws.ToString(output.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return fmt.Errorf("invalid trust policy: %w", err)
+	}
+	var policy awsinternal.Policy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return fmt.Errorf("invalid trust policy: %w", err)
+	}
+	for _, statement := range policy.Statement {
+		if statement.Allows("sts:AssumeRole") && slices.Contains(statement.Principals("Service"), awsinternal.ExportService) {
+			return nil
+		}
+	}
+	return fmt.Errorf("trust policy does not allow %s to assume the role", awsinternal.ExportService)
+}
+
+// errorCode returns the AWS error code of err, or "" if it has none.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func accessDenied(err error) bool {
+	code := errorCode(err)
+	return strings.Contains(code, "AccessDenied") || code == "AuthorizationError" || code == "UnauthorizedOperation"
+}
@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/smithy-go"
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+func TestPreflightKMSKeyAccess(t *testing.T) {
+	const otherAccount = "arn:aws:iam::999999999999:root"
+	allowRDS := `{"Effect":"Allow","Principal":{"Service":"rds.amazonaws.com"},"Action":["kms:DescribeKey","kms:CreateGrant"],"Resource":"*"}`
+
+	tests := []struct {
+		name       string
+		key        func(kms *fake.KMS, roleArn string)
+		skipExport bool
+		problem    string
+	}{
+		{
+			name: "default key policy",
+			key:  func(kms *fake.KMS, roleArn string) { kms.AddKey("target-key", true) },
+		},
+		{
+			name: "RDS and the export role by policy and grant",
+			key: func(kms *fake.KMS, roleArn string) {
+				kms.AddKey("target-key", true)
+				kms.SetKeyPolicy("target-key", `{"Statement":[`+allowRDS+`]}`)
+				kms.AddGrant("target-key", roleArn,
+					kmstypes.GrantOperationEncrypt, kmstypes.GrantOperationDecrypt,
+					kmstypes.GrantOperationReEncryptFrom, kmstypes.GrantOperationReEncryptTo,
+					kmstypes.GrantOperationGenerateDataKey, kmstypes.GrantOperationGenerateDataKeyWithoutPlaintext,
+					kmstypes.GrantOperationCreateGrant, kmstypes.GrantOperationRetireGrant, kmstypes.GrantOperationDescribeKey)
+			},
+		},
+		{
+			name: "export role by wildcard actions",
+			key: func(kms *fake.KMS, roleArn string) {
+				kms.AddKey("target-key", true)
+				kms.SetKeyPolicy("target-key", `{"Statement":[`+allowRDS+`,
+					{"Effect":"Allow","Principal":{"AWS":"`+roleArn+`"},"Action":["kms:Encrypt","kms:Decrypt","kms:ReEncrypt*","kms:GenerateDataKey*","kms:CreateGrant","kms:RetireGrant","kms:DescribeKey"],"Resource":"*"}]}`)
+			},
+		},
+		{
+			name: "key policy of another account",
+			key: func(kms *fake.KMS, roleArn string) {
+				kms.AddKey("target-key", true)
+				kms.SetKeyPolicy("target-key", `{"Statement":[{"Effect":"Allow","Principal":{"AWS":"`+otherAccount+`"},"Action":"kms:*","Resource":"*"}]}`)
+			},
+			problem: "kms-key arn:aws:kms:us-west-2:123456789012:key/target-key (us-west-2): neither the key policy nor a grant allows RDS to use the key for kms:DescribeKey, kms:CreateGrant (expected for account 123456789012 or rds.amazonaws.com)",
+		},
+		{
+			name: "export role not allowed",
+			key: func(kms *fake.KMS, roleArn string) {
+				kms.AddKey("target-key", true)
+				kms.SetKeyPolicy("target-key", `{"Statement":[`+allowRDS+`]}`)
+			},
+			problem: "kms-key arn:aws:kms:us-west-2:123456789012:key/target-key (us-west-2): neither the key policy nor a grant allows the export role arn:aws:iam::123456789012:role/rds-export to use the key for kms:Encrypt, kms:Decrypt",
+		},
+		{
+			name: "export role not needed without exports",
+			key: func(kms *fake.KMS, roleArn string) {
+				kms.AddKey("target-key", true)
+				kms.SetKeyPolicy("target-key", `{"Statement":[`+allowRDS+`]}`)
+			},
+			skipExport: true,
+		},
+		{
+			name:       "AWS managed key without exports",
+			key:        func(kms *fake.KMS, roleArn string) { kms.AddManagedKey("target-key") },
+			skipExport: true,
+		},
+		{
+			name:    "AWS managed key with exports",
+			key:     func(kms *fake.KMS, roleArn string) { kms.AddManagedKey("target-key") },
+			problem: "snapshot exports require a customer managed key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			cloud.SourceRDS.AddInstance("orders", "available")
+			cloud.TargetS3.AddBucket("target-backups")
+			roleArn := cloud.IAM.AddRole("rds-export", "export.rds.amazonaws.com")
+			tt.key(cloud.TargetKMS, roleArn)
+
+			db := &config.Database{DBIdentifier: "orders", Mode: config.ModeInstance, TargetBucket: "target-backups", ExportRoleARN: roleArn}
+			result := &Result{DBIdentifier: "orders"}
+			keyArn := "arn:aws:kms:us-west-2:" + fake.Account + ":key/target-key"
+			err := preflight(context.Background(), cloud.Clients(), db, db, "", keyArn, "", Options{SkipExport: tt.skipExport}, result)
+
+			var problems []string
+			for _, problem := range result.Report.Preflight {
+				problems = append(problems, problem.String())
+			}
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("preflight: %v", err)
+				}
+				return
+			}
+			if !slices.ContainsFunc(problems, func(problem string) bool { return strings.Contains(problem, tt.problem) }) {
+				t.Errorf("problems %q, want %q", problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestPreflightCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(cloud *fake.Cloud)
+		problem string
+	}{
+		{name: "working credentials", setup: func(cloud *fake.Cloud) {}},
+		{name: "vault credentials", setup: func(cloud *fake.Cloud) { cloud.UseVault("210987654321") }},
+		{
+			name: "role that cannot be assumed",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceSTS.FailNext("GetCallerIdentity", &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform sts:AssumeRole"})
+			},
+			problem: "credentials (us-east-1): api error AccessDenied: not authorized to perform sts:AssumeRole",
+		},
+		{
+			name: "vault role of another account",
+			setup: func(cloud *fake.Cloud) {
+				cloud.UseVault("210987654321")
+				cloud.TargetSTS.Account = fake.Account
+			},
+			problem: "credentials (us-west-2): the credentials act in account 123456789012 as arn:aws:sts::123456789012:assumed-role/rds-backup/rds-backup, not in the vault account 210987654321",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			cloud.SourceRDS.AddInstance("orders", "available")
+			tt.setup(cloud)
+
+			db := &config.Database{DBIdentifier: "orders", Mode: config.ModeInstance}
+			result := &Result{DBIdentifier: "orders"}
+			err := preflight(context.Background(), cloud.Clients(), db, db, "", "", "", Options{Steps: []Stage{StageSnapshot}}, result)
+
+			var problems []string
+			for _, problem := range result.Report.Preflight {
+				problems = append(problems, problem.String())
+			}
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("preflight: %v", err)
+				}
+				return
+			}
+			// No other check runs with credentials that do not work
+			if !slices.Equal(problems, []string{tt.problem}) {
+				t.Errorf("problems %q, want %q", problems, tt.problem)
+			}
+			if calls := cloud.SourceRDS.Calls(); len(calls) != 0 {
+				t.Errorf("RDS calls %q after the credentials check failed", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+)
+
+// Report is the structured account of a backup: how long each stage took,
+// what was snapshotted and exported, what retention deleted and anything
+// that went wrong without failing the backup. It is part of the result, so
+// it is persisted and resumed with it.
+type Report struct {
+	Snapshot *SnapshotReport   `json:"snapshot,omitempty"`
+	Stages   []StageReport     `json:"stages,omitempty"`
+	Exports  []ExportReport    `json:"exports,omitempty"`
+	Deleted  []DeletedSnapshot `json:"deleted,omitempty"`
+	Warnings []string          `json:"warnings,omitempty"`
+	// Preflight lists the problems found by the preflight checks.
+	Preflight []Problem `json:"preflight,omitempty"`
+}
+
+// SnapshotReport describes the source snapshot.
+type SnapshotReport struct {
+	Engine        string `json:"engine,omitempty"`
+	EngineVersion string `json:"engineVersion,omitempty"`
+	SizeGB        int32  `json:"sizeGb,omitempty"`
+}
+
+// StageReport times one stage. A stage resumed after a restart counts from
+// when it first started. Finished is nil while the stage is running.
+type StageReport struct {
+	Stage    Stage      `json:"stage"`
+	Region   string     `json:"region,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Duration returns how long the stage took, or has been running.
+func (s StageReport) Duration() time.Duration {
+	finished := time.Now()
+	if s.Finished != nil {
+		finished = *s.Finished
+	}
+	return finished.Sub(s.Started).Round(time.Second)
+}
+
+// ExportReport is one export task of a snapshot to S3. Prefix is the key
+// prefix of the exported files in Bucket. ExportOnly lists what the export
+// was limited to, if anything. Verification is nil if the files could not be
+// listed.
+type ExportReport struct {
+	Stage        Stage               `json:"stage"`
+	Region       string              `json:"region"`
+	TaskID       string              `json:"taskId"`
+	Bucket       string              `json:"bucket"`
+	Prefix       string              `json:"prefix"`
+	ExportOnly   []string            `json:"exportOnly,omitempty"`
+	Verification *ExportVerification `json:"verification,omitempty"`
+}
+
+// Location returns the S3 URL of the exported files.
+func (e ExportReport) Location() string {
+	return fmt.Sprintf("s3://%s/%s", e.Bucket, e.Prefix)
+}
+
+// DeletedSnapshot is a snapshot removed by the retention policy or because
+// the source snapshot is not kept.
+type DeletedSnapshot struct {
+	SnapshotID string     `json:"snapshotId"`
+	Region     string     `json:"region"`
+	Created    *time.Time `json:"created,omitempty"`
+	Reason     string     `json:"reason"`
+}
+
+// startStage records the start of a stage unless it was started before.
+func (r *Result) startStage(stage Stage, region string) {
+	for _, s := range r.Report.Stages {
+		if s.Stage == stage {
+			return
+		}
+	}
+	r.Report.Stages = append(r.Report.Stages, StageReport{Stage: stage, Region: region, Started: time.Now()})
+}
+
+func (r *Result) finishStage(stage Stage) {
+	for i := range r.Report.Stages {
+		s := &r.Report.Stages[i]
+		if s.Stage == stage && s.Finished == nil {
+			now := time.Now()
+			s.Finished = &now
+			if !r.DryRun {
+				metrics.StageDuration.WithLabelValues(r.DBIdentifier, string(stage)).Observe(now.Sub(s.Started).Seconds())
+			}
+		}
+	}
+}
+
+// deleted records a deleted snapshot.
+func (r *Result) deleted(snapshot DeletedSnapshot) {
+	r.Report.Deleted = append(r.Report.Deleted, snapshot)
+	metrics.SnapshotsDeleted.WithLabelValues(r.DBIdentifier, snapshot.Region).Inc()
+}
+
+// warn logs a problem that does not fail the backup and adds it to the
+// report.
+func (r *Result) warn(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", message)
+	r.Report.Warnings = append(r.Report.Warnings, message)
+}
+
+// S3Locations returns the S3 URLs of the exports of the backup.
+func (r *Result) S3Locations() []string {
+	locations := make([]string, 0, len(r.Report.Exports))
+	for _, export := range r.Report.Exports {
+		locations = append(locations, export.Location())
+	}
+	return locations
+}
+
+// WriteReport writes the result, report included, as JSON to dir/name.json.
+func WriteReport(dir, name string, result *Result) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	path := filepath.Join(dir, name+".json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
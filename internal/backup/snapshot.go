@@ -2,6 +2,7 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -9,312 +10,745 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
 	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
 	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+	"github.com/unplank/rds-backup-lambda/internal/retention"
 )
 
-func CreateAndExportSnapshotInSourceRegion(ctx context.Context, clients *awsinternal.AWSClients, config *config.Config, kmsKeyArn string, result *Result) (string, error) {
-    maxRetries := 12 // Will try for up to 1 hour (12 * 5 minutes)
-    var lastErr error
-    
-    for i := 0; i < maxRetries; i++ {
-        // Check instance state
-        instance, err := clients.SourceRDS.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{
-            DBInstanceIdentifier: aws.String(config.DBIdentifier),
-        })
-        if err != nil {
-            lastErr = fmt.Errorf("failed to describe DB instance: %w", err)
-            log.Printf("Error checking instance state: %v. Retry %d/%d", err, i+1, maxRetries)
-            time.Sleep(5 * time.Minute)
-            continue
-        }
-
-        if len(instance.DBInstances) == 0 {
-            return "", fmt.Errorf("DB instance not found: %s", config.DBIdentifier)
-        }
-
-        status := *instance.DBInstances[0].DBInstanceStatus
-
-        // If backing up, check for existing snapshot from today
-        if status == "backing-up" {
-            snapshots, err := clients.SourceRDS.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{
-                DBInstanceIdentifier: aws.String(config.DBIdentifier),
-                SnapshotType:        aws.String("manual"),
-            })
-            if err != nil {
-                lastErr = fmt.Errorf("failed to describe snapshots: %w", err)
-                log.Printf("Error checking snapshots: %v. Retry %d/%d", err, i+1, maxRetries)
-                time.Sleep(5 * time.Minute)
-                continue
-            }
-
-            today := time.Now().Format("2006-01-02")
-            for _, snap := range snapshots.DBSnapshots {
-                if strings.HasPrefix(*snap.DBSnapshotIdentifier, "backup-"+config.DBIdentifier+"-"+today) {
-                    log.Printf("Found existing snapshot from today: %s", *snap.DBSnapshotIdentifier)
-                    
-                    // Wait for the existing snapshot to be available
-                    waiter := rds.NewDBSnapshotAvailableWaiter(clients.SourceRDS)
-                    if err := waiter.Wait(ctx, &rds.DescribeDBSnapshotsInput{
-                        DBSnapshotIdentifier: snap.DBSnapshotIdentifier,
-                    }, 2*time.Hour); err != nil {
-                        lastErr = fmt.Errorf("error waiting for existing snapshot: %w", err)
-                        continue
-                    }
-
-                    // Export the existing snapshot if needed
-                    if config.StoreToSourceS3 {
-                        exportTask, err := exportSnapshotToS3(ctx, clients.SourceS3, clients.SourceRDS, 
-                            *snap.DBSnapshotIdentifier, config.SourceBucket, kmsKeyArn, config.ExportRoleARN)
-                        if err != nil {
-                            return "", err
-                        }
-                        result.S3Location = fmt.Sprintf("s3://%s/%s", config.SourceBucket, exportTask)
-                    }
-
-                    return *snap.DBSnapshotIdentifier, nil
-                }
-            }
-        }
-
-        // Proceed only if instance is available
-        if status == "available" {
-            snapshotID := fmt.Sprintf("backup-%s-%s", config.DBIdentifier, time.Now().Format("2006-01-02-15-04-05"))
-            log.Printf("Creating snapshot: %s", snapshotID)
-            
-            _, err = clients.SourceRDS.CreateDBSnapshot(ctx, &rds.CreateDBSnapshotInput{
-                DBInstanceIdentifier: aws.String(config.DBIdentifier),
-                DBSnapshotIdentifier: aws.String(snapshotID),
-            })
-            if err != nil {
-                lastErr = fmt.Errorf("failed to create snapshot: %w", err)
-                log.Printf("Error creating snapshot: %v. Retry %d/%d", err, i+1, maxRetries)
-                time.Sleep(5 * time.Minute)
-                continue
-            }
-
-            // Wait for the new snapshot to be available
-            waiter := rds.NewDBSnapshotAvailableWaiter(clients.SourceRDS)
-            if err := waiter.Wait(ctx, &rds.DescribeDBSnapshotsInput{
-                DBSnapshotIdentifier: aws.String(snapshotID),
-            }, 2*time.Hour); err != nil {
-                lastErr = fmt.Errorf("error waiting for snapshot: %w", err)
-                log.Printf("Error waiting for snapshot: %v. Retry %d/%d", err, i+1, maxRetries)
-                time.Sleep(5 * time.Minute)
-                continue
-            }
-
-            log.Printf("Exporting snapshot to S3")
-            if config.StoreToSourceS3 {
-                exportTask, err := exportSnapshotToS3(ctx, clients.SourceS3, clients.SourceRDS, 
-                    snapshotID, config.SourceBucket, kmsKeyArn, config.ExportRoleARN)
-                if err != nil {
-                    return "", err
-                }
-                result.S3Location = fmt.Sprintf("s3://%s/%s", config.SourceBucket, exportTask)
-            }
-
-            return snapshotID, nil
-        }
-
-        log.Printf("DB instance %s is in %s state. Waiting 5 minutes before retry (%d/%d)",
-            config.DBIdentifier, status, i+1, maxRetries)
-        time.Sleep(5 * time.Minute)
-    }
-
-    if lastErr != nil {
-        return "", fmt.Errorf("max retries reached with last error: %w", lastErr)
-    }
-    return "", fmt.Errorf("timed out waiting for DB instance to become available after %d retries", maxRetries)
+const snapshotTimeFormat = "2006-01-02-15-04-05"
+
+// Polling intervals. Tests shorten them to run against the in-memory fakes.
+var (
+	pollInterval   = 5 * time.Minute
+	waiterMinDelay = 30 * time.Second
+	waiterMaxDelay = 2 * time.Minute
+)
+
+// sleep waits for d or until ctx is done, in which case it returns the
+// context's error.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("interrupted while waiting: %w", ctx.Err())
+	}
 }
 
-func CopyAndExportSnapshotToTargetRegion(ctx context.Context, clients *awsinternal.AWSClients, config *config.Config, sourceSnapshotID string, targetKMSKeyArn string, result *Result) (string, error) {
-	targetSnapshotID, err := copySnapshotToTargetRegion(ctx, clients.SourceRDS, clients.TargetRDS, sourceSnapshotID, targetKMSKeyArn)
-	if err != nil {
-		return "", err
+func CreateAndExportSnapshotInSourceRegion(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, kmsKeyArn string, opts Options, result *Result) (string, error) {
+	state := &result.State
+	snapshots := newSnapshotClient(clients.SourceRDS, db)
+
+	if opts.runs(StageSnapshot) && !state.Done(StageSnapshot) {
+		result.startStage(StageSnapshot, clients.SourceRegion)
+		if state.SourceSnapshotID == "" {
+			if _, err := createSourceSnapshot(ctx, clients, db, opts, result); err != nil {
+				return "", err
+			}
+		} else if !opts.DryRun {
+			log.Printf("Resuming with snapshot %s", state.SourceSnapshotID)
+			if err := waitForSnapshot(ctx, snapshots, state.SourceSnapshotID, opts); err != nil {
+				return "", fmt.Errorf("error waiting for snapshot: %w", err)
+			}
+		}
+		if !opts.DryRun {
+			recordSnapshot(ctx, snapshots, state.SourceSnapshotID, result)
+		}
+		result.completeStage(StageSnapshot, opts)
 	}
 
-	exportTask, err := exportSnapshotToS3(ctx, clients.TargetS3, clients.TargetRDS, targetSnapshotID, config.TargetBucket, targetKMSKeyArn, config.ExportRoleARN)
+	if state.SourceSnapshotID == "" {
+		return "", fmt.Errorf("no source snapshot to work on, run the %s step first", StageSnapshot)
+	}
+	result.SnapshotID = state.SourceSnapshotID
+
+	if db.StoreToSourceS3 && !opts.SkipExport && opts.runs(StageExportSource) && !state.Done(StageExportSource) {
+		log.Printf("Exporting snapshot to S3")
+		result.startStage(StageExportSource, clients.SourceRegion)
+		prefix := s3Prefix(db.SourceS3Prefix, db.DBIdentifier, clients.SourceRegion, state.SourceSnapshotID)
+		exportTask, prefix, err := exportSnapshotToS3(ctx, snapshots, clients.SourceRegion,
+			state.SourceSnapshotID, db.SourceBucket, prefix, kmsKeyArn, db.ExportRoleARN, db.SourceExportOnly, opts, result)
+		if err != nil {
+			return "", err
+		}
+		recordExport(ctx, clients.SourceS3, ExportReport{
+			Stage:      StageExportSource,
+			Region:     clients.SourceRegion,
+			TaskID:     exportTask,
+			Bucket:     db.SourceBucket,
+			Prefix:     exportPrefix(prefix, exportTask),
+			ExportOnly: db.SourceExportOnly,
+		}, opts, result)
+		result.completeStage(StageExportSource, opts)
+	}
+
+	return state.SourceSnapshotID, nil
+}
+
+// recordSnapshot adds the engine and size of the source snapshot to the
+// report.
+func recordSnapshot(ctx context.Context, snapshots snapshotClient, snapshotID string, result *Result) {
+	snapshot, err := snapshots.describe(ctx, snapshotID)
 	if err != nil {
-		return "", err
+		result.warn("failed to describe snapshot %s for the report: %v", snapshotID, err)
+		return
+	}
+	if snapshot == nil {
+		return
+	}
+	result.Report.Snapshot = &SnapshotReport{
+		Engine:        snapshot.Engine,
+		EngineVersion: snapshot.Version,
+		SizeGB:        snapshot.SizeGB,
 	}
+}
 
-	result.S3Location += fmt.Sprintf("\ns3://%s/%s", config.TargetBucket, exportTask)
-	return targetSnapshotID, nil
+// createSourceSnapshot waits for the DB instance or cluster to be available,
+// takes a snapshot and waits for it. If it is already backing up, the
+// snapshot tagged with the ID of this run is reused; a snapshot of another
+// run is not. The snapshot ID is recorded in the result state as soon
+// as it is known.
+func createSourceSnapshot(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, opts Options, result *Result) (string, error) {
+	maxRetries := 12 // Will try for up to 1 hour (12 * pollInterval)
+	var lastErr error
+	snapshots := newSnapshotClient(clients.SourceRDS, db)
+
+	for i := 0; i < maxRetries; i++ {
+		if i > 0 {
+			metrics.SnapshotRetries.WithLabelValues(db.DBIdentifier).Inc()
+		}
+
+		// Check instance state
+		status, err := snapshots.sourceStatus(ctx, db.DBIdentifier)
+		if err != nil {
+			lastErr = err
+			if opts.NoWait {
+				return "", lastErr
+			}
+			log.Printf("Error checking instance state: %v. Retry %d/%d", err, i+1, maxRetries)
+			if err := sleep(ctx, pollInterval); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if status == "" {
+			return "", fmt.Errorf("%s not found: %s", snapshots.kind(), db.DBIdentifier)
+		}
+
+		// If backing up, it may be taking the snapshot this run started
+		// before it could save it
+		if status == "backing-up" && result.RunID != "" {
+			existing, err := snapshots.list(ctx, db.DBIdentifier)
+			if err != nil {
+				lastErr = fmt.Errorf("failed to describe snapshots: %w", err)
+				if opts.NoWait {
+					return "", lastErr
+				}
+				log.Printf("Error checking snapshots: %v. Retry %d/%d", err, i+1, maxRetries)
+				if err := sleep(ctx, pollInterval); err != nil {
+					return "", err
+				}
+				continue
+			}
+
+			for _, snap := range existing {
+				if snap.RunID == result.RunID {
+					log.Printf("Found existing snapshot of run %s: %s", result.RunID, snap.ID)
+					result.State.SourceSnapshotID = snap.ID
+					opts.checkpoint(result)
+
+					// Wait for the existing snapshot to be available
+					if !opts.DryRun {
+						if err := waitForSnapshot(ctx, snapshots, snap.ID, opts); err != nil {
+							if errors.Is(err, ErrPending) {
+								return "", err
+							}
+							lastErr = fmt.Errorf("error waiting for existing snapshot: %w", err)
+							continue
+						}
+					}
+
+					return snap.ID, nil
+				}
+			}
+		}
+
+		// A dry run does not wait for the instance, it reports what would be
+		// created once the instance is available
+		if status != "available" && opts.DryRun {
+			log.Printf("[dry-run] %s %s is in %s state; a real run waits for it to become available",
+				snapshots.kind(), db.DBIdentifier, status)
+			status = "available"
+		}
+
+		// Proceed only if instance is available
+		if status == "available" {
+			snapshotID := fmt.Sprintf("backup-%s-%s", db.DBIdentifier, time.Now().Format(snapshotTimeFormat))
+
+			if opts.DryRun {
+				result.plan(Action{
+					Service:   "RDS",
+					Operation: snapshots.operation("CreateDBSnapshot"),
+					Region:    clients.SourceRegion,
+					Resource:  snapshotID,
+					Detail:    "snapshot of " + snapshots.kind() + " " + db.DBIdentifier,
+				})
+				result.State.SourceSnapshotID = snapshotID
+				return snapshotID, nil
+			}
+
+			log.Printf("Creating snapshot: %s", snapshotID)
+
+			if err := snapshots.create(ctx, db.DBIdentifier, snapshotID, result.RunID); err != nil {
+				lastErr = fmt.Errorf("failed to create snapshot: %w", err)
+				if opts.NoWait {
+					return "", lastErr
+				}
+				log.Printf("Error creating snapshot: %v. Retry %d/%d", err, i+1, maxRetries)
+				if err := sleep(ctx, pollInterval); err != nil {
+					return "", err
+				}
+				continue
+			}
+			result.State.SourceSnapshotID = snapshotID
+			opts.checkpoint(result)
+
+			// Wait for the new snapshot to be available
+			if err := waitForSnapshot(ctx, snapshots, snapshotID, opts); err != nil {
+				if errors.Is(err, ErrPending) {
+					return "", err
+				}
+				lastErr = fmt.Errorf("error waiting for snapshot: %w", err)
+				log.Printf("Error waiting for snapshot: %v. Retry %d/%d", err, i+1, maxRetries)
+				if err := sleep(ctx, pollInterval); err != nil {
+					return "", err
+				}
+				continue
+			}
+
+			return snapshotID, nil
+		}
+
+		if opts.NoWait {
+			return "", fmt.Errorf("%s %s is in %s state: %w", snapshots.kind(), db.DBIdentifier, status, ErrPending)
+		}
+		log.Printf("%s %s is in %s state. Waiting %s before retry (%d/%d)",
+			snapshots.kind(), db.DBIdentifier, status, pollInterval, i+1, maxRetries)
+		if err := sleep(ctx, pollInterval); err != nil {
+			return "", err
+		}
+	}
+
+	if lastErr != nil {
+		return "", fmt.Errorf("max retries reached with last error: %w", lastErr)
+	}
+	return "", fmt.Errorf("timed out waiting for %s to become available after %d retries", snapshots.kind(), maxRetries)
 }
 
-func exportSnapshotToS3(ctx context.Context, s3Client *s3.Client, rdsClient *rds.Client, snapshotID, bucket, kmsKeyArn, roleArn string) (string, error) {
-	snapshot, err := rdsClient.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{
-		DBSnapshotIdentifier: aws.String(snapshotID),
-	})
+// waitForSnapshot waits up to two hours for a snapshot to become available.
+// With opts.NoWait it checks once and returns ErrPending if it is not.
+func waitForSnapshot(ctx context.Context, snapshots snapshotClient, snapshotID string, opts Options) error {
+	if !opts.NoWait {
+		return snapshots.wait(ctx, snapshotID, 2*time.Hour)
+	}
+
+	snapshot, err := snapshots.describe(ctx, snapshotID)
 	if err != nil {
-		return "", fmt.Errorf("failed to describe snapshot: %w", err)
+		return fmt.Errorf("failed to describe snapshot: %w", err)
+	}
+	if snapshot == nil {
+		return fmt.Errorf("no snapshot found with ID: %s", snapshotID)
+	}
+
+	switch status := snapshot.Status; status {
+	case "available":
+		return nil
+	case "failed", "deleting", "deleted", "incompatible-restore", "incompatible-parameters":
+		return fmt.Errorf("snapshot %s is in %s state", snapshotID, status)
+	default:
+		return fmt.Errorf("snapshot %s is in %s state: %w", snapshotID, status, ErrPending)
+	}
+}
+
+func CopyAndExportSnapshotToTargetRegion(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, sourceSnapshotID string, targetKMSKeyArn, stagingKMSKeyArn string, opts Options, result *Result) (string, error) {
+	state := &result.State
+
+	if opts.runs(StageCopy) && !state.Done(StageCopy) {
+		result.startStage(StageCopy, clients.TargetRegion)
+		targetSnapshotID := fmt.Sprintf("copy-%s", sourceSnapshotID)
+		var err error
+		if clients.TargetAccount != "" {
+			err = copySnapshotToVault(ctx, clients, db, sourceSnapshotID, targetSnapshotID, targetKMSKeyArn, stagingKMSKeyArn, opts, result)
+		} else {
+			err = copySnapshotToTargetRegion(ctx, newSnapshotClient(clients.SourceRDS, db), newSnapshotClient(clients.TargetRDS, db),
+				clients.SourceRegion, clients.TargetRegion, sourceSnapshotID, targetSnapshotID, targetKMSKeyArn, opts, result)
+		}
+		if err != nil {
+			return "", err
+		}
+		state.TargetSnapshotID = targetSnapshotID
+		result.completeStage(StageCopy, opts)
 	}
 
-	log.Printf("Starting export task for snapshot: %s", snapshotID)
+	if state.TargetSnapshotID == "" {
+		return "", fmt.Errorf("no target snapshot to work on, run the %s step first", StageCopy)
+	}
+	targetSnapshotID := state.TargetSnapshotID
+
+	if opts.SkipExport {
+		log.Printf("Skipping export of %s to S3", targetSnapshotID)
+		return targetSnapshotID, nil
+	}
+
+	if opts.runs(StageExportTarget) && !state.Done(StageExportTarget) {
+		result.startStage(StageExportTarget, clients.TargetRegion)
+		prefix := s3Prefix(db.TargetS3Prefix, db.DBIdentifier, clients.TargetRegion, targetSnapshotID)
+		exportTask, prefix, err := exportSnapshotToS3(ctx, newSnapshotClient(clients.TargetRDS, db), clients.TargetRegion,
+			targetSnapshotID, db.TargetBucket, prefix, targetKMSKeyArn, db.ExportRoleARN, db.TargetExportOnly, opts, result)
+		if err != nil {
+			return "", err
+		}
+
+		recordExport(ctx, clients.TargetS3, ExportReport{
+			Stage:      StageExportTarget,
+			Region:     clients.TargetRegion,
+			TaskID:     exportTask,
+			Bucket:     db.TargetBucket,
+			Prefix:     exportPrefix(prefix, exportTask),
+			ExportOnly: db.TargetExportOnly,
+		}, opts, result)
+		result.completeStage(StageExportTarget, opts)
+	}
+
+	return targetSnapshotID, nil
+}
+
+// exportSnapshotToS3 starts the export task of a snapshot, or attaches to it
+// if it was already started, and waits for it to complete. exportOnly limits
+// the export to the listed databases, schemas and tables; it is empty to
+// export everything. The files are written under prefix in bucket; the
+// prefix of an existing task is returned, which is the one its files are
+// under.
+func exportSnapshotToS3(ctx context.Context, snapshots snapshotClient, region, snapshotID, bucket, prefix, kmsKeyArn, roleArn string, exportOnly []string, opts Options, result *Result) (string, string, error) {
 	exportTask := fmt.Sprintf("export-%s", snapshotID)
-	_, err = rdsClient.StartExportTask(ctx, &rds.StartExportTaskInput{
-		ExportTaskIdentifier: aws.String(exportTask),
-		IamRoleArn:           aws.String(roleArn),
-		KmsKeyId:             aws.String(kmsKeyArn),
-		S3BucketName:         aws.String(bucket),
-		SourceArn:            snapshot.DBSnapshots[0].DBSnapshotArn,
-	})
+	if opts.DryRun {
+		detail := fmt.Sprintf("export snapshot %s to s3://%s/%s/ with role %s and KMS key %s", snapshotID, bucket, exportPrefix(prefix, exportTask), roleArn, kmsKeyArn)
+		if len(exportOnly) > 0 {
+			detail += ", only " + strings.Join(exportOnly, ", ")
+		}
+		result.plan(Action{
+			Service:   "RDS",
+			Operation: "StartExportTask",
+			Region:    region,
+			Resource:  exportTask,
+			Detail:    detail,
+		})
+		return exportTask, prefix, nil
+	}
+
+	existing, err := describeExportTask(ctx, snapshots.rds, exportTask)
 	if err != nil {
-		return "", fmt.Errorf("failed to start export task: %w", err)
+		return "", "", err
 	}
 
-	for {
-		describeOutput, err := rdsClient.DescribeExportTasks(ctx, &rds.DescribeExportTasksInput{
+	if existing != nil {
+		log.Printf("Export task %s already exists (%s), waiting for it", exportTask, aws.ToString(existing.Status))
+		prefix = strings.Trim(aws.ToString(existing.S3Prefix), "/")
+	} else {
+		snapshot, err := snapshots.describe(ctx, snapshotID)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to describe snapshot: %w", err)
+		}
+		if snapshot == nil {
+			return "", "", fmt.Errorf("no snapshot found with ID: %s", snapshotID)
+		}
+
+		if len(exportOnly) > 0 {
+			log.Printf("Starting export task for snapshot: %s (only %s)", snapshotID, strings.Join(exportOnly, ", "))
+		} else {
+			log.Printf("Starting export task for snapshot: %s", snapshotID)
+		}
+		_, err = snapshots.rds.StartExportTask(ctx, &rds.StartExportTaskInput{
 			ExportTaskIdentifier: aws.String(exportTask),
+			IamRoleArn:           aws.String(roleArn),
+			KmsKeyId:             aws.String(kmsKeyArn),
+			S3BucketName:         aws.String(bucket),
+			S3Prefix:             s3PrefixInput(prefix),
+			SourceArn:            aws.String(snapshot.ARN),
+			ExportOnly:           exportOnly,
 		})
 		if err != nil {
-			return "", fmt.Errorf("error describing export tasks: %w", err)
+			return "", "", fmt.Errorf("failed to start export task: %w", err)
 		}
-		if len(describeOutput.ExportTasks) == 0 {
-			return "", fmt.Errorf("no export task found with identifier %s", exportTask)
+	}
+
+	for {
+		task, err := describeExportTask(ctx, snapshots.rds, exportTask)
+		if err != nil {
+			return "", "", err
+		}
+		if task == nil {
+			return "", "", fmt.Errorf("no export task found with identifier %s", exportTask)
 		}
 
-		status := *describeOutput.ExportTasks[0].Status
+		status := *task.Status
 		log.Printf("Export task status: %s", status)
 
+		if status == "COMPLETE" || status == "FAILED" || status == "CANCELED" {
+			metrics.ExportTasks.WithLabelValues(result.DBIdentifier, region, status).Inc()
+		}
 		if status == "COMPLETE" {
 			break
 		}
-		if status == "FAILED" {
+		if status == "FAILED" || status == "CANCELED" {
 			failureMsg := "Unknown failure"
-			if describeOutput.ExportTasks[0].FailureCause != nil {
-				failureMsg = *describeOutput.ExportTasks[0].FailureCause
+			if task.FailureCause != nil {
+				failureMsg = *task.FailureCause
 			}
-			return "", fmt.Errorf("export task %s failed: %s", exportTask, failureMsg)
+			return "", "", fmt.Errorf("export task %s failed: %s", exportTask, failureMsg)
+		}
+		if opts.NoWait {
+			return "", "", fmt.Errorf("export task %s is %s: %w", exportTask, status, ErrPending)
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return "", "", err
 		}
-		time.Sleep(5 * time.Minute)
 	}
 
-	return exportTask, nil
+	return exportTask, prefix, nil
 }
 
-func copySnapshotToTargetRegion(ctx context.Context, sourceRDS *rds.Client, targetRDS *rds.Client, sourceSnapshotID string, targetKMSKeyArn string) (string, error) {
-    targetSnapshotID := fmt.Sprintf("copy-%s", sourceSnapshotID)
-
-    // First check if the snapshot already exists
-    existingSnapshot, err := targetRDS.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{
-        DBSnapshotIdentifier: aws.String(targetSnapshotID),
-    })
-    if err == nil && len(existingSnapshot.DBSnapshots) > 0 {
-        // Snapshot exists, check its status
-        status := *existingSnapshot.DBSnapshots[0].Status
-        if status == "available" {
-            log.Printf("Snapshot %s already exists and is available", targetSnapshotID)
-            return targetSnapshotID, nil
-        }
-        // If snapshot exists but not available, wait for it
-        waiter := rds.NewDBSnapshotAvailableWaiter(targetRDS)
-        err = waiter.Wait(ctx, &rds.DescribeDBSnapshotsInput{
-            DBSnapshotIdentifier: aws.String(targetSnapshotID),
-        }, 2*time.Hour)
-        if err == nil {
-            return targetSnapshotID, nil
-        }
-        // If waiting failed, try to delete and recreate
-        _ = DeleteSnapshot(ctx, targetRDS, targetSnapshotID)
-    }
-
-    // Original copy logic
-    snapshot, err := sourceRDS.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{
-        DBSnapshotIdentifier: aws.String(sourceSnapshotID),
-    })
-    if err != nil {
-        return "", fmt.Errorf("failed to describe source snapshot: %w", err)
-    }
-
-    if len(snapshot.DBSnapshots) == 0 {
-        return "", fmt.Errorf("no snapshot found with ID: %s", sourceSnapshotID)
-    }
-
-    sourceSnapshotArn := snapshot.DBSnapshots[0].DBSnapshotArn
-    
-    log.Printf("Copying snapshot to target region: %s", targetSnapshotID)
-    _, err = targetRDS.CopyDBSnapshot(ctx, &rds.CopyDBSnapshotInput{
-        SourceDBSnapshotIdentifier: sourceSnapshotArn,
-        TargetDBSnapshotIdentifier: aws.String(targetSnapshotID),
-        KmsKeyId:                   aws.String(targetKMSKeyArn),
-        CopyTags:                   aws.Bool(true),
-    })
-    if err != nil {
-        return "", fmt.Errorf("failed to start snapshot copy: %w", err)
-    }
-
-    waiter := rds.NewDBSnapshotAvailableWaiter(targetRDS)
-    err = waiter.Wait(ctx, &rds.DescribeDBSnapshotsInput{
-        DBSnapshotIdentifier: aws.String(targetSnapshotID),
-    }, 2*time.Hour)
-    if err != nil {
-        return "", fmt.Errorf("error waiting for snapshot: %w", err)
-    }
-
-    return targetSnapshotID, nil
+// s3Prefix expands the prefix template of db for the export of snapshotID.
+// The time is taken from the snapshot ID so that a resumed export expands
+// to the same prefix.
+func s3Prefix(template, db, region, snapshotID string) string {
+	if template == "" {
+		return ""
+	}
+	taken := time.Now()
+	if len(snapshotID) >= len(snapshotTimeFormat) {
+		if t, err := time.Parse(snapshotTimeFormat, snapshotID[len(snapshotID)-len(snapshotTimeFormat):]); err == nil {
+			taken = t
+		}
+	}
+	return config.ExpandS3Prefix(template, db, region, snapshotID, taken)
 }
 
+// exportPrefix returns the key prefix of the files of exportTask: RDS
+// writes them under <prefix>/<exportTask>/.
+func exportPrefix(prefix, exportTask string) string {
+	if prefix == "" {
+		return exportTask
+	}
+	return prefix + "/" + exportTask
+}
 
-func DeleteSnapshot(ctx context.Context, rdsClient *rds.Client, snapshotID string) error {
-	_, err := rdsClient.DeleteDBSnapshot(ctx, &rds.DeleteDBSnapshotInput{
-		DBSnapshotIdentifier: aws.String(snapshotID),
+func s3PrefixInput(prefix string) *string {
+	if prefix == "" {
+		return nil
+	}
+	return aws.String(prefix)
+}
+
+// recordExport verifies the files of a completed export and adds it to the
+// report. An empty or partial export is flagged as a warning rather than
+// failing the backup: the snapshot and its copy are unaffected.
+func recordExport(ctx context.Context, s3Client awsinternal.S3API, export ExportReport, opts Options, result *Result) {
+	if !opts.DryRun {
+		verification, err := verifyExport(ctx, s3Client, export.Bucket, export.Prefix, export.TaskID, export.ExportOnly)
+		switch {
+		case err != nil:
+			result.warn("failed to verify export %s: %v", export.TaskID, err)
+		case !verification.Complete():
+			export.Verification = verification
+			metrics.IncompleteExports.WithLabelValues(result.DBIdentifier, export.Region).Inc()
+			result.warn("export %s to %s is incomplete: %s", export.TaskID, export.Location(), strings.Join(verification.Problems, "; "))
+		default:
+			export.Verification = verification
+			log.Printf("Verified export %s: %s", export.TaskID, verification.Summary())
+		}
+	}
+	result.Report.Exports = append(result.Report.Exports, export)
+}
+
+// describeExportTask returns the export task with the given identifier, or
+// nil if there is none.
+func describeExportTask(ctx context.Context, rdsClient awsinternal.RDSAPI, exportTask string) (*types.ExportTask, error) {
+	output, err := rdsClient.DescribeExportTasks(ctx, &rds.DescribeExportTasksInput{
+		ExportTaskIdentifier: aws.String(exportTask),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to delete snapshot: %w", err)
+		var notFound *types.ExportTaskNotFoundFault
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error describing export tasks: %w", err)
+	}
+	if len(output.ExportTasks) == 0 {
+		return nil, nil
+	}
+	return &output.ExportTasks[0], nil
+}
+
+// copySnapshotToVault copies the source snapshot to targetSnapshotID in the
+// vault account. RDS cannot copy an encrypted snapshot shared from another
+// account across regions, so the snapshot is first copied to the target
+// region in the source account, encrypted with stagingKMSKeyArn. That copy
+// is shared with the vault, copied there with the vault's key
+// vaultKMSKeyArn, and deleted once the vault has its own copy.
+func copySnapshotToVault(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, sourceSnapshotID, targetSnapshotID, vaultKMSKeyArn, stagingKMSKeyArn string, opts Options, result *Result) error {
+	source := newSnapshotClient(clients.SourceRDS, db)
+	staging := newSnapshotClient(clients.StagingRDS, db)
+	vault := newSnapshotClient(clients.TargetRDS, db)
+	stagingSnapshotID := fmt.Sprintf("staging-%s", sourceSnapshotID)
+
+	// A resumed run may find the vault copy done before the staging copy was
+	// removed
+	if !opts.DryRun {
+		if existing, err := vault.describe(ctx, targetSnapshotID); err == nil && existing != nil && existing.Status == "available" {
+			log.Printf("Snapshot %s already exists and is available", targetSnapshotID)
+			removeStagingSnapshot(ctx, staging, clients.TargetRegion, stagingSnapshotID, clients.TargetAccount, opts, result)
+			return nil
+		}
+	}
+
+	if err := copySnapshotToTargetRegion(ctx, source, staging, clients.SourceRegion, clients.TargetRegion,
+		sourceSnapshotID, stagingSnapshotID, stagingKMSKeyArn, opts, result); err != nil {
+		return err
+	}
+	if err := shareSnapshot(ctx, staging, clients.TargetRegion, stagingSnapshotID, clients.TargetAccount, opts, result); err != nil {
+		return err
+	}
+	// The staging copy is in the target region already
+	if err := copySnapshotToTargetRegion(ctx, staging, vault, "", clients.TargetRegion,
+		stagingSnapshotID, targetSnapshotID, vaultKMSKeyArn, opts, result); err != nil {
+		return err
+	}
+	removeStagingSnapshot(ctx, staging, clients.TargetRegion, stagingSnapshotID, clients.TargetAccount, opts, result)
+	return nil
+}
+
+// shareSnapshot shares a snapshot with account so that it can be copied
+// there.
+func shareSnapshot(ctx context.Context, snapshots snapshotClient, region, snapshotID, account string, opts Options, result *Result) error {
+	if opts.DryRun {
+		result.plan(Action{
+			Service:   "RDS",
+			Operation: snapshots.operation("ModifyDBSnapshotAttribute"),
+			Region:    region,
+			Resource:  snapshotID,
+			Detail:    fmt.Sprintf("share with account %s for the copy, until it is done", account),
+		})
+		return nil
+	}
+
+	log.Printf("Sharing snapshot %s with account %s", snapshotID, account)
+	if err := snapshots.share(ctx, snapshotID, account, true); err != nil {
+		return fmt.Errorf("failed to share snapshot with account %s: %w", account, err)
+	}
+	return nil
+}
+
+// removeStagingSnapshot stops sharing the staging copy of a vault copy with
+// account and deletes it, if it exists. Failures are warnings: the vault
+// has its copy.
+func removeStagingSnapshot(ctx context.Context, staging snapshotClient, region, snapshotID, account string, opts Options, result *Result) {
+	if opts.DryRun {
+		result.plan(Action{
+			Service:   "RDS",
+			Operation: staging.operation("DeleteDBSnapshot"),
+			Region:    region,
+			Resource:  snapshotID,
+			Detail:    "staging copy, once the vault has its own",
+		})
+		return
+	}
+
+	if snapshot, err := staging.describe(ctx, snapshotID); err != nil || snapshot == nil {
+		return
+	}
+	if err := staging.share(ctx, snapshotID, account, false); err != nil {
+		result.warn("failed to stop sharing snapshot %s with account %s: %v", snapshotID, account, err)
+	}
+	log.Printf("Deleting staging snapshot %s", snapshotID)
+	if err := staging.delete(ctx, snapshotID); err != nil {
+		result.warn("failed to delete staging snapshot %s: %v", snapshotID, err)
+	}
+}
+
+// copySnapshotToTargetRegion copies sourceSnapshotID to targetSnapshotID,
+// encrypted with targetKMSKeyArn, or waits for the copy of an earlier run.
+// sourceRegion is the region of the source snapshot, empty if it is in
+// targetRegion.
+func copySnapshotToTargetRegion(ctx context.Context, source, target snapshotClient, sourceRegion, targetRegion, sourceSnapshotID, targetSnapshotID, targetKMSKeyArn string, opts Options, result *Result) error {
+	if opts.DryRun {
+		result.plan(Action{
+			Service:   "RDS",
+			Operation: target.operation("CopyDBSnapshot"),
+			Region:    targetRegion,
+			Resource:  targetSnapshotID,
+			Detail:    fmt.Sprintf("copy of %s encrypted with KMS key %s", sourceSnapshotID, targetKMSKeyArn),
+		})
+		return nil
+	}
+
+	// First check if the snapshot already exists
+	existingSnapshot, err := target.describe(ctx, targetSnapshotID)
+	if err == nil && existingSnapshot != nil {
+		// Snapshot exists, check its status
+		if existingSnapshot.Status == "available" {
+			log.Printf("Snapshot %s already exists and is available", targetSnapshotID)
+			return nil
+		}
+		// If snapshot exists but not available, wait for it
+		err = waitForSnapshot(ctx, target, targetSnapshotID, opts)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrPending) {
+			return err
+		}
+		// If waiting failed, try to delete and recreate
+		_ = target.delete(ctx, targetSnapshotID)
+	}
+
+	// Original copy logic
+	snapshot, err := source.describe(ctx, sourceSnapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to describe source snapshot: %w", err)
+	}
+
+	if snapshot == nil {
+		return fmt.Errorf("no snapshot found with ID: %s", sourceSnapshotID)
 	}
+
+	log.Printf("Copying snapshot to target region: %s", targetSnapshotID)
+	err = target.copy(ctx, snapshot.ARN, sourceRegion, targetSnapshotID, targetKMSKeyArn)
+	if err != nil {
+		return fmt.Errorf("failed to start snapshot copy: %w", err)
+	}
+
+	err = waitForSnapshot(ctx, target, targetSnapshotID, opts)
+	if err != nil {
+		if errors.Is(err, ErrPending) {
+			return err
+		}
+		return fmt.Errorf("error waiting for snapshot: %w", err)
+	}
+
 	return nil
 }
 
-func CleanupOldSnapshots(ctx context.Context, clients *awsinternal.AWSClients, config *config.Config) error {
-	cutoffTime := time.Now().AddDate(0, 0, -45)
+// DeleteSnapshot deletes a snapshot of db, a DB cluster snapshot if db is in
+// cluster mode.
+func DeleteSnapshot(ctx context.Context, rdsClient awsinternal.RDSAPI, db *config.Database, snapshotID string) error {
+	if err := newSnapshotClient(rdsClient, db).delete(ctx, snapshotID); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
 
-	if err := deleteOldSnapshots(ctx, clients.SourceRDS, "backup-"+config.DBIdentifier, cutoffTime); err != nil {
+func CleanupOldSnapshots(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, opts Options, result *Result) error {
+	if err := deleteOldSnapshots(ctx, newSnapshotClient(clients.SourceRDS, db), clients.SourceRegion, "backup-"+db.DBIdentifier+"-", db.SourceRetention, opts, result); err != nil {
 		return fmt.Errorf("failed to cleanup source region snapshots: %w", err)
 	}
 
-	if err := deleteOldSnapshots(ctx, clients.TargetRDS, "copy-backup-"+config.DBIdentifier, cutoffTime); err != nil {
+	if err := deleteOldSnapshots(ctx, newSnapshotClient(clients.TargetRDS, db), clients.TargetRegion, "copy-backup-"+db.DBIdentifier+"-", db.TargetRetention, opts, result); err != nil {
 		return fmt.Errorf("failed to cleanup target region snapshots: %w", err)
 	}
 
 	return nil
 }
 
-func deleteOldSnapshots(ctx context.Context, rdsClient *rds.Client, prefix string, cutoffTime time.Time) error {
-	input := &rds.DescribeDBSnapshotsInput{
-		SnapshotType: aws.String("manual"),
+func deleteOldSnapshots(ctx context.Context, snapshotsClient snapshotClient, region, prefix string, policy retention.Policy, opts Options, result *Result) error {
+	found, err := listBackupSnapshots(ctx, snapshotsClient, prefix)
+	if err != nil {
+		return err
 	}
 
-	paginator := rds.NewDescribeDBSnapshotsPaginator(rdsClient, input)
-
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list snapshots: %w", err)
+	var snapshots []retention.Snapshot
+	for _, snapshot := range found {
+		if snapshot.Created == nil {
+			continue
 		}
+		snapshots = append(snapshots, retention.Snapshot{
+			ID:      snapshot.ID,
+			Created: *snapshot.Created,
+		})
+	}
 
-		for _, snapshot := range output.DBSnapshots {
-			if !strings.HasPrefix(*snapshot.DBSnapshotIdentifier, prefix) {
-				continue
+	log.Printf("Applying retention policy %s to %d snapshot(s) matching %s", policy, len(snapshots), prefix)
+	for _, decision := range policy.Apply(snapshots, time.Now()) {
+		if decision.Keep {
+			if opts.DryRun {
+				log.Printf("[dry-run] Keeping snapshot %s (%s)", decision.Snapshot.ID, decision.Reason)
 			}
+			continue
+		}
 
-			if snapshot.SnapshotCreateTime.Before(cutoffTime) {
-				log.Printf("Deleting old snapshot: %s (created: %s)",
-					*snapshot.DBSnapshotIdentifier,
-					snapshot.SnapshotCreateTime.Format(time.RFC3339))
+		if opts.DryRun {
+			result.plan(Action{
+				Service:   "RDS",
+				Operation: snapshotsClient.operation("DeleteDBSnapshot"),
+				Region:    region,
+				Resource:  decision.Snapshot.ID,
+				Detail:    fmt.Sprintf("created %s, %s", decision.Snapshot.Created.Format(time.RFC3339), decision.Reason),
+			})
+			continue
+		}
 
-				_, err := rdsClient.DeleteDBSnapshot(ctx, &rds.DeleteDBSnapshotInput{
-					DBSnapshotIdentifier: snapshot.DBSnapshotIdentifier,
-				})
-				if err != nil {
-					log.Printf("Warning: Failed to delete snapshot %s: %v",
-						*snapshot.DBSnapshotIdentifier, err)
-				}
-			}
+		log.Printf("Deleting old snapshot: %s (created: %s, %s)",
+			decision.Snapshot.ID,
+			decision.Snapshot.Created.Format(time.RFC3339),
+			decision.Reason)
+
+		if err := snapshotsClient.delete(ctx, decision.Snapshot.ID); err != nil {
+			result.warn("failed to delete snapshot %s: %v", decision.Snapshot.ID, err)
+			continue
 		}
+		created := decision.Snapshot.Created
+		result.deleted(DeletedSnapshot{
+			SnapshotID: decision.Snapshot.ID,
+			Region:     region,
+			Created:    &created,
+			Reason:     decision.Reason,
+		})
 	}
 
 	return nil
 }
+
+// listBackupSnapshots returns the manual snapshots whose identifier is
+// prefix followed by a snapshot timestamp.
+func listBackupSnapshots(ctx context.Context, snapshotsClient snapshotClient, prefix string) ([]snapshotInfo, error) {
+	all, err := snapshotsClient.list(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []snapshotInfo
+	for _, snapshot := range all {
+		if isBackupSnapshot(snapshot.ID, prefix) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// isBackupSnapshot reports whether id is prefix followed by a snapshot
+// timestamp, so that "backup-orders-" does not also match the snapshots of
+// a database called "orders-archive".
+func isBackupSnapshot(id, prefix string) bool {
+	suffix, ok := strings.CutPrefix(id, prefix)
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(snapshotTimeFormat, suffix)
+	return err == nil
+}
@@ -0,0 +1,466 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+const testRunID = "orders-20250110T120000Z"
+
+// fastPolling shortens the polling intervals for the duration of the test.
+func fastPolling(t *testing.T) {
+	t.Helper()
+	interval, minDelay, maxDelay := pollInterval, waiterMinDelay, waiterMaxDelay
+	pollInterval, waiterMinDelay, waiterMaxDelay = time.Millisecond, time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() {
+		pollInterval, waiterMinDelay, waiterMaxDelay = interval, minDelay, maxDelay
+	})
+}
+
+func count(calls []string, operation string) int {
+	n := 0
+	for _, call := range calls {
+		if call == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCreateAndExportSnapshotInSourceRegion(t *testing.T) {
+	fastPolling(t)
+	existing := "backup-orders-2025-01-10-11-00-00"
+
+	tests := []struct {
+		name    string
+		setup   func(cloud *fake.Cloud)
+		db      config.Database
+		opts    Options
+		state   State
+		cancel  bool
+		wantErr string
+		pending bool
+		check   func(t *testing.T, cloud *fake.Cloud, id string, result *Result)
+	}{
+		{
+			name:  "available instance is snapshotted",
+			setup: func(cloud *fake.Cloud) { cloud.SourceRDS.AddInstance("orders", "available") },
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if !strings.HasPrefix(id, "backup-orders-") {
+					t.Errorf("snapshot ID %q", id)
+				}
+				if status := cloud.SourceRDS.SnapshotStatus(id); status != "available" {
+					t.Errorf("snapshot %s is %s", id, status)
+				}
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBSnapshot"); n != 1 {
+					t.Errorf("%d CreateDBSnapshot calls", n)
+				}
+				if result.Report.Snapshot == nil || result.Report.Snapshot.Engine != "postgres" {
+					t.Errorf("snapshot report %+v", result.Report.Snapshot)
+				}
+			},
+		},
+		{
+			name: "backing-up instance reuses the snapshot of the run",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "backing-up")
+				cloud.SourceRDS.AddSnapshot(existing, "orders", "creating", time.Now())
+				cloud.SourceRDS.TagSnapshot(existing, runIDTag, testRunID)
+			},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if id != existing {
+					t.Errorf("snapshot ID %q, want %q", id, existing)
+				}
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBSnapshot"); n != 0 {
+					t.Errorf("%d CreateDBSnapshot calls", n)
+				}
+			},
+		},
+		{
+			name: "backing-up instance does not reuse the snapshot of another run",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "backing-up")
+				cloud.SourceRDS.AddSnapshot(existing, "orders", "creating", time.Now())
+				cloud.SourceRDS.TagSnapshot(existing, runIDTag, "orders-20250110T110000Z")
+			},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if id == existing {
+					t.Errorf("reused the snapshot of another run")
+				}
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBSnapshot"); n != 1 {
+					t.Errorf("%d CreateDBSnapshot calls", n)
+				}
+			},
+		},
+		{
+			name:    "missing instance",
+			wantErr: "DB instance not found: orders",
+		},
+		{
+			name: "retries a failed describe",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				cloud.SourceRDS.FailNext("DescribeDBInstances", errors.New("throttled"))
+			},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if n := count(cloud.SourceRDS.Calls(), "DescribeDBInstances"); n != 2 {
+					t.Errorf("%d DescribeDBInstances calls", n)
+				}
+			},
+		},
+		{
+			name: "retries a failed create",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				cloud.SourceRDS.FailNext("CreateDBSnapshot", &types.SnapshotQuotaExceededFault{Message: new(string)})
+			},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBSnapshot"); n != 2 {
+					t.Errorf("%d CreateDBSnapshot calls", n)
+				}
+				if status := cloud.SourceRDS.SnapshotStatus(id); status != "available" {
+					t.Errorf("snapshot %s is %s", id, status)
+				}
+			},
+		},
+		{
+			name: "gives up after the last retry",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				for range 12 {
+					cloud.SourceRDS.FailNext("DescribeDBInstances", errors.New("throttled"))
+				}
+			},
+			wantErr: "max retries reached with last error: ",
+		},
+		{
+			name:    "no-wait returns pending while the instance is busy",
+			setup:   func(cloud *fake.Cloud) { cloud.SourceRDS.AddInstance("orders", "modifying") },
+			opts:    Options{NoWait: true},
+			pending: true,
+		},
+		{
+			name: "no-wait returns pending while the snapshot is created",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				cloud.SourceRDS.Pending = 1
+			},
+			opts:    Options{NoWait: true},
+			pending: true,
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if result.State.SourceSnapshotID == "" {
+					t.Errorf("snapshot ID not recorded in the state")
+				}
+				if result.State.Done(StageSnapshot) {
+					t.Errorf("snapshot stage completed")
+				}
+			},
+		},
+		{
+			name: "resumes with the snapshot in the state",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "backing-up")
+				cloud.SourceRDS.AddSnapshot(existing, "orders", "creating", time.Now())
+			},
+			state: State{SourceSnapshotID: existing},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if id != existing {
+					t.Errorf("snapshot ID %q, want %q", id, existing)
+				}
+				if n := count(cloud.SourceRDS.Calls(), "DescribeDBInstances"); n != 0 {
+					t.Errorf("%d DescribeDBInstances calls", n)
+				}
+			},
+		},
+		{
+			name:    "interrupted while waiting for the instance",
+			setup:   func(cloud *fake.Cloud) { cloud.SourceRDS.AddInstance("orders", "modifying") },
+			cancel:  true,
+			wantErr: "interrupted while waiting",
+		},
+		{
+			name:  "dry run plans the snapshot",
+			setup: func(cloud *fake.Cloud) { cloud.SourceRDS.AddInstance("orders", "modifying") },
+			opts:  Options{DryRun: true},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBSnapshot"); n != 0 {
+					t.Errorf("%d CreateDBSnapshot calls", n)
+				}
+				if len(result.PlannedActions) != 1 || result.PlannedActions[0].Operation != "CreateDBSnapshot" || result.PlannedActions[0].Resource != id {
+					t.Errorf("planned actions %+v", result.PlannedActions)
+				}
+			},
+		},
+		{
+			name:  "cluster is snapshotted",
+			setup: func(cloud *fake.Cloud) { cloud.SourceRDS.AddCluster("orders", "available") },
+			db:    config.Database{Mode: config.ModeCluster},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if n := count(cloud.SourceRDS.Calls(), "CreateDBClusterSnapshot"); n != 1 {
+					t.Errorf("%d CreateDBClusterSnapshot calls", n)
+				}
+			},
+		},
+		{
+			name: "exports to the source bucket",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				cloud.SourceS3.AddBucket("source-backups")
+			},
+			db: config.Database{StoreToSourceS3: true, SourceBucket: "source-backups", SourceS3Prefix: "rds/{{db}}"},
+			check: func(t *testing.T, cloud *fake.Cloud, id string, result *Result) {
+				if !result.State.Done(StageExportSource) {
+					t.Errorf("export stage not completed")
+				}
+				if len(result.Report.Exports) != 1 {
+					t.Fatalf("exports %+v", result.Report.Exports)
+				}
+				export := result.Report.Exports[0]
+				if export.TaskID != "export-"+id || export.Prefix != "rds/orders/export-"+id {
+					t.Errorf("export %+v", export)
+				}
+				if export.Verification == nil || !export.Verification.Complete() {
+					t.Errorf("export verification %+v", export.Verification)
+				}
+			},
+		},
+		{
+			name: "failed export",
+			setup: func(cloud *fake.Cloud) {
+				cloud.SourceRDS.AddInstance("orders", "available")
+				cloud.SourceRDS.FailNext("StartExportTask", errors.New("access denied"))
+			},
+			db:      config.Database{StoreToSourceS3: true, SourceBucket: "source-backups"},
+			wantErr: "failed to start export task: access denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			if tt.setup != nil {
+				tt.setup(cloud)
+			}
+			db := tt.db
+			db.DBIdentifier = "orders"
+			if db.Mode == "" {
+				db.Mode = config.ModeInstance
+			}
+			db.ExportRoleARN = "arn:aws:iam::123456789012:role/rds-export"
+			kmsKeyArn := cloud.SourceKMS.AddKey("source-key", true)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			result := &Result{RunID: testRunID, DBIdentifier: "orders", State: tt.state}
+
+			id, err := CreateAndExportSnapshotInSourceRegion(ctx, cloud.Clients(), &db, kmsKeyArn, tt.opts, result)
+			switch {
+			case tt.pending:
+				if !errors.Is(err, ErrPending) {
+					t.Fatalf("error %v, want ErrPending", err)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("CreateAndExportSnapshotInSourceRegion: %v", err)
+			default:
+				if id != result.State.SourceSnapshotID || !result.State.Done(StageSnapshot) {
+					t.Errorf("returned %q with state %+v", id, result.State)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, cloud, id, result)
+			}
+		})
+	}
+}
+
+func TestPerform(t *testing.T) {
+	fastPolling(t)
+
+	for _, mode := range []string{config.ModeInstance, config.ModeCluster} {
+		t.Run(mode, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			if mode == config.ModeCluster {
+				cloud.SourceRDS.AddCluster("orders", "available")
+			} else {
+				cloud.SourceRDS.AddInstance("orders", "available")
+			}
+			cloud.TargetS3.AddBucket("target-backups")
+			cloud.TargetKMS.AddKey("target-key", true)
+			cloud.TargetKMS.AddAlias("alias/rds-backup", "target-key")
+			roleArn := cloud.IAM.AddRole("rds-export", "export.rds.amazonaws.com")
+
+			clients := newClients
+			newClients = func(ctx context.Context, cfg *config.Config) (*awsinternal.AWSClients, error) {
+				return cloud.Clients(), nil
+			}
+			t.Cleanup(func() { newClients = clients })
+
+			cfg := &config.Config{SourceRegion: "us-east-1", TargetRegion: "us-west-2"}
+			db := &config.Database{
+				DBIdentifier:   "orders",
+				Mode:           mode,
+				TargetBucket:   "target-backups",
+				ExportRoleARN:  roleArn,
+				TargetKMSKeyID: "alias/rds-backup",
+			}
+			var checkpoints int
+			opts := Options{Checkpoint: func(*Result) error { checkpoints++; return nil }}
+			result := &Result{RunID: testRunID, DBIdentifier: "orders"}
+
+			if err := Perform(context.Background(), cfg, db, opts, result); err != nil {
+				t.Fatalf("Perform: %v", err)
+			}
+
+			for _, stage := range []Stage{StagePreflight, StageSnapshot, StageCopy, StageExportTarget, StageCleanup} {
+				if !result.State.Done(stage) {
+					t.Errorf("stage %s not completed", stage)
+				}
+			}
+			if checkpoints == 0 {
+				t.Errorf("the run was never checkpointed")
+			}
+			if got := cloud.TargetRDS.SnapshotIDs(); !slices.Equal(got, []string{result.State.TargetSnapshotID}) {
+				t.Errorf("target snapshots %q, want %q", got, result.State.TargetSnapshotID)
+			}
+			if got := cloud.SourceRDS.SnapshotIDs(); len(got) != 0 {
+				t.Errorf("source snapshots %q were not deleted", got)
+			}
+			if len(result.Report.Exports) != 1 || result.Report.Exports[0].Verification == nil || !result.Report.Exports[0].Verification.Complete() {
+				t.Errorf("exports %+v", result.Report.Exports)
+			}
+			if len(result.Report.Warnings) != 0 {
+				t.Errorf("warnings %q", result.Report.Warnings)
+			}
+		})
+	}
+}
+
+func TestPerformWithVault(t *testing.T) {
+	fastPolling(t)
+	const vaultAccount = "210987654321"
+	vaultRoot := "arn:aws:iam::" + vaultAccount + ":root"
+
+	tests := []struct {
+		name    string
+		mode    string
+		key     func(kms *fake.KMS)
+		wantErr string
+	}{
+		{
+			name: "key policy grants the vault",
+			mode: config.ModeInstance,
+			key: func(kms *fake.KMS) {
+				kms.AddKey("shared-key", true)
+				kms.SetKeyPolicy("shared-key", `{"Statement":[
+					{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::123456789012:root"},"Action":"kms:*","Resource":"*"},
+					{"Effect":"Allow","Principal":{"AWS":["`+vaultRoot+`"]},"Action":["kms:Decrypt","kms:DescribeKey","kms:CreateGrant"],"Resource":"*"}]}`)
+			},
+		},
+		{
+			name: "grant to the vault",
+			mode: config.ModeCluster,
+			key: func(kms *fake.KMS) {
+				kms.AddKey("shared-key", true)
+				kms.AddGrant("shared-key", vaultRoot, kmstypes.GrantOperationDescribeKey, kmstypes.GrantOperationDecrypt, kmstypes.GrantOperationCreateGrant)
+			},
+		},
+		{
+			name: "key not granted to the vault",
+			mode: config.ModeInstance,
+			key: func(kms *fake.KMS) {
+				kms.AddKey("shared-key", true)
+			},
+			wantErr: "neither the key policy nor a grant allows account 210987654321 to use the key for kms:DescribeKey, kms:Decrypt, kms:CreateGrant",
+		},
+		{
+			name: "AWS managed key",
+			mode: config.ModeInstance,
+			key: func(kms *fake.KMS) {
+				kms.AddManagedKey("shared-key")
+			},
+			wantErr: "KMS key is AWS managed and cannot be shared with account 210987654321",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			cloud.UseVault(vaultAccount)
+			if tt.mode == config.ModeCluster {
+				cloud.SourceRDS.AddCluster("orders", "available")
+			} else {
+				cloud.SourceRDS.AddInstance("orders", "available")
+			}
+			cloud.TargetS3.AddBucket("vault-backups")
+			cloud.TargetKMS.AddKey("vault-key", true)
+			cloud.TargetKMS.AddAlias("alias/rds-backup-vault", "vault-key")
+			tt.key(cloud.StagingKMS)
+			cloud.StagingKMS.AddAlias("alias/rds-backup-shared", "shared-key")
+			roleArn := cloud.IAM.AddRole("rds-export", "export.rds.amazonaws.com")
+
+			clients := newClients
+			newClients = func(ctx context.Context, cfg *config.Config) (*awsinternal.AWSClients, error) {
+				return cloud.Clients(), nil
+			}
+			t.Cleanup(func() { newClients = clients })
+
+			cfg := &config.Config{SourceRegion: "us-east-1", TargetRegion: "us-west-2", Vault: config.Vault{
+				RoleARN:        "arn:aws:iam::" + vaultAccount + ":role/rds-backup-vault",
+				KMSKeyID:       "alias/rds-backup-vault",
+				SourceKMSKeyID: "alias/rds-backup-shared",
+				ExportRoleARN:  roleArn,
+			}}
+			db := &config.Database{DBIdentifier: "orders", Mode: tt.mode, TargetBucket: "vault-backups"}
+			result := &Result{RunID: testRunID, DBIdentifier: "orders"}
+
+			err := Perform(context.Background(), cfg, db, Options{}, result)
+			if tt.wantErr != "" {
+				var preflightErr *PreflightError
+				if !errors.As(err, &preflightErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want a preflight problem %q", err, tt.wantErr)
+				}
+				if got := cloud.SourceRDS.SnapshotIDs(); len(got) != 0 {
+					t.Errorf("snapshots %q taken despite the preflight problem", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Perform: %v", err)
+			}
+
+			if got, want := cloud.TargetRDS.SnapshotIDs(), []string{result.State.TargetSnapshotID}; !slices.Equal(got, want) {
+				t.Errorf("vault snapshots %q, want %q", got, want)
+			}
+			if got := cloud.StagingRDS.SnapshotIDs(); len(got) != 0 {
+				t.Errorf("staging snapshots %q were not deleted", got)
+			}
+			share := newSnapshotClient(cloud.StagingRDS, db).operation("ModifyDBSnapshotAttribute")
+			if n := count(cloud.StagingRDS.Calls(), share); n != 2 {
+				t.Errorf("%s called %d times, want 2 to share and unshare", share, n)
+			}
+			if n := count(cloud.SourceRDS.Calls(), share); n != 0 {
+				t.Errorf("the source snapshot was shared")
+			}
+			if !result.State.Done(StageExportTarget) || len(result.Report.Warnings) != 0 {
+				t.Errorf("export done %v, warnings %q", result.State.Done(StageExportTarget), result.Report.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// snapshotClient makes the snapshot calls of one region for either DB
+// instances or Aurora DB clusters, so that the pipeline does not have to
+// care which of the two it is backing up.
+type snapshotClient struct {
+	rds     awsinternal.RDSAPI
+	cluster bool
+}
+
+// snapshotInfo is the part of a DB snapshot or DB cluster snapshot the
+// pipeline uses.
+type snapshotInfo struct {
+	ID      string
+	ARN     string
+	Status  string
+	Created *time.Time
+	SizeGB  int32
+	Engine  string
+	Version string
+	// RunID is the run that took the snapshot, from its runIDTag.
+	RunID string
+}
+
+// runIDTag tags the snapshots taken by a run with the run's ID, so that a
+// resumed run can find the snapshot it started before it could save it.
+const runIDTag = "rds-backup-run-id"
+
+func newSnapshotClient(client awsinternal.RDSAPI, db *config.Database) snapshotClient {
+	return snapshotClient{rds: client, cluster: db.Mode == config.ModeCluster}
+}
+
+// kind names the backed up resource in messages.
+func (c snapshotClient) kind() string {
+	if c.cluster {
+		return "DB cluster"
+	}
+	return "DB instance"
+}
+
+// operation returns the name of the API call for dry-run reports, given the
+// DB instance variant such as "CreateDBSnapshot".
+func (c snapshotClient) operation(name string) string {
+	if c.cluster {
+		return strings.Replace(name, "DBSnapshot", "DBClusterSnapshot", 1)
+	}
+	return name
+}
+
+// sourceStatus returns the status of the DB instance or cluster, or "" if it
+// does not exist.
+func (c snapshotClient) sourceStatus(ctx context.Context, id string) (string, error) {
+	if c.cluster {
+		output, err := c.rds.DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{
+			DBClusterIdentifier: aws.String(id),
+		})
+		var notFound *types.DBClusterNotFoundFault
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to describe DB cluster: %w", err)
+		}
+		if len(output.DBClusters) == 0 {
+			return "", nil
+		}
+		return aws.ToString(output.DBClusters[0].Status), nil
+	}
+
+	output, err := c.rds.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(id),
+	})
+	var notFound *types.DBInstanceNotFoundFault
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to describe DB instance: %w", err)
+	}
+	if len(output.DBInstances) == 0 {
+		return "", nil
+	}
+	return aws.ToString(output.DBInstances[0].DBInstanceStatus), nil
+}
+
+// describe returns the snapshot with the given identifier, or nil if the
+// describe call returned none.
+func (c snapshotClient) describe(ctx context.Context, id string) (*snapshotInfo, error) {
+	var snapshots []snapshotInfo
+	var err error
+	if c.cluster {
+		var output *rds.DescribeDBClusterSnapshotsOutput
+		output, err = c.rds.DescribeDBClusterSnapshots(ctx, &rds.DescribeDBClusterSnapshotsInput{
+			DBClusterSnapshotIdentifier: aws.String(id),
+		})
+		if err == nil {
+			snapshots = clusterSnapshotInfos(output.DBClusterSnapshots)
+		}
+	} else {
+		var output *rds.DescribeDBSnapshotsOutput
+		output, err = c.rds.DescribeDBSnapshots(ctx, &rds.DescribeDBSnapshotsInput{
+			DBSnapshotIdentifier: aws.String(id),
+		})
+		if err == nil {
+			snapshots = instanceSnapshotInfos(output.DBSnapshots)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+	return &snapshots[0], nil
+}
+
+// list returns the manual snapshots of the region, only those of the given
+// DB instance or cluster unless source is empty.
+func (c snapshotClient) list(ctx context.Context, source string) ([]snapshotInfo, error) {
+	var snapshots []snapshotInfo
+
+	if c.cluster {
+		input := &rds.DescribeDBClusterSnapshotsInput{SnapshotType: aws.String("manual")}
+		if source != "" {
+			input.DBClusterIdentifier = aws.String(source)
+		}
+		paginator := rds.NewDescribeDBClusterSnapshotsPaginator(c.rds, input)
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list cluster snapshots: %w", err)
+			}
+			snapshots = append(snapshots, clusterSnapshotInfos(output.DBClusterSnapshots)...)
+		}
+		return snapshots, nil
+	}
+
+	input := &rds.DescribeDBSnapshotsInput{SnapshotType: aws.String("manual")}
+	if source != "" {
+		input.DBInstanceIdentifier = aws.String(source)
+	}
+	paginator := rds.NewDescribeDBSnapshotsPaginator(c.rds, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		snapshots = append(snapshots, instanceSnapshotInfos(output.DBSnapshots)...)
+	}
+	return snapshots, nil
+}
+
+// create takes a snapshot of source, tagged with runID if it is set.
+func (c snapshotClient) create(ctx context.Context, source, snapshotID, runID string) error {
+	var tags []types.Tag
+	if runID != "" {
+		tags = []types.Tag{{Key: aws.String(runIDTag), Value: aws.String(runID)}}
+	}
+
+	var err error
+	if c.cluster {
+		_, err = c.rds.CreateDBClusterSnapshot(ctx, &rds.CreateDBClusterSnapshotInput{
+			DBClusterIdentifier:         aws.String(source),
+			DBClusterSnapshotIdentifier: aws.String(snapshotID),
+			Tags:                        tags,
+		})
+	} else {
+		_, err = c.rds.CreateDBSnapshot(ctx, &rds.CreateDBSnapshotInput{
+			DBInstanceIdentifier: aws.String(source),
+			DBSnapshotIdentifier: aws.String(snapshotID),
+			Tags:                 tags,
+		})
+	}
+	return err
+}
+
+// copy starts copying the snapshot with sourceARN to targetID encrypted
+// with kmsKeyArn. sourceRegion is the region of the source snapshot if it
+// is not the region of c; the SDK then presigns the request in the source
+// region, which RDS requires to copy an encrypted snapshot across regions.
+func (c snapshotClient) copy(ctx context.Context, sourceARN, sourceRegion, targetID, kmsKeyArn string) error {
+	var region *string
+	if sourceRegion != "" {
+		region = aws.String(sourceRegion)
+	}
+
+	var err error
+	if c.cluster {
+		_, err = c.rds.CopyDBClusterSnapshot(ctx, &rds.CopyDBClusterSnapshotInput{
+			SourceDBClusterSnapshotIdentifier: aws.String(sourceARN),
+			TargetDBClusterSnapshotIdentifier: aws.String(targetID),
+			KmsKeyId:                          aws.String(kmsKeyArn),
+			CopyTags:                          aws.Bool(true),
+			SourceRegion:                      region,
+		})
+	} else {
+		_, err = c.rds.CopyDBSnapshot(ctx, &rds.CopyDBSnapshotInput{
+			SourceDBSnapshotIdentifier: aws.String(sourceARN),
+			TargetDBSnapshotIdentifier: aws.String(targetID),
+			KmsKeyId:                   aws.String(kmsKeyArn),
+			CopyTags:                   aws.Bool(true),
+			SourceRegion:               region,
+		})
+	}
+	return err
+}
+
+// share allows account to copy and restore the snapshot, or disallows it
+// again if allow is false.
+func (c snapshotClient) share(ctx context.Context, snapshotID, account string, allow bool) error {
+	var add, remove []string
+	if allow {
+		add = []string{account}
+	} else {
+		remove = []string{account}
+	}
+
+	var err error
+	if c.cluster {
+		_, err = c.rds.ModifyDBClusterSnapshotAttribute(ctx, &rds.ModifyDBClusterSnapshotAttributeInput{
+			DBClusterSnapshotIdentifier: aws.String(snapshotID),
+			AttributeName:               aws.String("restore"),
+			ValuesToAdd:                 add,
+			ValuesToRemove:              remove,
+		})
+	} else {
+		_, err = c.rds.ModifyDBSnapshotAttribute(ctx, &rds.ModifyDBSnapshotAttributeInput{
+			DBSnapshotIdentifier: aws.String(snapshotID),
+			AttributeName:        aws.String("restore"),
+			ValuesToAdd:          add,
+			ValuesToRemove:       remove,
+		})
+	}
+	return err
+}
+
+func (c snapshotClient) delete(ctx context.Context, snapshotID string) error {
+	var err error
+	if c.cluster {
+		_, err = c.rds.DeleteDBClusterSnapshot(ctx, &rds.DeleteDBClusterSnapshotInput{
+			DBClusterSnapshotIdentifier: aws.String(snapshotID),
+		})
+	} else {
+		_, err = c.rds.DeleteDBSnapshot(ctx, &rds.DeleteDBSnapshotInput{
+			DBSnapshotIdentifier: aws.String(snapshotID),
+		})
+	}
+	return err
+}
+
+// wait blocks until the snapshot is available, for at most maxWait.
+func (c snapshotClient) wait(ctx context.Context, snapshotID string, maxWait time.Duration) error {
+	if c.cluster {
+		waiter := rds.NewDBClusterSnapshotAvailableWaiter(c.rds, func(o *rds.DBClusterSnapshotAvailableWaiterOptions) {
+			o.MinDelay = waiterMinDelay
+			o.MaxDelay = waiterMaxDelay
+		})
+		return waiter.Wait(ctx, &rds.DescribeDBClusterSnapshotsInput{
+			DBClusterSnapshotIdentifier: aws.String(snapshotID),
+		}, maxWait)
+	}
+
+	waiter := rds.NewDBSnapshotAvailableWaiter(c.rds, func(o *rds.DBSnapshotAvailableWaiterOptions) {
+		o.MinDelay = waiterMinDelay
+		o.MaxDelay = waiterMaxDelay
+	})
+	return waiter.Wait(ctx, &rds.DescribeDBSnapshotsInput{
+		DBSnapshotIdentifier: aws.String(snapshotID),
+	}, maxWait)
+}
+
+func instanceSnapshotInfos(snapshots []types.DBSnapshot) []snapshotInfo {
+	infos := make([]snapshotInfo, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		infos = append(infos, snapshotInfo{
+			ID:      aws.ToString(snapshot.DBSnapshotIdentifier),
+			ARN:     aws.ToString(snapshot.DBSnapshotArn),
+			Status:  aws.ToString(snapshot.Status),
+			Created: snapshot.SnapshotCreateTime,
+			SizeGB:  aws.ToInt32(snapshot.AllocatedStorage),
+			Engine:  aws.ToString(snapshot.Engine),
+			Version: aws.ToString(snapshot.EngineVersion),
+			RunID:   tagValue(snapshot.TagList, runIDTag),
+		})
+	}
+	return infos
+}
+
+func clusterSnapshotInfos(snapshots []types.DBClusterSnapshot) []snapshotInfo {
+	infos := make([]snapshotInfo, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		infos = append(infos, snapshotInfo{
+			ID:      aws.ToString(snapshot.DBClusterSnapshotIdentifier),
+			ARN:     aws.ToString(snapshot.DBClusterSnapshotArn),
+			Status:  aws.ToString(snapshot.Status),
+			Created: snapshot.SnapshotCreateTime,
+			SizeGB:  aws.ToInt32(snapshot.AllocatedStorage),
+			Engine:  aws.ToString(snapshot.Engine),
+			Version: aws.ToString(snapshot.EngineVersion),
+			RunID:   tagValue(snapshot.TagList, runIDTag),
+		})
+	}
+	return infos
+}
+
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// ErrPending is returned with Options.NoWait when a snapshot, copy or export
+// is still in progress. Calling Perform again with the same Result picks up
+// where the previous call stopped.
+var ErrPending = errors.New("operation still in progress")
+
+// Stage is one step of the backup pipeline.
+type Stage string
+
+const (
+	StagePreflight    Stage = "preflight"
+	StageSnapshot     Stage = "snapshot"
+	StageExportSource Stage = "export-source"
+	StageCopy         Stage = "copy"
+	StageExportTarget Stage = "export-target"
+	StageCleanup      Stage = "cleanup"
+)
+
+var Stages = []Stage{StagePreflight, StageSnapshot, StageExportSource, StageCopy, StageExportTarget, StageCleanup}
+
+// ParseStages converts step names to stages, rejecting unknown names.
+func ParseStages(names []string) ([]Stage, error) {
+	stages := make([]Stage, 0, len(names))
+	for _, name := range names {
+		stage := Stage(name)
+		if !slices.Contains(Stages, stage) {
+			return nil, fmt.Errorf("unknown step %q (expected one of %v)", name, Stages)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// State records the resources created by a run and the stages that are
+// finished, so that an interrupted run can be resumed.
+type State struct {
+	SourceSnapshotID string  `json:"sourceSnapshotId,omitempty"`
+	TargetSnapshotID string  `json:"targetSnapshotId,omitempty"`
+	Completed        []Stage `json:"completed,omitempty"`
+}
+
+func (s *State) Done(stage Stage) bool {
+	return slices.Contains(s.Completed, stage)
+}
+
+func (s *State) complete(stage Stage) {
+	if !s.Done(stage) {
+		s.Completed = append(s.Completed, stage)
+	}
+}
+
+// completeStage marks a stage as finished and checkpoints the result.
+func (r *Result) completeStage(stage Stage, opts Options) {
+	r.State.complete(stage)
+	r.finishStage(stage)
+	opts.checkpoint(r)
+}
+
+func (o Options) checkpoint(result *Result) {
+	if o.Checkpoint == nil || o.DryRun {
+		return
+	}
+	if err := o.Checkpoint(result); err != nil {
+		log.Printf("Warning: Failed to save run state for %s: %v", result.DBIdentifier, err)
+	}
+}
+
+func (o Options) runs(stage Stage) bool {
+	return len(o.Steps) == 0 || slices.Contains(o.Steps, stage)
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+)
+
+// ExportVerification is what was found in S3 once an export task reported
+// COMPLETE. RDS writes an export_info file, export_tables_info files listing
+// every table of the snapshot with its export status, and the data files of
+// each table under <database>/<table>/. Problems lists anything missing; an
+// export with problems is empty or partial.
+type ExportVerification struct {
+	Objects        int      `json:"objects"`
+	Bytes          int64    `json:"bytes"`
+	InfoFile       bool     `json:"infoFile"`
+	TablesInfo     int      `json:"tablesInfoFiles"`
+	Tables         int      `json:"tables"`
+	ExportedTables int      `json:"exportedTables"`
+	FailedTables   []string `json:"failedTables,omitempty"`
+	MissingTables  []string `json:"missingTables,omitempty"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// Complete reports whether the export was found complete.
+func (v *ExportVerification) Complete() bool {
+	return len(v.Problems) == 0
+}
+
+// Summary describes the export in a few words for notifications.
+func (v *ExportVerification) Summary() string {
+	summary := fmt.Sprintf("%d of %d tables, %d objects, %s", v.ExportedTables, v.Tables, v.Objects, formatBytes(v.Bytes))
+	if !v.Complete() {
+		summary += ", INCOMPLETE"
+	}
+	return summary
+}
+
+// exportTablesInfo is the part of an export_tables_info file that is
+// checked. Target names a table as database.schema.table, or database.table
+// for MySQL.
+type exportTablesInfo struct {
+	PerTableStatus []struct {
+		Target string `json:"target"`
+		Status string `json:"status"`
+	} `json:"perTableStatus"`
+}
+
+// verifyExport lists the files of the export task under prefix in bucket
+// and checks them against the tables the export reports and, for an export
+// limited by exportOnly, that each entry matched an exported table.
+func verifyExport(ctx context.Context, s3Client awsinternal.S3API, bucket, prefix, taskID string, exportOnly []string) (*ExportVerification, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	v := &ExportVerification{}
+
+	var tablesInfoKeys []string
+	// Data files of each table directory, keyed as "database/table"
+	directories := make(map[string]int)
+
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, object := range output.Contents {
+			key := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+			v.Objects++
+			v.Bytes += aws.ToInt64(object.Size)
+
+			switch name := path.Base(key); {
+			case !strings.Contains(key, "/") && name == "export_info_"+taskID+".json":
+				v.InfoFile = true
+			case !strings.Contains(key, "/") && strings.HasPrefix(name, "export_tables_info_"+taskID+"_"):
+				tablesInfoKeys = append(tablesInfoKeys, prefix+key)
+			default:
+				if parts := strings.SplitN(key, "/", 3); len(parts) == 3 && !strings.HasSuffix(key, "/") {
+					directories[parts[0]+"/"+parts[1]]++
+				}
+			}
+		}
+	}
+	v.TablesInfo = len(tablesInfoKeys)
+
+	var targets []string
+	for _, key := range tablesInfoKeys {
+		info, err := readTablesInfo(ctx, s3Client, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range info.PerTableStatus {
+			v.Tables++
+			targets = append(targets, table.Target)
+			switch {
+			case table.Status != "COMPLETE":
+				v.FailedTables = append(v.FailedTables, fmt.Sprintf("%s (%s)", table.Target, table.Status))
+			case !hasDataFiles(directories, table.Target):
+				v.MissingTables = append(v.MissingTables, table.Target)
+			default:
+				v.ExportedTables++
+			}
+		}
+	}
+	sort.Strings(v.FailedTables)
+	sort.Strings(v.MissingTables)
+
+	switch {
+	case v.Objects == 0:
+		v.Problems = append(v.Problems, "no files were exported")
+	case len(directories) == 0:
+		v.Problems = append(v.Problems, "no table data was exported")
+	}
+	if v.Objects > 0 && !v.InfoFile {
+		v.Problems = append(v.Problems, "export_info file is missing")
+	}
+	if v.Objects > 0 && v.TablesInfo == 0 {
+		v.Problems = append(v.Problems, "export_tables_info files are missing")
+	}
+	if len(v.FailedTables) > 0 {
+		v.Problems = append(v.Problems, fmt.Sprintf("%d table(s) failed to export: %s", len(v.FailedTables), strings.Join(v.FailedTables, ", ")))
+	}
+	if len(v.MissingTables) > 0 {
+		v.Problems = append(v.Problems, fmt.Sprintf("%d table(s) have no data files: %s", len(v.MissingTables), strings.Join(v.MissingTables, ", ")))
+	}
+	if v.TablesInfo > 0 {
+		for _, entry := range exportOnly {
+			if !slices.ContainsFunc(targets, func(target string) bool {
+				return target == entry || strings.HasPrefix(target, entry+".")
+			}) {
+				v.Problems = append(v.Problems, fmt.Sprintf("export filter %s matched no table", entry))
+			}
+		}
+	}
+	return v, nil
+}
+
+func readTablesInfo(ctx context.Context, s3Client awsinternal.S3API, bucket, key string) (*exportTablesInfo, error) {
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, err)
+	}
+	var info exportTablesInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid export tables info s3://%s/%s: %w", bucket, key, err)
+	}
+	return &info, nil
+}
+
+// hasDataFiles reports whether the table named target has files in one of
+// the directories. PostgreSQL tables "db.schema.table" are exported to
+// db/schema.table/, MySQL tables "db.table" to db/db.table/.
+func hasDataFiles(directories map[string]int, target string) bool {
+	database, table, _ := strings.Cut(target, ".")
+	return directories[database+"/"+table] > 0 || directories[database+"/"+target] > 0
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+)
+
+func TestVerifyExport(t *testing.T) {
+	const (
+		bucket = "target-backups"
+		prefix = "orders/2025/01/10/copy-backup-orders"
+		taskID = "copy-backup-orders"
+	)
+	data := []byte("PAR1 fake data PAR1")
+
+	// export writes the export_info and export_tables_info files of the
+	// task for tables with a status each, and data files for tables.
+	export := func(put func(key string, data []byte), statuses map[string]string, tables ...string) {
+		put(prefix+"/export_info_"+taskID+".json", []byte(`{"status": "COMPLETE"}`))
+		var entries []string
+		for _, target := range slices.Sorted(maps.Keys(statuses)) {
+			entries = append(entries, fmt.Sprintf(`{"target": %q, "status": %q}`, target, statuses[target]))
+		}
+		put(prefix+"/export_tables_info_"+taskID+"_from_1_to_2.json", []byte(`{"perTableStatus": [`+strings.Join(entries, ", ")+`]}`))
+		for _, table := range tables {
+			put(prefix+"/"+table+"/1/part-00000.gz.parquet", data)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(put func(key string, data []byte))
+		exportOnly []string
+		want       ExportVerification
+	}{
+		{
+			name:  "no objects",
+			setup: func(put func(key string, data []byte)) {},
+			want:  ExportVerification{Problems: []string{"no files were exported"}},
+		},
+		{
+			name: "objects of another prefix only",
+			setup: func(put func(key string, data []byte)) {
+				put(prefix+"-old/export_info_"+taskID+".json", []byte(`{}`))
+			},
+			want: ExportVerification{Problems: []string{"no files were exported"}},
+		},
+		{
+			name: "healthy export",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE", "app.public.items": "COMPLETE"}, "app/public.orders", "app/public.items")
+			},
+			want: ExportVerification{Objects: 4, InfoFile: true, TablesInfo: 1, Tables: 2, ExportedTables: 2},
+		},
+		{
+			name: "MySQL layout",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"shop.orders": "COMPLETE"}, "shop/shop.orders")
+			},
+			want: ExportVerification{Objects: 3, InfoFile: true, TablesInfo: 1, Tables: 1, ExportedTables: 1},
+		},
+		{
+			name: "complete task with missing table files",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE", "app.public.items": "COMPLETE"}, "app/public.orders")
+			},
+			want: ExportVerification{
+				Objects: 3, InfoFile: true, TablesInfo: 1, Tables: 2, ExportedTables: 1,
+				MissingTables: []string{"app.public.items"},
+				Problems:      []string{"1 table(s) have no data files: app.public.items"},
+			},
+		},
+		{
+			name: "complete task without table data",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE"})
+			},
+			want: ExportVerification{
+				Objects: 2, InfoFile: true, TablesInfo: 1, Tables: 1,
+				MissingTables: []string{"app.public.orders"},
+				Problems:      []string{"no table data was exported", "1 table(s) have no data files: app.public.orders"},
+			},
+		},
+		{
+			name: "failed table",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE", "app.public.items": "FAILED"}, "app/public.orders")
+			},
+			want: ExportVerification{
+				Objects: 3, InfoFile: true, TablesInfo: 1, Tables: 2, ExportedTables: 1,
+				FailedTables: []string{"app.public.items (FAILED)"},
+				Problems:     []string{"1 table(s) failed to export: app.public.items (FAILED)"},
+			},
+		},
+		{
+			name: "info files missing",
+			setup: func(put func(key string, data []byte)) {
+				put(prefix+"/app/public.orders/1/part-00000.gz.parquet", data)
+			},
+			want: ExportVerification{
+				Objects:  1,
+				Problems: []string{"export_info file is missing", "export_tables_info files are missing"},
+			},
+		},
+		{
+			name: "filtered export",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE", "reporting.public.daily": "COMPLETE"}, "app/public.orders", "reporting/public.daily")
+			},
+			exportOnly: []string{"app.public.orders", "reporting"},
+			want:       ExportVerification{Objects: 4, InfoFile: true, TablesInfo: 1, Tables: 2, ExportedTables: 2},
+		},
+		{
+			name: "filter that matched no table",
+			setup: func(put func(key string, data []byte)) {
+				export(put, map[string]string{"app.public.orders": "COMPLETE"}, "app/public.orders")
+			},
+			exportOnly: []string{"app.public", "app.audit", "app.public.order"},
+			want: ExportVerification{
+				Objects: 3, InfoFile: true, TablesInfo: 1, Tables: 1, ExportedTables: 1,
+				Problems: []string{"export filter app.audit matched no table", "export filter app.public.order matched no table"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := fake.NewS3()
+			s3.AddBucket(bucket)
+			// Bytes is the size of the objects under the prefix
+			want := tt.want
+			tt.setup(func(key string, data []byte) {
+				s3.Put(bucket, key, data)
+				if strings.HasPrefix(key, prefix+"/") {
+					want.Bytes += int64(len(data))
+				}
+			})
+
+			// The prefix is accepted with or without a trailing slash
+			for _, p := range []string{prefix, prefix + "/"} {
+				v, err := verifyExport(context.Background(), s3, bucket, p, taskID, tt.exportOnly)
+				if err != nil {
+					t.Fatalf("verifyExport: %v", err)
+				}
+				if fmt.Sprintf("%+v", *v) != fmt.Sprintf("%+v", want) {
+					t.Errorf("prefix %q: verification\n%+v\nwant\n%+v", p, *v, want)
+				}
+				if v.Complete() != (len(tt.want.Problems) == 0) {
+					t.Errorf("prefix %q: Complete() = %v", p, v.Complete())
+				}
+			}
+		})
+	}
+}
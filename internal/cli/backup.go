@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/scheduler"
+)
+
+func runBackupNow(args []string) error {
+	fs, cf := newFlagSet("backup-now")
+	dryRun := fs.Bool("dry-run", false, "Make only read-only calls and report the calls that would change something")
+	noExport := fs.Bool("no-export", false, "Take and copy the snapshot without exporting it to S3")
+	notify := fs.Bool("notify", true, "Send the notification emails")
+	jsonOut := fs.Bool("json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	s, err := scheduler.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C interrupts the backups; they save their state and are reported
+	// as interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := s.Run(ctx, cfg.Databases, backup.Options{
+		SkipExport: *noExport,
+		DryRun:     *dryRun,
+	})
+	if *notify {
+		scheduler.Notify(cfg, results)
+	}
+
+	if *jsonOut {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.ErrorMessage != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d backup(s) failed", failed, len(results))
+	}
+	return nil
+}
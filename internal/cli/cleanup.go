@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+)
+
+func runCleanup(args []string) error {
+	fs, cf := newFlagSet("cleanup")
+	dryRun := fs.Bool("dry-run", false, "Only report the snapshots that would be deleted")
+	jsonOut := fs.Bool("json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	clients, err := aws.NewClients(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	var results []*backup.Result
+	var failed int
+	for i := range cfg.Databases {
+		db := &cfg.Databases[i]
+		result := &backup.Result{
+			DBIdentifier: db.DBIdentifier,
+			BackupTime:   time.Now().Format(time.RFC3339),
+			DryRun:       *dryRun,
+		}
+		if err := backup.CleanupOldSnapshots(ctx, clients, db, backup.Options{DryRun: *dryRun}, result); err != nil {
+			result.ErrorMessage = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	if *jsonOut {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("cleanup failed for %d of %d database(s)", failed, len(results))
+	}
+	return nil
+}
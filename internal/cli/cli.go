@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"run", "Run the backup scheduler as a daemon (default)", runDaemon},
+	{"backup-now", "Back up databases once and exit", runBackupNow},
+	{"list-snapshots", "List backup snapshots in both regions", runListSnapshots},
+	{"list-exports", "List S3 export tasks in both regions", runListExports},
+	{"cleanup", "Apply the retention policies without taking a backup", runCleanup},
+	{"verify-config", "Validate the configuration and print it with secrets redacted", runVerifyConfig},
+	{"restore", "Restore a backup snapshot to a new DB instance and wait for it", runRestore},
+	{"drill", "Restore the latest copies to throwaway instances, check and delete them", runDrill},
+	{"lambda", "Serve backup events as an AWS Lambda function", runLambda},
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit
+// code. Without arguments the scheduler daemon is started, or the Lambda
+// handler when running inside the Lambda runtime.
+func Run(args []string) int {
+	name := "run"
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		name = "lambda"
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				if err == flag.ErrHelp {
+					return 0
+				}
+				log.Printf("%s: %v", name, err)
+				return 1
+			}
+			return 0
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: rds-backup <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'rds-backup <command> -h' for the flags of a command.")
+}
+
+// configFlags are the flags shared by every command. Each one overrides the
+// env var of the same meaning before config.Load reads the environment.
+type configFlags struct {
+	fs  *flag.FlagSet
+	env map[string]string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *configFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cf := &configFlags{fs: fs, env: make(map[string]string)}
+
+	cf.string("config", "CONFIG_FILE", "YAML configuration file, overridden by env vars")
+	cf.string("db", "DB_IDENTIFIERS", "Comma separated DB identifiers to work on")
+	cf.string("source-region", "SOURCE_REGION", "Region of the DB instances")
+	cf.string("target-region", "TARGET_REGION", "DR region snapshots are copied to")
+	cf.string("source-bucket", "SOURCE_BUCKET", "S3 bucket in the source region")
+	cf.string("target-bucket", "TARGET_BUCKET", "S3 bucket in the target region")
+	cf.string("kms-key-id", "KMS_KEY_ID", "KMS key of both regions, usually an alias")
+	cf.string("source-kms-key-id", "SOURCE_KMS_KEY_ID", "KMS key ID, ARN or alias in the source region")
+	cf.string("target-kms-key-id", "TARGET_KMS_KEY_ID", "KMS key ID, ARN or alias in the target region")
+	cf.string("export-role-arn", "EXPORT_ROLE_ARN", "IAM role ARN used by RDS exports")
+	cf.string("keep-source-snapshot", "KEEP_SOURCE_SNAPSHOT", "Keep the source snapshot after copying (true/false)")
+	cf.string("store-to-source-s3", "STORE_TO_SOURCE_S3", "Export the snapshot to the source bucket (true/false)")
+	cf.string("emails", "ADMIN_EMAILS", "Comma separated notification recipients")
+	cf.string("notification-mode", "NOTIFICATION_MODE", "per-database or digest")
+	cf.string("max-concurrent", "MAX_CONCURRENT_BACKUPS", "Maximum number of databases backed up at once")
+
+	return fs, cf
+}
+
+func (cf *configFlags) string(name, envVar, usage string) {
+	cf.fs.String(name, "", fmt.Sprintf("%s (overrides %s)", usage, envVar))
+	cf.env[name] = envVar
+}
+
+// apply sets the env vars of the flags that were given on the command line.
+func (cf *configFlags) apply() {
+	cf.fs.Visit(func(f *flag.Flag) {
+		if envVar, ok := cf.env[f.Name]; ok {
+			os.Setenv(envVar, f.Value.String())
+		}
+	})
+}
+
+// load applies the flags and loads the configuration.
+func (cf *configFlags) load() (*config.Config, error) {
+	cf.apply()
+	return config.Load()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
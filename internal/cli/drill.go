@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/unplank/rds-backup-lambda/internal/drill"
+	"github.com/unplank/rds-backup-lambda/internal/notification"
+)
+
+func runDrill(args []string) error {
+	fs, cf := newFlagSet("drill")
+	notify := fs.Bool("notify", true, "Send the drill notifications")
+	jsonOut := fs.Bool("json", false, "Print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C stops the drills; their instances are still deleted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []*drill.Result
+	for i := range cfg.Databases {
+		result := drill.Run(ctx, cfg, &cfg.Databases[i])
+		if *notify {
+			notification.SendDrill(context.Background(), cfg, result)
+		}
+		results = append(results, result)
+	}
+
+	if *jsonOut {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d drill(s) failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package cli
+
+import "github.com/unplank/rds-backup-lambda/internal/lambdafn"
+
+func runLambda(args []string) error {
+	fs, cf := newFlagSet("lambda")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// The handler loads the configuration on every invocation
+	cf.apply()
+	lambdafn.Start()
+	return nil
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+)
+
+func runListSnapshots(args []string) error {
+	fs, cf := newFlagSet("list-snapshots")
+	jsonOut := fs.Bool("json", false, "Print the snapshots as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	clients, err := aws.NewClients(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	var snapshots []backup.SnapshotInfo
+	for i := range cfg.Databases {
+		found, err := backup.ListSnapshots(ctx, clients, &cfg.Databases[i])
+		if err != nil {
+			return fmt.Errorf("failed to list snapshots of %s: %w", cfg.Databases[i].DBIdentifier, err)
+		}
+		snapshots = append(snapshots, found...)
+	}
+
+	if *jsonOut {
+		return writeJSON(os.Stdout, snapshots)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SNAPSHOT\tREGION\tSTATUS\tCREATED\tSIZE (GB)")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", s.ID, s.Region, s.Status, s.Created.Format(time.RFC3339), s.SizeGB)
+	}
+	return tw.Flush()
+}
+
+func runListExports(args []string) error {
+	fs, cf := newFlagSet("list-exports")
+	jsonOut := fs.Bool("json", false, "Print the export tasks as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	clients, err := aws.NewClients(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	var exports []backup.ExportInfo
+	for i := range cfg.Databases {
+		found, err := backup.ListExports(ctx, clients, &cfg.Databases[i])
+		if err != nil {
+			return fmt.Errorf("failed to list exports of %s: %w", cfg.Databases[i].DBIdentifier, err)
+		}
+		exports = append(exports, found...)
+	}
+
+	if *jsonOut {
+		return writeJSON(os.Stdout, exports)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EXPORT TASK\tREGION\tSTATUS\tPROGRESS\tLOCATION")
+	for _, e := range exports {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d%%\t%s\n", e.ID, e.Region, e.Status, e.Progress, e.Location)
+	}
+	return tw.Flush()
+}
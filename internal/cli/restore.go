@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/restore"
+)
+
+func runRestore(args []string) error {
+	fs, cf := newFlagSet("restore")
+	snapshotID := fs.String("snapshot", "", "Identifier of the snapshot to restore")
+	date := fs.String("date", "", "Restore the newest snapshot taken on this day, as YYYY-MM-DD in UTC (default the newest snapshot)")
+	instanceID := fs.String("instance", "", "Identifier of the new DB instance, or DB cluster")
+	instanceClass := fs.String("instance-class", "", "DB instance class of the new instance (defaults to the snapshot's, required for clusters)")
+	subnetGroup := fs.String("subnet-group", "", "DB subnet group of the new instance")
+	securityGroups := fs.String("security-groups", "", "Comma separated VPC security group IDs of the new instance")
+	parameterGroup := fs.String("parameter-group", "", "DB parameter group of the new instance, or DB cluster parameter group")
+	region := fs.String("region", "", "Region to restore in: source or target (defaults to the region of -snapshot, or target)")
+	noWait := fs.Bool("no-wait", false, "Return once the restore has started instead of waiting for the instance")
+	maxWait := fs.Duration("max-wait", restore.DefaultMaxWait, "Maximum time to wait for the instance to become available")
+	jsonOut := fs.Bool("json", false, "Print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := restore.Options{
+		Region:         *region,
+		SnapshotID:     *snapshotID,
+		InstanceID:     *instanceID,
+		InstanceClass:  *instanceClass,
+		SubnetGroup:    *subnetGroup,
+		SecurityGroups: config.SplitList(*securityGroups),
+		ParameterGroup: *parameterGroup,
+		NoWait:         *noWait,
+		MaxWait:        *maxWait,
+	}
+	if opts.SnapshotID != "" && *date != "" {
+		return errors.New("-snapshot and -date are mutually exclusive")
+	}
+	if *date != "" {
+		day, err := time.Parse(time.DateOnly, *date)
+		if err != nil {
+			return fmt.Errorf("invalid -date %q (expected YYYY-MM-DD)", *date)
+		}
+		opts.Date = day
+	}
+	if opts.Region == "" {
+		opts.Region = restore.RegionTarget
+		if strings.HasPrefix(opts.SnapshotID, "backup-") {
+			opts.Region = restore.RegionSource
+		}
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	db, err := restoreDatabase(cfg, opts.SnapshotID)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	clients, err := aws.NewClients(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	result, err := restore.Restore(ctx, clients, db, opts)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return writeJSON(os.Stdout, result)
+	}
+	fmt.Printf("Snapshot:  %s (%s)\n", result.SnapshotID, result.Region)
+	if result.ClusterID != "" {
+		fmt.Printf("Cluster:   %s\n", result.ClusterID)
+	}
+	fmt.Printf("Instance:  %s\n", result.InstanceID)
+	fmt.Printf("Status:    %s\n", result.Status)
+	if address := result.Address(); address != "" {
+		fmt.Printf("Endpoint:  %s\n", address)
+	}
+	return nil
+}
+
+// restoreDatabase returns the database to restore: the only one configured,
+// or the one whose snapshot is being restored.
+func restoreDatabase(cfg *config.Config, snapshotID string) (*config.Database, error) {
+	if len(cfg.Databases) == 1 {
+		return &cfg.Databases[0], nil
+	}
+
+	// Prefer the longest identifier, so that a snapshot of "orders-archive"
+	// is not taken for one of "orders".
+	var db *config.Database
+	for i := range cfg.Databases {
+		id := cfg.Databases[i].DBIdentifier
+		if strings.HasPrefix(snapshotID, "backup-"+id+"-") || strings.HasPrefix(snapshotID, "copy-backup-"+id+"-") {
+			if db == nil || len(id) > len(db.DBIdentifier) {
+				db = &cfg.Databases[i]
+			}
+		}
+	}
+	if db == nil {
+		return nil, errors.New("several databases are configured: select one with -db or give its -snapshot")
+	}
+	return db, nil
+}
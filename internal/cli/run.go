@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/unplank/rds-backup-lambda/internal/control"
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+	"github.com/unplank/rds-backup-lambda/internal/scheduler"
+)
+
+func runDaemon(args []string) error {
+	fs, cf := newFlagSet("run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	s, err := scheduler.New(cfg)
+	if err != nil {
+		return fmt.Errorf("error scheduling backup: %w", err)
+	}
+
+	ctx, stopServers := context.WithCancel(context.Background())
+	defer stopServers()
+	if cfg.MetricsAddr != "" {
+		if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+			return err
+		}
+		s.SeedMetrics(ctx)
+	}
+	if cfg.ControlAddr != "" {
+		if err := control.Serve(ctx, cfg.ControlAddr, s, string(cfg.ControlToken)); err != nil {
+			return err
+		}
+	}
+
+	// Start the scheduler
+	s.Start()
+	log.Printf("Backup scheduler started for %d database(s)", len(cfg.Databases))
+
+	// Pick up the runs that were interrupted by the last shutdown
+	go s.Resume()
+
+	// Handle graceful shutdown
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	received := <-sig
+	signal.Stop(sig)
+
+	log.Printf("Received %s, stopping backup scheduler (grace period %s)", received, cfg.ShutdownGracePeriod)
+	s.Shutdown(cfg.ShutdownGracePeriod)
+
+	log.Println("Backup scheduler stopped successfully")
+	return nil
+}
@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+)
+
+func runVerifyConfig(args []string) error {
+	fs, cf := newFlagSet("verify-config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// config.Load lists every problem if the configuration is invalid. The
+	// effective configuration is printed with secrets redacted.
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSON(os.Stdout, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return nil
+}
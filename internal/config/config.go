@@ -1,29 +1,208 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/robfig/cron/v3"
+	"github.com/unplank/rds-backup-lambda/internal/retention"
 )
 
-type Config struct {
-	SourceRegion       string
-	TargetRegion       string
+const (
+	NotifyPerDatabase = "per-database"
+	NotifyDigest      = "digest"
+
+	DefaultSchedule = "0 0 * * *"
+
+	// ModeInstance backs up an RDS DB instance, ModeCluster an Aurora DB
+	// cluster.
+	ModeInstance = "instance"
+	ModeCluster  = "cluster"
+
+	DefaultShutdownGracePeriod = 30 * time.Second
+	DefaultStateRetention      = 30 * 24 * time.Hour
+)
+
+// Database holds the per-database settings. Every field can be set for a
+// single database with an env var prefixed by its identifier (for example
+// ORDERS_DB_SOURCE_BUCKET for "orders-db") and falls back to the unprefixed
+// variable. Mode is ModeInstance or ModeCluster.
+type Database struct {
 	DBIdentifier       string
+	Mode               string
 	SourceBucket       string
 	TargetBucket       string
-	KMSKeyID           string
 	ExportRoleARN      string
 	KeepSourceSnapshot bool
 	StoreToSourceS3    bool
-	AdminEmail         string
-	Emails             []string
+	// SourceKMSKeyID encrypts the exports of the source region and
+	// TargetKMSKeyID the copies and exports of the target region. Each may be
+	// a key ID, key ARN, alias name or alias ARN of a key in its region.
+	// With a vault the target region uses the vault's keys instead.
+	SourceKMSKeyID string
+	TargetKMSKeyID string
+	// SourceExportOnly and TargetExportOnly limit the S3 exports of each
+	// region to the listed databases, schemas and tables, written as
+	// "database", "database.schema" or "database.schema.table" (database.table
+	// for MySQL). Empty exports everything; the snapshots are always full.
+	SourceExportOnly []string
+	TargetExportOnly []string
+	// SourceS3Prefix and TargetS3Prefix are templates of the key prefix the
+	// exports of each region are written under, expanded by ExpandS3Prefix.
+	// Empty writes them at the root of the bucket.
+	SourceS3Prefix  string
+	TargetS3Prefix  string
+	Schedules       []Schedule
+	SourceRetention retention.Policy
+	TargetRetention retention.Policy
+	Drill           Drill
+}
+
+// Schedule is one cron trigger for a database. SkipExport takes and copies
+// the snapshot without exporting it to S3.
+type Schedule struct {
+	Name       string
+	Spec       string
+	Timezone   string
+	Jitter     time.Duration
+	SkipExport bool
+}
+
+type Config struct {
+	SourceRegion     string
+	TargetRegion     string
+	Databases        []Database
+	MaxConcurrency   int
+	NotificationMode string
+	StateStore       string
+	// StateRetention is how long finished runs are kept in the state store.
+	StateRetention time.Duration
+	// ReportDir, if set, receives the JSON report of every run.
+	ReportDir string
+	// MetricsAddr, if set, is the listen address of the Prometheus metrics
+	// endpoint, for example ":9090".
+	MetricsAddr string
+	// ControlAddr, if set, is the listen address of the control API, which
+	// requires ControlToken as a bearer token.
+	ControlAddr  string
+	ControlToken Secret
+	// ShutdownGracePeriod is how long in-flight backups get to save their
+	// state and notify after SIGINT or SIGTERM.
+	ShutdownGracePeriod time.Duration
+	Emails              []string
+	Channels            []Channel
+	// SourceRole and TargetRole are assumed by the clients of each region,
+	// TargetRole being the vault role when Vault is enabled.
+	SourceRole Role
+	TargetRole Role
+	Vault      Vault
+}
+
+// Secret is a setting such as a password or token. It is printed and
+// marshalled redacted, so that the effective configuration can be shown.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// ValidationError lists every problem found in the configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// loader reads settings from the environment and, below it, from the
+// configuration file, and collects the problems it finds so that they are
+// all reported at once.
+type loader struct {
+	file map[string]string
+	// fileKeys holds the key in the file of each setting of file
+	fileKeys map[string]string
+	problems []string
+}
+
+func (l *loader) errorf(format string, args ...any) {
+	problem := fmt.Sprintf(format, args...)
+	// Settings shared by both regions or several databases are reported once
+	if !slices.Contains(l.problems, problem) {
+		l.problems = append(l.problems, problem)
+	}
+}
+
+// getenv returns the env var key or, if it is not set, the value the
+// configuration file gives it.
+func (l *loader) getenv(key string) string {
+	return l.lookup(key)
+}
+
+// lookup returns the first of the env vars keys that is set or, if none is,
+// the value the configuration file gives the first of them it sets. Env
+// vars always override the file.
+func (l *loader) lookup(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	for _, key := range keys {
+		if v := l.file[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// fromFile returns where in the configuration file lookup(keys...) found
+// its value, to be added to the name of the setting in error messages, or
+// "" if the value comes from the environment.
+func (l *loader) fromFile(keys ...string) string {
+	for _, key := range keys {
+		if os.Getenv(key) != "" {
+			return ""
+		}
+	}
+	for _, key := range keys {
+		if path, ok := l.fileKeys[key]; ok {
+			return fmt.Sprintf(" (%s in the config file)", path)
+		}
+	}
+	return ""
+}
+
+// setting names the env var key in error messages.
+func (l *loader) setting(key string) string {
+	return key + l.fromFile(key)
+}
+
+// databaseSetting names the setting key of database id in error messages.
+func (l *loader) databaseSetting(id, key string) string {
+	return key + l.fromFile(EnvPrefix(id)+"_"+key, key)
 }
 
-func Load() *Config {
+// Load reads the configuration from the environment, a .env file and the
+// configuration file named by CONFIG_FILE, if any. Env vars override the
+// file. A *ValidationError lists every problem found.
+func Load() (*Config, error) {
 	// Load .env file
 	err := godotenv.Load()
 	if err != nil {
@@ -43,94 +222,456 @@ func Load() *Config {
 		}
 	}
 
+	l := &loader{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		l.file, l.fileKeys = values.values, values.keys
+	}
+
+	cfg := l.load()
+	if len(l.problems) > 0 {
+		return nil, &ValidationError{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+func (l *loader) load() *Config {
 	requiredEnvVars := []string{
 		"SOURCE_REGION",
 		"TARGET_REGION",
-		"DB_IDENTIFIER",
+	}
+
+	for _, envVar := range requiredEnvVars {
+		if l.getenv(envVar) == "" {
+			l.errorf("Missing required environment variable: %s", envVar)
+		}
+	}
+	sourceRegion, targetRegion := l.getenv("SOURCE_REGION"), l.getenv("TARGET_REGION")
+	for key, region := range map[string]string{"SOURCE_REGION": sourceRegion, "TARGET_REGION": targetRegion} {
+		if region != "" && !validRegion(region) {
+			l.errorf("Invalid %s: %q is not an AWS region", l.setting(key), region)
+		}
+	}
+
+	identifiers := SplitList(l.getenv("DB_IDENTIFIERS"))
+	if len(identifiers) == 0 {
+		identifiers = SplitList(l.getenv("DB_IDENTIFIER"))
+	}
+	if len(identifiers) == 0 {
+		l.errorf("Missing required environment variable: DB_IDENTIFIERS or DB_IDENTIFIER")
+	}
+
+	databases := make([]Database, 0, len(identifiers))
+	for _, id := range identifiers {
+		databases = append(databases, l.loadDatabase(id, sourceRegion, targetRegion))
+	}
+
+	maxConcurrency := 2
+	if v := l.getenv("MAX_CONCURRENT_BACKUPS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			l.errorf("Invalid %s: %q", l.setting("MAX_CONCURRENT_BACKUPS"), v)
+		}
+		maxConcurrency = n
+	}
+
+	notificationMode := l.getenv("NOTIFICATION_MODE")
+	switch notificationMode {
+	case "":
+		notificationMode = NotifyPerDatabase
+	case NotifyPerDatabase, NotifyDigest:
+	default:
+		l.errorf("Invalid %s: %q (expected %q or %q)", l.setting("NOTIFICATION_MODE"), notificationMode, NotifyPerDatabase, NotifyDigest)
+	}
+
+	gracePeriod := DefaultShutdownGracePeriod
+	if v := l.getenv("SHUTDOWN_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			l.errorf("Invalid %s: %q", l.setting("SHUTDOWN_GRACE_PERIOD"), v)
+		}
+		gracePeriod = d
+	}
+
+	stateRetention := DefaultStateRetention
+	if v := l.getenv("STATE_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			l.errorf("Invalid %s: %q", l.setting("STATE_RETENTION"), v)
+		}
+		stateRetention = d
+	}
+
+	emails := SplitList(l.getenv("ADMIN_EMAILS"))
+	for _, email := range emails {
+		if !validEmail(email) {
+			l.errorf("Invalid %s entry: %q is not an email address", l.setting("ADMIN_EMAILS"), email)
+		}
+	}
+
+	targetRole, vault := l.loadRole("TARGET"), l.loadVault(targetRegion)
+	if vault.Enabled() {
+		if targetRole.ARN != "" || targetRole.ExternalID != "" {
+			l.errorf("Invalid %s: the target role is VAULT_ROLE_ARN when a vault is configured", l.setting("TARGET_ROLE_ARN"))
+		}
+		targetRole.ARN, targetRole.ExternalID = vault.RoleARN, vault.ExternalID
+	}
+
+	controlAddr := l.getenv("CONTROL_API_ADDR")
+	if controlAddr != "" && l.getenv("CONTROL_API_TOKEN") == "" {
+		l.errorf("Missing required environment variable: CONTROL_API_TOKEN must be set with CONTROL_API_ADDR")
+	}
+
+	return &Config{
+		SourceRegion:        sourceRegion,
+		TargetRegion:        targetRegion,
+		Databases:           databases,
+		MaxConcurrency:      maxConcurrency,
+		NotificationMode:    notificationMode,
+		StateStore:          l.getenv("STATE_STORE"),
+		StateRetention:      stateRetention,
+		ReportDir:           l.getenv("REPORT_DIR"),
+		MetricsAddr:         l.getenv("METRICS_ADDR"),
+		ControlAddr:         controlAddr,
+		ControlToken:        Secret(l.getenv("CONTROL_API_TOKEN")),
+		ShutdownGracePeriod: gracePeriod,
+		Emails:              emails,
+		Channels:            l.loadChannels(sourceRegion, emails),
+		SourceRole:          l.loadRole("SOURCE"),
+		TargetRole:          targetRole,
+		Vault:               vault,
+	}
+}
+
+func (l *loader) loadDatabase(id, sourceRegion, targetRegion string) Database {
+	requiredEnvVars := []string{
 		"SOURCE_BUCKET",
 		"TARGET_BUCKET",
-		"KMS_KEY_ID",
 		"EXPORT_ROLE_ARN",
-		"ADMIN_EMAIL",
 		"KEEP_SOURCE_SNAPSHOT",
 		"STORE_TO_SOURCE_S3",
-		"ADMIN_EMAILS",
 	}
 
 	for _, envVar := range requiredEnvVars {
-		if os.Getenv(envVar) == "" {
-			log.Fatalf("Missing required environment variable: %s", envVar)
+		if l.databaseEnv(id, envVar) == "" {
+			l.errorf("Missing required environment variable for %s: %s or %s", id, EnvPrefix(id)+"_"+envVar, envVar)
 		}
 	}
 
-	return &Config{
-		SourceRegion:  os.Getenv("SOURCE_REGION"),
-		TargetRegion:  os.Getenv("TARGET_REGION"),
-		DBIdentifier:  os.Getenv("DB_IDENTIFIER"),
-		SourceBucket:  os.Getenv("SOURCE_BUCKET"),
-		TargetBucket:  os.Getenv("TARGET_BUCKET"),
-		KMSKeyID:      os.Getenv("KMS_KEY_ID"),
-		ExportRoleARN: os.Getenv("EXPORT_ROLE_ARN"),
-		AdminEmail:    os.Getenv("ADMIN_EMAIL"),
-		Emails: func() []string {
-			emails := os.Getenv("ADMIN_EMAILS")
-			return strings.Split(emails, ",")
-		}(),
-		KeepSourceSnapshot: os.Getenv("KEEP_SOURCE_SNAPSHOT") == "true",
-		StoreToSourceS3:    os.Getenv("STORE_TO_SOURCE_S3") == "true",
-	}
-}
-
-// package config
-
-// import (
-// 	"log"
-// 	"os"
-// )
-
-// type Config struct {
-// 	SourceRegion       string
-// 	TargetRegion       string
-// 	DBIdentifier       string
-// 	SourceBucket       string
-// 	TargetBucket       string
-// 	KMSKeyID           string
-// 	ExportRoleARN      string
-// 	KeepSourceSnapshot bool
-// 	StoreToSourceS3    bool
-// 	AdminEmail         string
-// }
-
-// func Load() *Config {
-// 	requiredEnvVars := []string{
-// 		"SOURCE_REGION",
-// 		"TARGET_REGION",
-// 		"DB_IDENTIFIER",
-// 		"SOURCE_BUCKET",
-// 		"TARGET_BUCKET",
-// 		"KMS_KEY_ID",
-// 		"EXPORT_ROLE_ARN",
-// 		"ADMIN_EMAIL",
-// 		"KEEP_SOURCE_SNAPSHOT",
-// 		"STORE_TO_SOURCE_S3",
-// 	}
-
-// 	for _, envVar := range requiredEnvVars {
-// 		if os.Getenv(envVar) == "" {
-// 			log.Fatalf("Missing required environment variable: %s", envVar)
-// 		}
-// 	}
-
-// 	return &Config{
-// 		SourceRegion:       os.Getenv("SOURCE_REGION"),
-// 		TargetRegion:       os.Getenv("TARGET_REGION"),
-// 		DBIdentifier:       os.Getenv("DB_IDENTIFIER"),
-// 		SourceBucket:       os.Getenv("SOURCE_BUCKET"),
-// 		TargetBucket:       os.Getenv("TARGET_BUCKET"),
-// 		KMSKeyID:           os.Getenv("KMS_KEY_ID"),
-// 		ExportRoleARN:      os.Getenv("EXPORT_ROLE_ARN"),
-// 		AdminEmail:         os.Getenv("ADMIN_EMAIL"),
-// 		KeepSourceSnapshot: os.Getenv("KEEP_SOURCE_SNAPSHOT") == "true",
-// 		StoreToSourceS3:    os.Getenv("STORE_TO_SOURCE_S3") == "true",
-// 	}
-// }
+	mode := l.databaseEnv(id, "DB_MODE")
+	switch mode {
+	case "":
+		mode = ModeInstance
+	case ModeInstance, ModeCluster:
+	default:
+		l.errorf("Invalid %s for %s: %q (expected %q or %q)", l.databaseSetting(id, "DB_MODE"), id, mode, ModeInstance, ModeCluster)
+	}
+
+	for _, key := range []string{"SOURCE_BUCKET", "TARGET_BUCKET"} {
+		l.checkBucket(id, key, l.databaseEnv(id, key))
+	}
+	l.checkRoleARN(id, "EXPORT_ROLE_ARN", l.databaseEnv(id, "EXPORT_ROLE_ARN"))
+	for _, key := range []string{"KEEP_SOURCE_SNAPSHOT", "STORE_TO_SOURCE_S3"} {
+		if v := l.databaseEnv(id, key); v != "" && v != "true" && v != "false" {
+			l.errorf("Invalid %s for %s: %q (expected true or false)", l.databaseSetting(id, key), id, v)
+		}
+	}
+
+	return Database{
+		DBIdentifier:       id,
+		Mode:               mode,
+		SourceBucket:       l.databaseEnv(id, "SOURCE_BUCKET"),
+		TargetBucket:       l.databaseEnv(id, "TARGET_BUCKET"),
+		ExportRoleARN:      l.databaseEnv(id, "EXPORT_ROLE_ARN"),
+		KeepSourceSnapshot: l.databaseEnv(id, "KEEP_SOURCE_SNAPSHOT") == "true",
+		StoreToSourceS3:    l.databaseEnv(id, "STORE_TO_SOURCE_S3") == "true",
+		SourceKMSKeyID:     l.loadKMSKey(id, "SOURCE_KMS_KEY_ID", sourceRegion, l.databaseEnv(id, "STORE_TO_SOURCE_S3") == "true"),
+		TargetKMSKeyID:     l.loadKMSKey(id, "TARGET_KMS_KEY_ID", targetRegion, l.getenv("VAULT_ROLE_ARN") == ""),
+		SourceExportOnly:   l.loadExportOnly(id, "SOURCE_EXPORT_ONLY"),
+		TargetExportOnly:   l.loadExportOnly(id, "TARGET_EXPORT_ONLY"),
+		SourceS3Prefix:     l.loadS3Prefix(id, "SOURCE_S3_PREFIX"),
+		TargetS3Prefix:     l.loadS3Prefix(id, "TARGET_S3_PREFIX"),
+		Schedules:          l.loadSchedules(id),
+		SourceRetention:    l.loadRetention(id, "SOURCE_RETENTION"),
+		TargetRetention:    l.loadRetention(id, "TARGET_RETENTION"),
+		Drill:              l.loadDrill(id, mode),
+	}
+}
+
+// loadExportOnly reads the export filter for one region, falling back to
+// EXPORT_ONLY.
+func (l *loader) loadExportOnly(id, key string) []string {
+	value := l.databaseEnv(id, key)
+	if value == "" {
+		key = "EXPORT_ONLY"
+		value = l.databaseEnv(id, key)
+	}
+
+	entries := SplitList(value)
+	for _, entry := range entries {
+		parts := strings.Split(entry, ".")
+		if len(parts) > 3 || slices.Contains(parts, "") {
+			l.errorf("Invalid %s entry for %s: %q (expected database, database.schema or database.schema.table)", l.databaseSetting(id, key), id, entry)
+		}
+	}
+	return entries
+}
+
+// loadS3Prefix reads the export prefix template for one region, falling
+// back to S3_PREFIX.
+func (l *loader) loadS3Prefix(id, key string) string {
+	value := l.databaseEnv(id, key)
+	if value == "" {
+		key = "S3_PREFIX"
+		value = l.databaseEnv(id, key)
+	}
+	if err := validateS3Prefix(value); err != nil {
+		l.errorf("Invalid %s for %s: %q: %v", l.databaseSetting(id, key), id, value, err)
+	}
+	return value
+}
+
+// loadKMSKey reads the KMS key of one region, falling back to KMS_KEY_ID.
+func (l *loader) loadKMSKey(id, key, region string, required bool) string {
+	value := l.databaseEnv(id, key)
+	if value == "" {
+		value = l.databaseEnv(id, "KMS_KEY_ID")
+		if value == "" {
+			if required {
+				l.errorf("Missing required environment variable for %s: %s, %s or KMS_KEY_ID", id, EnvPrefix(id)+"_"+key, key)
+			}
+			return ""
+		}
+		key = "KMS_KEY_ID"
+	}
+	l.checkKMSKey(id, key, value, region)
+	return value
+}
+
+// checkBucket checks the bucket setting key of database id, if it is set.
+func (l *loader) checkBucket(id, key, bucket string) {
+	if bucket != "" && !validBucket(bucket) {
+		l.errorf("Invalid %s for %s: %q is not a valid S3 bucket name", l.databaseSetting(id, key), id, bucket)
+	}
+}
+
+// checkRoleARN checks the role setting key of database id, if it is set.
+func (l *loader) checkRoleARN(id, key, arn string) {
+	if arn != "" && !validRoleARN(arn) {
+		l.errorf("Invalid %s for %s: %q (expected arn:aws:iam::<account>:role/<name>)", l.databaseSetting(id, key), id, arn)
+	}
+}
+
+// checkKMSKey checks the KMS key setting key of database id. An ARN must
+// name a key or alias of region.
+func (l *loader) checkKMSKey(id, key, value, region string) {
+	switch {
+	case !validKMSKey(value):
+		l.errorf("Invalid %s for %s: %q (expected a key ID, key ARN, alias name or alias ARN)", l.databaseSetting(id, key), id, value)
+	case strings.HasPrefix(value, "arn:") && region != "" && strings.Split(value, ":")[3] != region:
+		l.errorf("Invalid %s for %s: %q is not in %s", l.databaseSetting(id, key), id, value, region)
+	}
+}
+
+// Validate checks the buckets, export role and KMS keys of db with the
+// rules of Load, for settings changed after loading such as the overrides
+// of a single run. A *ValidationError lists every problem found.
+func (db *Database) Validate(cfg *Config) error {
+	sourceRegion, targetRegion := cfg.SourceRegion, cfg.TargetRegion
+	l := &loader{}
+	id := db.DBIdentifier
+	for _, setting := range []struct{ key, value string }{
+		{"SOURCE_BUCKET", db.SourceBucket},
+		{"TARGET_BUCKET", db.TargetBucket},
+		{"EXPORT_ROLE_ARN", db.ExportRoleARN},
+	} {
+		if setting.value == "" {
+			l.errorf("Missing required setting for %s: %s", id, setting.key)
+		}
+	}
+	l.checkBucket(id, "SOURCE_BUCKET", db.SourceBucket)
+	l.checkBucket(id, "TARGET_BUCKET", db.TargetBucket)
+	l.checkRoleARN(id, "EXPORT_ROLE_ARN", db.ExportRoleARN)
+
+	if db.SourceKMSKeyID != "" {
+		l.checkKMSKey(id, "SOURCE_KMS_KEY_ID", db.SourceKMSKeyID, sourceRegion)
+	} else if db.StoreToSourceS3 {
+		l.errorf("Missing required setting for %s: SOURCE_KMS_KEY_ID, for exports in the source region", id)
+	}
+	if db.TargetKMSKeyID != "" {
+		l.checkKMSKey(id, "TARGET_KMS_KEY_ID", db.TargetKMSKeyID, targetRegion)
+	} else if !cfg.Vault.Enabled() {
+		l.errorf("Missing required setting for %s: TARGET_KMS_KEY_ID", id)
+	}
+
+	if len(l.problems) > 0 {
+		return &ValidationError{Problems: l.problems}
+	}
+	return nil
+}
+
+// loadRetention reads the retention policy for one region, falling back to
+// RETENTION and then to retention.DefaultPolicy.
+func (l *loader) loadRetention(id, key string) retention.Policy {
+	value := l.databaseEnv(id, key)
+	if value == "" {
+		key = "RETENTION"
+		value = l.databaseEnv(id, key)
+	}
+	if value == "" {
+		return retention.DefaultPolicy
+	}
+
+	policy, err := retention.Parse(value)
+	if err != nil {
+		l.errorf("Invalid %s for %s: %v", l.databaseSetting(id, key), id, err)
+	}
+	return policy
+}
+
+// loadSchedules reads the schedules for a database. SCHEDULES holds
+// several entries separated by ";", each written as
+// name=spec[|noexport][|tz=Zone][|jitter=Duration], for example
+// "hourly=0 * * * *|noexport;daily=0 0 * * *". Without SCHEDULES a single
+// schedule is built from SCHEDULE. SCHEDULE_TIMEZONE and SCHEDULE_JITTER set
+// the defaults for entries that do not specify their own.
+func (l *loader) loadSchedules(id string) []Schedule {
+	timezone := l.databaseEnv(id, "SCHEDULE_TIMEZONE")
+	if timezone == "" {
+		timezone = "UTC"
+	} else if _, err := time.LoadLocation(timezone); err != nil {
+		l.errorf("Invalid %s for %s: %q is not a time zone", l.databaseSetting(id, "SCHEDULE_TIMEZONE"), id, timezone)
+	}
+
+	var jitter time.Duration
+	if v := l.databaseEnv(id, "SCHEDULE_JITTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			l.errorf("Invalid %s for %s: %q", l.databaseSetting(id, "SCHEDULE_JITTER"), id, v)
+		}
+		jitter = d
+	}
+
+	entries := l.databaseEnv(id, "SCHEDULES")
+	if entries == "" {
+		spec := l.databaseEnv(id, "SCHEDULE")
+		if spec == "" {
+			spec = DefaultSchedule
+		}
+		if err := checkCron(spec); err != nil {
+			l.errorf("Invalid %s for %s: %q: %v", l.databaseSetting(id, "SCHEDULE"), id, spec, err)
+		}
+		return []Schedule{{Name: "default", Spec: spec, Timezone: timezone, Jitter: jitter}}
+	}
+
+	setting := l.databaseSetting(id, "SCHEDULES")
+	var schedules []Schedule
+	for _, entry := range strings.Split(entries, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		name, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			l.errorf("Invalid %s entry for %s: %q (expected name=spec)", setting, id, entry)
+			continue
+		}
+
+		fields := strings.Split(rest, "|")
+		schedule := Schedule{
+			Name:     strings.TrimSpace(name),
+			Spec:     strings.TrimSpace(fields[0]),
+			Timezone: timezone,
+			Jitter:   jitter,
+		}
+		for _, option := range fields[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case "noexport":
+				schedule.SkipExport = true
+			case "tz":
+				if _, err := time.LoadLocation(value); err != nil {
+					l.errorf("Invalid tz in %s entry %q for %s: %q is not a time zone", setting, schedule.Name, id, value)
+				}
+				schedule.Timezone = value
+			case "jitter":
+				d, err := time.ParseDuration(value)
+				if err != nil || d < 0 {
+					l.errorf("Invalid jitter in %s entry %q for %s: %q", setting, schedule.Name, id, value)
+				}
+				schedule.Jitter = d
+			default:
+				l.errorf("Unknown option in %s entry %q for %s: %q", setting, schedule.Name, id, option)
+			}
+		}
+		if err := checkCron(schedule.Spec); err != nil {
+			l.errorf("Invalid spec in %s entry %q for %s: %q: %v", setting, schedule.Name, id, schedule.Spec, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules
+}
+
+// checkCron checks a cron spec the way the scheduler parses it: five fields
+// or a descriptor such as @daily. The scheduler adds the time zone of the
+// schedule itself, so a CRON_TZ= or TZ= prefix is rejected.
+func checkCron(spec string) error {
+	if spec == "" {
+		return errors.New("must not be empty")
+	}
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(spec, prefix) {
+			return fmt.Errorf("must not start with %s (set SCHEDULE_TIMEZONE or the tz option; drills run in UTC)", prefix)
+		}
+	}
+	_, err := cron.ParseStandard(spec)
+	return err
+}
+
+// Database returns the configured database with the given identifier.
+func (c *Config) Database(id string) (*Database, bool) {
+	for i := range c.Databases {
+		if c.Databases[i].DBIdentifier == id {
+			return &c.Databases[i], true
+		}
+	}
+	return nil, false
+}
+
+// EnvPrefix turns a DB identifier into the prefix used for its env vars,
+// e.g. "orders-db" becomes "ORDERS_DB".
+func EnvPrefix(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+}
+
+// databaseEnv returns the setting key of database id. Env vars override
+// the file, and within each the more specific value wins: the prefixed env
+// var, the unprefixed env var, the database's entry in the file and then the
+// file's defaults.
+func (l *loader) databaseEnv(id, key string) string {
+	return l.lookup(EnvPrefix(id)+"_"+key, key)
+}
+
+// SplitList splits a comma separated list, dropping empty items.
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadSchedules(t *testing.T) {
+	l := &loader{file: settings(map[string]string{
+		"DB_IDENTIFIERS":           "orders,users",
+		"ADMIN_EMAILS":             "dba@example.com",
+		"EMAIL_FROM":               "backups@example.com",
+		"ORDERS_SCHEDULES":         "hourly=0 * * * *|noexport|jitter=5m; daily=30 2 * * *|tz=Europe/Berlin",
+		"ORDERS_SCHEDULE_TIMEZONE": "America/New_York",
+		"USERS_SCHEDULE":           "@daily",
+	})}
+	cfg := l.load()
+	if len(l.problems) > 0 {
+		t.Fatalf("problems %q", l.problems)
+	}
+
+	orders, _ := cfg.Database("orders")
+	want := []Schedule{
+		{Name: "hourly", Spec: "0 * * * *", Timezone: "America/New_York", Jitter: 5 * time.Minute, SkipExport: true},
+		{Name: "daily", Spec: "30 2 * * *", Timezone: "Europe/Berlin"},
+	}
+	if !slices.Equal(orders.Schedules, want) {
+		t.Errorf("orders schedules %+v, want %+v", orders.Schedules, want)
+	}
+	users, _ := cfg.Database("users")
+	if want := []Schedule{{Name: "default", Spec: "@daily", Timezone: "UTC"}}; !slices.Equal(users.Schedules, want) {
+		t.Errorf("users schedules %+v, want %+v", users.Schedules, want)
+	}
+}
+
+func TestLoadReportsEveryScheduleProblem(t *testing.T) {
+	l := &loader{file: settings(map[string]string{
+		"DB_IDENTIFIERS":           "orders,users,events,audit",
+		"ADMIN_EMAILS":             "dba@example.com",
+		"EMAIL_FROM":               "backups@example.com",
+		"ORDERS_SCHEDULES":         "hourly=61 * * * *; daily=0 2 * * *|tz=Mars/Olympus; weekly=|jitter=-1m",
+		"ORDERS_SCHEDULE_TIMEZONE": "Nowhere/Special",
+		"USERS_SCHEDULE":           "every day",
+		"EVENTS_DRILL_SCHEDULE":    "0 0 * *",
+		"AUDIT_SCHEDULES":          "daily=CRON_TZ=Europe/Berlin 0 2 * * *",
+		"AUDIT_DRILL_SCHEDULE":     "TZ=Europe/Berlin 0 6 * * 0",
+	})}
+	l.load()
+
+	want := []string{
+		`Invalid SCHEDULE_TIMEZONE for orders: "Nowhere/Special" is not a time zone`,
+		`Invalid spec in SCHEDULES entry "hourly" for orders: "61 * * * *": end of range (61) above maximum (59): 61`,
+		`Invalid tz in SCHEDULES entry "daily" for orders: "Mars/Olympus" is not a time zone`,
+		`Invalid jitter in SCHEDULES entry "weekly" for orders: "-1m"`,
+		`Invalid spec in SCHEDULES entry "weekly" for orders: "": must not be empty`,
+		`Invalid SCHEDULE for users: "every day": expected exactly 5 fields, found 2: [every day]`,
+		`Invalid DRILL_SCHEDULE for events: "0 0 * *": expected exactly 5 fields, found 4: [0 0 * *]`,
+		`Invalid spec in SCHEDULES entry "daily" for audit: "CRON_TZ=Europe/Berlin 0 2 * * *": must not start with CRON_TZ= (set SCHEDULE_TIMEZONE or the tz option; drills run in UTC)`,
+		`Invalid DRILL_SCHEDULE for audit: "TZ=Europe/Berlin 0 6 * * 0": must not start with TZ= (set SCHEDULE_TIMEZONE or the tz option; drills run in UTC)`,
+	}
+	if !slices.Equal(l.problems, want) {
+		t.Errorf("problems\n%q\nwant\n%q", l.problems, want)
+	}
+}
+
+func TestLoadVault(t *testing.T) {
+	vault := map[string]string{
+		"ADMIN_EMAILS":            "dba@example.com",
+		"EMAIL_FROM":              "backups@example.com",
+		"TARGET_KMS_KEY_ID":       "",
+		"VAULT_ROLE_ARN":          "arn:aws:iam::210987654321:role/rds-backup-vault",
+		"VAULT_KMS_KEY_ID":        "alias/rds-backup-vault",
+		"VAULT_SOURCE_KMS_KEY_ID": "alias/rds-backup-shared",
+		"VAULT_EXPORT_ROLE_ARN":   "arn:aws:iam::210987654321:role/rds-s3-export",
+	}
+	with := func(extra map[string]string) map[string]string {
+		values := maps.Clone(vault)
+		maps.Copy(values, extra)
+		return values
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{name: "no target key with a vault", env: vault},
+		{
+			name:     "no target key without a vault",
+			env:      map[string]string{"ADMIN_EMAILS": "dba@example.com", "EMAIL_FROM": "backups@example.com", "TARGET_KMS_KEY_ID": ""},
+			problems: []string{"Missing required environment variable for orders: ORDERS_TARGET_KMS_KEY_ID, TARGET_KMS_KEY_ID or KMS_KEY_ID"},
+		},
+		{
+			name:     "missing source key",
+			env:      with(map[string]string{"VAULT_SOURCE_KMS_KEY_ID": ""}),
+			problems: []string{"Missing required environment variable: VAULT_SOURCE_KMS_KEY_ID must be set with VAULT_ROLE_ARN"},
+		},
+		{
+			name:     "AWS managed source key",
+			env:      with(map[string]string{"VAULT_SOURCE_KMS_KEY_ID": "alias/aws/rds"}),
+			problems: []string{`Invalid VAULT_SOURCE_KMS_KEY_ID: "alias/aws/rds" is AWS managed and cannot be shared with the vault (expected a customer managed key)`},
+		},
+		{
+			name:     "source key of the source region",
+			env:      with(map[string]string{"VAULT_SOURCE_KMS_KEY_ID": "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"}),
+			problems: []string{`Invalid VAULT_SOURCE_KMS_KEY_ID: "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab" is not in us-west-2`},
+		},
+		{
+			name: "vault key of the target region",
+			env:  with(map[string]string{"VAULT_KMS_KEY_ID": "arn:aws:kms:us-west-2:210987654321:alias/rds-backup-vault"}),
+		},
+		{
+			name:     "vault key of the source region",
+			env:      with(map[string]string{"VAULT_KMS_KEY_ID": "arn:aws:kms:us-east-1:210987654321:key/1234abcd-12ab-34cd-56ef-1234567890ab"}),
+			problems: []string{`Invalid VAULT_KMS_KEY_ID: "arn:aws:kms:us-east-1:210987654321:key/1234abcd-12ab-34cd-56ef-1234567890ab" is not in us-west-2`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &loader{file: settings(tt.env)}
+			cfg := l.load()
+			if !slices.Equal(l.problems, tt.problems) {
+				t.Fatalf("problems %q, want %q", l.problems, tt.problems)
+			}
+			if len(tt.problems) > 0 {
+				return
+			}
+			db, _ := cfg.Database("orders")
+			if err := db.Validate(cfg); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Drill configures the restore drill of a database: its latest
+// copy-backup-* snapshot is restored in the target region to a throwaway
+// instance, the SQL checks are run against it and it is deleted again. The
+// drill is scheduled when Schedule is set and can always be run with the
+// drill command.
+type Drill struct {
+	Schedule       string
+	InstanceClass  string
+	SubnetGroup    string
+	SecurityGroups []string
+	ParameterGroup string
+	// DBName, Username and Password are used to connect to the restored
+	// instance. The restored instance keeps the master credentials of the
+	// backed up database.
+	DBName   string
+	Username string
+	Password Secret
+	Checks   []Check
+}
+
+// Check is one SQL query run against a restored instance. The query must
+// return a single value. Without bounds the check passes if the query
+// succeeds; Min and Max bound a numeric value such as a row count, and
+// MaxAge bounds how much older than the snapshot a timestamp may be.
+type Check struct {
+	Name   string        `json:"name"`
+	Query  string        `json:"query"`
+	Min    *float64      `json:"min,omitempty"`
+	Max    *float64      `json:"max,omitempty"`
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+}
+
+func (l *loader) loadDrill(id, mode string) Drill {
+	drill := Drill{
+		Schedule:       l.databaseEnv(id, "DRILL_SCHEDULE"),
+		InstanceClass:  l.databaseEnv(id, "DRILL_INSTANCE_CLASS"),
+		SubnetGroup:    l.databaseEnv(id, "DRILL_SUBNET_GROUP"),
+		SecurityGroups: SplitList(l.databaseEnv(id, "DRILL_SECURITY_GROUPS")),
+		ParameterGroup: l.databaseEnv(id, "DRILL_PARAMETER_GROUP"),
+		DBName:         l.databaseEnv(id, "DRILL_DB_NAME"),
+		Username:       l.databaseEnv(id, "DRILL_DB_USERNAME"),
+		Password:       Secret(l.databaseEnv(id, "DRILL_DB_PASSWORD")),
+	}
+	if drill.Schedule != "" {
+		if err := checkCron(drill.Schedule); err != nil {
+			l.errorf("Invalid %s for %s: %q: %v", l.databaseSetting(id, "DRILL_SCHEDULE"), id, drill.Schedule, err)
+		}
+	}
+	if drill.Schedule != "" && mode == ModeCluster && drill.InstanceClass == "" {
+		l.errorf("Missing required environment variable for %s: %s or DRILL_INSTANCE_CLASS must be set to drill a DB cluster", id, EnvPrefix(id)+"_DRILL_INSTANCE_CLASS")
+	}
+
+	if path := l.databaseEnv(id, "DRILL_CHECKS_FILE"); path != "" {
+		checks, err := loadChecks(path)
+		if err != nil {
+			l.errorf("Invalid %s for %s: %v", l.databaseSetting(id, "DRILL_CHECKS_FILE"), id, err)
+		}
+		drill.Checks = checks
+	}
+	if len(drill.Checks) > 0 && drill.Username == "" {
+		l.errorf("Missing required environment variable for %s: %s or DRILL_DB_USERNAME must be set to run SQL checks", id, EnvPrefix(id)+"_DRILL_DB_USERNAME")
+	}
+	return drill
+}
+
+// loadChecks reads a JSON array of checks, for example
+//
+//	[{"name": "orders", "query": "SELECT count(*) FROM orders", "min": 1000},
+//	 {"name": "latest order", "query": "SELECT max(created_at) FROM orders", "maxAge": "26h"}]
+func loadChecks(path string) ([]Check, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Name   string   `json:"name"`
+		Query  string   `json:"query"`
+		Min    *float64 `json:"min"`
+		Max    *float64 `json:"max"`
+		MaxAge string   `json:"maxAge"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	checks := make([]Check, 0, len(entries))
+	for i, entry := range entries {
+		check := Check{Name: entry.Name, Query: entry.Query, Min: entry.Min, Max: entry.Max}
+		if check.Name == "" {
+			check.Name = "check " + strconv.Itoa(i+1)
+		}
+		if check.Query == "" {
+			return nil, fmt.Errorf("%s: query must not be empty", check.Name)
+		}
+		if entry.MaxAge != "" {
+			d, err := time.ParseDuration(entry.MaxAge)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s: invalid maxAge %q", check.Name, entry.MaxAge)
+			}
+			check.MaxAge = d
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
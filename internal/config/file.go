@@ -0,0 +1,324 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the schema of the configuration file named by CONFIG_FILE.
+// Every setting stands for the env var of the same meaning, which overrides
+// it. The settings under defaults apply to every database, like unprefixed
+// env vars; those of an entry under databases apply to that database only,
+// like env vars prefixed with its identifier. For example
+//
+//	sourceRegion: us-east-1
+//	targetRegion: us-west-2
+//	emails: [admin@example.com]
+//	defaults:
+//	  sourceBucket: source-backups
+//	  targetBucket: target-backups
+//	  sourceKmsKeyId: alias/rds-backup
+//	  targetKmsKeyId: alias/rds-backup
+//	  exportRoleArn: arn:aws:iam::123456789012:role/rds-export-role
+//	  keepSourceSnapshot: true
+//	  storeToSourceS3: false
+//	databases:
+//	  - identifier: orders-db
+//	    schedules:
+//	      - {name: hourly, spec: "0 * * * *", noExport: true}
+//	      - {name: daily, spec: "0 0 * * *"}
+//	  - identifier: analytics-cluster
+//	    mode: cluster
+type fileConfig struct {
+	SourceRegion         string `yaml:"sourceRegion"`
+	TargetRegion         string `yaml:"targetRegion"`
+	MaxConcurrentBackups int    `yaml:"maxConcurrentBackups"`
+	NotificationMode     string `yaml:"notificationMode"`
+	StateStore           string `yaml:"stateStore"`
+	StateRetention       string `yaml:"stateRetention"`
+	ReportDir            string `yaml:"reportDir"`
+	MetricsAddr          string `yaml:"metricsAddr"`
+	ControlAPI           struct {
+		Addr  string `yaml:"addr"`
+		Token string `yaml:"token"`
+	} `yaml:"controlApi"`
+	ShutdownGracePeriod string   `yaml:"shutdownGracePeriod"`
+	Emails              []string `yaml:"emails"`
+	Email               struct {
+		Transport string `yaml:"transport"`
+		From      string `yaml:"from"`
+		FromName  string `yaml:"fromName"`
+	} `yaml:"email"`
+	SMTP  fileSMTP `yaml:"smtp"`
+	Roles struct {
+		Source fileRole `yaml:"source"`
+		Target fileRole `yaml:"target"`
+		Notify fileRole `yaml:"notify"`
+	} `yaml:"roles"`
+	Vault struct {
+		RoleARN        string `yaml:"roleArn"`
+		ExternalID     string `yaml:"externalId"`
+		KMSKeyID       string `yaml:"kmsKeyId"`
+		SourceKMSKeyID string `yaml:"sourceKmsKeyId"`
+		ExportRoleARN  string `yaml:"exportRoleArn"`
+	} `yaml:"vault"`
+	Defaults  fileDatabase   `yaml:"defaults"`
+	Databases []fileDatabase `yaml:"databases"`
+	Channels  []fileChannel  `yaml:"channels"`
+}
+
+type fileDatabase struct {
+	Identifier         string         `yaml:"identifier"`
+	Mode               string         `yaml:"mode"`
+	SourceBucket       string         `yaml:"sourceBucket"`
+	TargetBucket       string         `yaml:"targetBucket"`
+	KMSKeyID           string         `yaml:"kmsKeyId"`
+	SourceKMSKeyID     string         `yaml:"sourceKmsKeyId"`
+	TargetKMSKeyID     string         `yaml:"targetKmsKeyId"`
+	ExportRoleARN      string         `yaml:"exportRoleArn"`
+	KeepSourceSnapshot *bool          `yaml:"keepSourceSnapshot"`
+	StoreToSourceS3    *bool          `yaml:"storeToSourceS3"`
+	ExportOnly         []string       `yaml:"exportOnly"`
+	SourceExportOnly   []string       `yaml:"sourceExportOnly"`
+	TargetExportOnly   []string       `yaml:"targetExportOnly"`
+	S3Prefix           string         `yaml:"s3Prefix"`
+	SourceS3Prefix     string         `yaml:"sourceS3Prefix"`
+	TargetS3Prefix     string         `yaml:"targetS3Prefix"`
+	Retention          string         `yaml:"retention"`
+	SourceRetention    string         `yaml:"sourceRetention"`
+	TargetRetention    string         `yaml:"targetRetention"`
+	Schedule           string         `yaml:"schedule"`
+	Schedules          []fileSchedule `yaml:"schedules"`
+	ScheduleTimezone   string         `yaml:"scheduleTimezone"`
+	ScheduleJitter     string         `yaml:"scheduleJitter"`
+	Drill              struct {
+		Schedule       string   `yaml:"schedule"`
+		InstanceClass  string   `yaml:"instanceClass"`
+		SubnetGroup    string   `yaml:"subnetGroup"`
+		SecurityGroups []string `yaml:"securityGroups"`
+		ParameterGroup string   `yaml:"parameterGroup"`
+		DBName         string   `yaml:"dbName"`
+		Username       string   `yaml:"username"`
+		Password       string   `yaml:"password"`
+		ChecksFile     string   `yaml:"checksFile"`
+	} `yaml:"drill"`
+}
+
+type fileSchedule struct {
+	Name     string `yaml:"name"`
+	Spec     string `yaml:"spec"`
+	NoExport bool   `yaml:"noExport"`
+	Timezone string `yaml:"timezone"`
+	Jitter   string `yaml:"jitter"`
+}
+
+type fileChannel struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	On       []string `yaml:"on"`
+	To       []string `yaml:"to"`
+	From     string   `yaml:"from"`
+	FromName string   `yaml:"fromName"`
+	URL      string   `yaml:"url"`
+	TopicARN string   `yaml:"topicArn"`
+	Region   string   `yaml:"region"`
+	Role     fileRole `yaml:"role"`
+	SMTP     fileSMTP `yaml:"smtp"`
+}
+
+type fileRole struct {
+	ARN         string `yaml:"arn"`
+	ExternalID  string `yaml:"externalId"`
+	SessionName string `yaml:"sessionName"`
+}
+
+type fileSMTP struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	TLS      string `yaml:"tls"`
+}
+
+// fileValues holds the settings of the file keyed by their env var, and the
+// key of each setting in the file for error messages.
+type fileValues struct {
+	values map[string]string
+	keys   map[string]string
+}
+
+func (v *fileValues) set(key, path, value string) {
+	if value != "" {
+		v.values[key] = value
+		v.keys[key] = path
+	}
+}
+
+func (v *fileValues) setList(key, path string, items []string) {
+	v.set(key, path, strings.Join(items, ","))
+}
+
+func (v *fileValues) setInt(key, path string, n int) {
+	if n != 0 {
+		v.set(key, path, strconv.Itoa(n))
+	}
+}
+
+func (v *fileValues) setBool(key, path string, b *bool) {
+	if b != nil {
+		v.set(key, path, strconv.FormatBool(*b))
+	}
+}
+
+// readFile reads the configuration file at path into the env vars its
+// settings stand for. Unknown keys are an error.
+func readFile(path string) (*fileValues, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	v := &fileValues{values: make(map[string]string), keys: make(map[string]string)}
+	v.set("SOURCE_REGION", "sourceRegion", file.SourceRegion)
+	v.set("TARGET_REGION", "targetRegion", file.TargetRegion)
+	v.setInt("MAX_CONCURRENT_BACKUPS", "maxConcurrentBackups", file.MaxConcurrentBackups)
+	v.set("NOTIFICATION_MODE", "notificationMode", file.NotificationMode)
+	v.set("STATE_STORE", "stateStore", file.StateStore)
+	v.set("STATE_RETENTION", "stateRetention", file.StateRetention)
+	v.set("REPORT_DIR", "reportDir", file.ReportDir)
+	v.set("METRICS_ADDR", "metricsAddr", file.MetricsAddr)
+	v.set("CONTROL_API_ADDR", "controlApi.addr", file.ControlAPI.Addr)
+	v.set("CONTROL_API_TOKEN", "controlApi.token", file.ControlAPI.Token)
+	v.set("SHUTDOWN_GRACE_PERIOD", "shutdownGracePeriod", file.ShutdownGracePeriod)
+	v.setList("ADMIN_EMAILS", "emails", file.Emails)
+	v.set("EMAIL_TRANSPORT", "email.transport", file.Email.Transport)
+	v.set("EMAIL_FROM", "email.from", file.Email.From)
+	v.set("EMAIL_FROM_NAME", "email.fromName", file.Email.FromName)
+	file.SMTP.values(v, "SMTP_", "smtp.")
+	file.Roles.Source.values(v, "SOURCE_ROLE_", "roles.source.")
+	file.Roles.Target.values(v, "TARGET_ROLE_", "roles.target.")
+	file.Roles.Notify.values(v, "NOTIFY_ROLE_", "roles.notify.")
+	v.set("VAULT_ROLE_ARN", "vault.roleArn", file.Vault.RoleARN)
+	v.set("VAULT_EXTERNAL_ID", "vault.externalId", file.Vault.ExternalID)
+	v.set("VAULT_KMS_KEY_ID", "vault.kmsKeyId", file.Vault.KMSKeyID)
+	v.set("VAULT_SOURCE_KMS_KEY_ID", "vault.sourceKmsKeyId", file.Vault.SourceKMSKeyID)
+	v.set("VAULT_EXPORT_ROLE_ARN", "vault.exportRoleArn", file.Vault.ExportRoleARN)
+	file.Defaults.values(v, "", "defaults.")
+
+	identifiers := make([]string, 0, len(file.Databases))
+	for i, db := range file.Databases {
+		if db.Identifier == "" {
+			return nil, fmt.Errorf("%s: databases[%d]: identifier must be set", path, i)
+		}
+		for _, id := range identifiers {
+			if EnvPrefix(id) == EnvPrefix(db.Identifier) {
+				return nil, fmt.Errorf("%s: databases[%d]: %s is configured twice", path, i, db.Identifier)
+			}
+		}
+		identifiers = append(identifiers, db.Identifier)
+		db.values(v, EnvPrefix(db.Identifier)+"_", fmt.Sprintf("databases[%d].", i))
+	}
+	v.setList("DB_IDENTIFIERS", "databases", identifiers)
+
+	names := make([]string, 0, len(file.Channels))
+	for i, channel := range file.Channels {
+		if channel.Name == "" {
+			return nil, fmt.Errorf("%s: channels[%d]: name must be set", path, i)
+		}
+		names = append(names, channel.Name)
+		prefix, path := "NOTIFY_"+EnvPrefix(channel.Name)+"_", fmt.Sprintf("channels[%d].", i)
+		v.set(prefix+"TYPE", path+"type", channel.Type)
+		v.setList(prefix+"ON", path+"on", channel.On)
+		v.setList(prefix+"TO", path+"to", channel.To)
+		v.set(prefix+"FROM", path+"from", channel.From)
+		v.set(prefix+"FROM_NAME", path+"fromName", channel.FromName)
+		v.set(prefix+"URL", path+"url", channel.URL)
+		v.set(prefix+"TOPIC_ARN", path+"topicArn", channel.TopicARN)
+		v.set(prefix+"REGION", path+"region", channel.Region)
+		channel.Role.values(v, prefix+"ROLE_", path+"role.")
+		channel.SMTP.values(v, prefix, path+"smtp.")
+	}
+	v.setList("NOTIFY_CHANNELS", "channels", names)
+
+	return v, nil
+}
+
+func (r fileRole) values(v *fileValues, prefix, path string) {
+	v.set(prefix+"ARN", path+"arn", r.ARN)
+	v.set(prefix+"EXTERNAL_ID", path+"externalId", r.ExternalID)
+	v.set(prefix+"SESSION_NAME", path+"sessionName", r.SessionName)
+}
+
+func (s fileSMTP) values(v *fileValues, prefix, path string) {
+	v.set(prefix+"HOST", path+"host", s.Host)
+	v.setInt(prefix+"PORT", path+"port", s.Port)
+	v.set(prefix+"USERNAME", path+"username", s.Username)
+	v.set(prefix+"PASSWORD", path+"password", s.Password)
+	v.set(prefix+"TLS", path+"tls", s.TLS)
+}
+
+// values sets the env vars of the database settings, with prefix "" for
+// the defaults. path is the key of the settings in the file.
+func (d fileDatabase) values(v *fileValues, prefix, path string) {
+	v.set(prefix+"DB_MODE", path+"mode", d.Mode)
+	v.set(prefix+"SOURCE_BUCKET", path+"sourceBucket", d.SourceBucket)
+	v.set(prefix+"TARGET_BUCKET", path+"targetBucket", d.TargetBucket)
+	v.set(prefix+"KMS_KEY_ID", path+"kmsKeyId", d.KMSKeyID)
+	v.set(prefix+"SOURCE_KMS_KEY_ID", path+"sourceKmsKeyId", d.SourceKMSKeyID)
+	v.set(prefix+"TARGET_KMS_KEY_ID", path+"targetKmsKeyId", d.TargetKMSKeyID)
+	v.set(prefix+"EXPORT_ROLE_ARN", path+"exportRoleArn", d.ExportRoleARN)
+	v.setBool(prefix+"KEEP_SOURCE_SNAPSHOT", path+"keepSourceSnapshot", d.KeepSourceSnapshot)
+	v.setBool(prefix+"STORE_TO_SOURCE_S3", path+"storeToSourceS3", d.StoreToSourceS3)
+	v.setList(prefix+"EXPORT_ONLY", path+"exportOnly", d.ExportOnly)
+	v.setList(prefix+"SOURCE_EXPORT_ONLY", path+"sourceExportOnly", d.SourceExportOnly)
+	v.setList(prefix+"TARGET_EXPORT_ONLY", path+"targetExportOnly", d.TargetExportOnly)
+	v.set(prefix+"S3_PREFIX", path+"s3Prefix", d.S3Prefix)
+	v.set(prefix+"SOURCE_S3_PREFIX", path+"sourceS3Prefix", d.SourceS3Prefix)
+	v.set(prefix+"TARGET_S3_PREFIX", path+"targetS3Prefix", d.TargetS3Prefix)
+	v.set(prefix+"RETENTION", path+"retention", d.Retention)
+	v.set(prefix+"SOURCE_RETENTION", path+"sourceRetention", d.SourceRetention)
+	v.set(prefix+"TARGET_RETENTION", path+"targetRetention", d.TargetRetention)
+	v.set(prefix+"SCHEDULE", path+"schedule", d.Schedule)
+	v.set(prefix+"SCHEDULE_TIMEZONE", path+"scheduleTimezone", d.ScheduleTimezone)
+	v.set(prefix+"SCHEDULE_JITTER", path+"scheduleJitter", d.ScheduleJitter)
+	v.set(prefix+"DRILL_SCHEDULE", path+"drill.schedule", d.Drill.Schedule)
+	v.set(prefix+"DRILL_INSTANCE_CLASS", path+"drill.instanceClass", d.Drill.InstanceClass)
+	v.set(prefix+"DRILL_SUBNET_GROUP", path+"drill.subnetGroup", d.Drill.SubnetGroup)
+	v.setList(prefix+"DRILL_SECURITY_GROUPS", path+"drill.securityGroups", d.Drill.SecurityGroups)
+	v.set(prefix+"DRILL_PARAMETER_GROUP", path+"drill.parameterGroup", d.Drill.ParameterGroup)
+	v.set(prefix+"DRILL_DB_NAME", path+"drill.dbName", d.Drill.DBName)
+	v.set(prefix+"DRILL_DB_USERNAME", path+"drill.username", d.Drill.Username)
+	v.set(prefix+"DRILL_DB_PASSWORD", path+"drill.password", d.Drill.Password)
+	v.set(prefix+"DRILL_CHECKS_FILE", path+"drill.checksFile", d.Drill.ChecksFile)
+
+	// Schedules are written in the SCHEDULES syntax read by loadSchedules.
+	entries := make([]string, 0, len(d.Schedules))
+	for _, schedule := range d.Schedules {
+		entry := schedule.Name + "=" + schedule.Spec
+		if schedule.NoExport {
+			entry += "|noexport"
+		}
+		if schedule.Timezone != "" {
+			entry += "|tz=" + schedule.Timezone
+		}
+		if schedule.Jitter != "" {
+			entry += "|jitter=" + schedule.Jitter
+		}
+		entries = append(entries, entry)
+	}
+	v.set(prefix+"SCHEDULES", path+"schedules", strings.Join(entries, ";"))
+}
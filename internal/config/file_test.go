@@ -0,0 +1,305 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFile writes a configuration file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fileLoader returns a loader of the configuration file content.
+func fileLoader(t *testing.T, content string) *loader {
+	t.Helper()
+	values, err := readFile(writeFile(t, content))
+	if err != nil {
+		t.Fatalf("readFile: %v", err)
+	}
+	return &loader{file: values.values, fileKeys: values.keys}
+}
+
+const baseFile = `
+sourceRegion: us-east-1
+targetRegion: us-west-2
+emails: [dba@example.com]
+email: {from: backups@example.com}
+defaults:
+  sourceBucket: source-backups
+  targetBucket: target-backups
+  targetKmsKeyId: alias/rds-backup
+  exportRoleArn: arn:aws:iam::123456789012:role/rds-export
+  keepSourceSnapshot: false
+  storeToSourceS3: false
+`
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		values  map[string]string
+		keys    map[string]string
+		wantErr string
+	}{
+		{
+			name: "global settings",
+			file: `
+sourceRegion: us-east-1
+maxConcurrentBackups: 4
+emails: [dba@example.com, ops@example.com]
+controlApi: {addr: ":8081", token: s3cr3t}
+roles:
+  source: {arn: "arn:aws:iam::123456789012:role/source", sessionName: backup}
+vault: {roleArn: "arn:aws:iam::210987654321:role/vault", sourceKmsKeyId: alias/shared}
+smtp: {host: smtp.example.com, port: 465}
+`,
+			values: map[string]string{
+				"SOURCE_REGION":            "us-east-1",
+				"MAX_CONCURRENT_BACKUPS":   "4",
+				"ADMIN_EMAILS":             "dba@example.com,ops@example.com",
+				"CONTROL_API_ADDR":         ":8081",
+				"CONTROL_API_TOKEN":        "s3cr3t",
+				"SOURCE_ROLE_ARN":          "arn:aws:iam::123456789012:role/source",
+				"SOURCE_ROLE_SESSION_NAME": "backup",
+				"VAULT_ROLE_ARN":           "arn:aws:iam::210987654321:role/vault",
+				"VAULT_SOURCE_KMS_KEY_ID":  "alias/shared",
+				"SMTP_HOST":                "smtp.example.com",
+				"SMTP_PORT":                "465",
+			},
+			keys: map[string]string{
+				"SOURCE_REGION":            "sourceRegion",
+				"MAX_CONCURRENT_BACKUPS":   "maxConcurrentBackups",
+				"ADMIN_EMAILS":             "emails",
+				"CONTROL_API_ADDR":         "controlApi.addr",
+				"CONTROL_API_TOKEN":        "controlApi.token",
+				"SOURCE_ROLE_ARN":          "roles.source.arn",
+				"SOURCE_ROLE_SESSION_NAME": "roles.source.sessionName",
+				"VAULT_ROLE_ARN":           "vault.roleArn",
+				"VAULT_SOURCE_KMS_KEY_ID":  "vault.sourceKmsKeyId",
+				"SMTP_HOST":                "smtp.host",
+				"SMTP_PORT":                "smtp.port",
+			},
+		},
+		{
+			name: "defaults and databases",
+			file: `
+defaults:
+  targetBucket: target-backups
+  keepSourceSnapshot: false
+  exportOnly: [app, reporting.public]
+databases:
+  - identifier: orders-db
+    keepSourceSnapshot: true
+    schedules:
+      - {name: hourly, spec: "0 * * * *", noExport: true, jitter: 5m}
+      - {name: daily, spec: "0 0 * * *", timezone: Europe/Berlin}
+    drill: {schedule: "0 6 * * 0", securityGroups: [sg-1, sg-2]}
+  - identifier: analytics
+    mode: cluster
+`,
+			values: map[string]string{
+				"TARGET_BUCKET":                   "target-backups",
+				"KEEP_SOURCE_SNAPSHOT":            "false",
+				"EXPORT_ONLY":                     "app,reporting.public",
+				"ORDERS_DB_KEEP_SOURCE_SNAPSHOT":  "true",
+				"ORDERS_DB_SCHEDULES":             "hourly=0 * * * *|noexport|jitter=5m;daily=0 0 * * *|tz=Europe/Berlin",
+				"ORDERS_DB_DRILL_SCHEDULE":        "0 6 * * 0",
+				"ORDERS_DB_DRILL_SECURITY_GROUPS": "sg-1,sg-2",
+				"ANALYTICS_DB_MODE":               "cluster",
+				"DB_IDENTIFIERS":                  "orders-db,analytics",
+			},
+			keys: map[string]string{
+				"TARGET_BUCKET":                   "defaults.targetBucket",
+				"KEEP_SOURCE_SNAPSHOT":            "defaults.keepSourceSnapshot",
+				"EXPORT_ONLY":                     "defaults.exportOnly",
+				"ORDERS_DB_KEEP_SOURCE_SNAPSHOT":  "databases[0].keepSourceSnapshot",
+				"ORDERS_DB_SCHEDULES":             "databases[0].schedules",
+				"ORDERS_DB_DRILL_SCHEDULE":        "databases[0].drill.schedule",
+				"ORDERS_DB_DRILL_SECURITY_GROUPS": "databases[0].drill.securityGroups",
+				"ANALYTICS_DB_MODE":               "databases[1].mode",
+				"DB_IDENTIFIERS":                  "databases",
+			},
+		},
+		{
+			name: "channels",
+			file: `
+channels:
+  - name: oncall
+    type: slack
+    url: https://hooks.slack.com/services/T000/B000/XXX
+    on: [failure]
+  - name: ops-mail
+    type: smtp
+    smtp: {host: smtp.example.com, tls: tls}
+    role: {arn: "arn:aws:iam::123456789012:role/notify"}
+`,
+			values: map[string]string{
+				"NOTIFY_ONCALL_TYPE":       "slack",
+				"NOTIFY_ONCALL_URL":        "https://hooks.slack.com/services/T000/B000/XXX",
+				"NOTIFY_ONCALL_ON":         "failure",
+				"NOTIFY_OPS_MAIL_TYPE":     "smtp",
+				"NOTIFY_OPS_MAIL_HOST":     "smtp.example.com",
+				"NOTIFY_OPS_MAIL_TLS":      "tls",
+				"NOTIFY_OPS_MAIL_ROLE_ARN": "arn:aws:iam::123456789012:role/notify",
+				"NOTIFY_CHANNELS":          "oncall,ops-mail",
+			},
+			keys: map[string]string{
+				"NOTIFY_ONCALL_TYPE":       "channels[0].type",
+				"NOTIFY_ONCALL_URL":        "channels[0].url",
+				"NOTIFY_ONCALL_ON":         "channels[0].on",
+				"NOTIFY_OPS_MAIL_TYPE":     "channels[1].type",
+				"NOTIFY_OPS_MAIL_HOST":     "channels[1].smtp.host",
+				"NOTIFY_OPS_MAIL_TLS":      "channels[1].smtp.tls",
+				"NOTIFY_OPS_MAIL_ROLE_ARN": "channels[1].role.arn",
+				"NOTIFY_CHANNELS":          "channels",
+			},
+		},
+		{
+			name:   "empty file",
+			file:   "",
+			values: map[string]string{},
+			keys:   map[string]string{},
+		},
+		{
+			name:    "unknown top-level key",
+			file:    "sourceRegon: us-east-1\n",
+			wantErr: "field sourceRegon not found",
+		},
+		{
+			name:    "unknown database key",
+			file:    "databases:\n  - identifier: orders\n    bucket: backups\n",
+			wantErr: "field bucket not found",
+		},
+		{
+			name:    "unknown schedule key",
+			file:    "defaults:\n  schedules:\n    - {name: daily, cron: \"0 0 * * *\"}\n",
+			wantErr: "field cron not found",
+		},
+		{
+			name:    "database without identifier",
+			file:    "databases:\n  - mode: cluster\n",
+			wantErr: "databases[0]: identifier must be set",
+		},
+		{
+			name:    "database configured twice",
+			file:    "databases:\n  - identifier: orders-db\n  - identifier: ORDERS_DB\n",
+			wantErr: "databases[1]: ORDERS_DB is configured twice",
+		},
+		{
+			name:    "channel without name",
+			file:    "channels:\n  - type: slack\n",
+			wantErr: "channels[0]: name must be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := readFile(writeFile(t, tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readFile: %v", err)
+			}
+			if !maps.Equal(values.values, tt.values) {
+				t.Errorf("values\n%q\nwant\n%q", values.values, tt.values)
+			}
+			if !maps.Equal(values.keys, tt.keys) {
+				t.Errorf("keys\n%q\nwant\n%q", values.keys, tt.keys)
+			}
+		})
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	file := baseFile + `
+databases:
+  - identifier: orders
+    targetBucket: orders-backups
+    scheduleTimezone: Europe/Berlin
+  - identifier: users
+`
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		orders string
+		users  string
+	}{
+		{name: "database entry over defaults", orders: "orders-backups", users: "target-backups"},
+		{name: "unprefixed env over the database entry", env: map[string]string{"TARGET_BUCKET": "env-backups"}, orders: "env-backups", users: "env-backups"},
+		{
+			name:   "prefixed env over unprefixed env",
+			env:    map[string]string{"TARGET_BUCKET": "env-backups", "ORDERS_TARGET_BUCKET": "orders-env-backups"},
+			orders: "orders-env-backups",
+			users:  "env-backups",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			l := fileLoader(t, file)
+			cfg := l.load()
+			if len(l.problems) > 0 {
+				t.Fatalf("problems %q", l.problems)
+			}
+			orders, _ := cfg.Database("orders")
+			users, _ := cfg.Database("users")
+			if orders.TargetBucket != tt.orders || users.TargetBucket != tt.users {
+				t.Errorf("target buckets %q and %q, want %q and %q", orders.TargetBucket, users.TargetBucket, tt.orders, tt.users)
+			}
+			if orders.Schedules[0].Timezone != "Europe/Berlin" || users.Schedules[0].Timezone != "UTC" {
+				t.Errorf("schedules %+v and %+v", orders.Schedules, users.Schedules)
+			}
+		})
+	}
+}
+
+func TestProblemsNameTheFileKey(t *testing.T) {
+	file := baseFile + `
+maxConcurrentBackups: -1
+roles:
+  source: {arn: not-a-role}
+databases:
+  - identifier: orders
+    targetBucket: Not_A_Bucket
+    schedules:
+      - {name: daily, spec: "0 25 * * *"}
+  - identifier: users
+channels:
+  - name: oncall
+    type: pager
+`
+	t.Setenv("USERS_SCHEDULE_TIMEZONE", "Nowhere/Special")
+
+	l := fileLoader(t, file)
+	l.load()
+
+	want := []string{
+		`Invalid TARGET_BUCKET (databases[0].targetBucket in the config file) for orders: "Not_A_Bucket" is not a valid S3 bucket name`,
+		`Invalid spec in SCHEDULES (databases[0].schedules in the config file) entry "daily" for orders: "0 25 * * *": end of range (25) above maximum (23): 25`,
+		`Invalid SCHEDULE_TIMEZONE for users: "Nowhere/Special" is not a time zone`,
+		`Invalid MAX_CONCURRENT_BACKUPS (maxConcurrentBackups in the config file): "-1"`,
+		`Invalid NOTIFY_ONCALL_TYPE (channels[0].type in the config file): "pager" (expected ses, smtp, slack, webhook or sns)`,
+		`Invalid SOURCE_ROLE_ARN (roles.source.arn in the config file): "not-a-role" (expected arn:aws:iam::<account>:role/<name>)`,
+	}
+	if !slices.Equal(l.problems, want) {
+		t.Errorf("problems\n%q\nwant\n%q", l.problems, want)
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Notification channel types.
+const (
+	ChannelSES     = "ses"
+	ChannelSMTP    = "smtp"
+	ChannelSlack   = "slack"
+	ChannelWebhook = "webhook"
+	ChannelSNS     = "sns"
+)
+
+// SMTP connection security. STARTTLS upgrades a plain connection, TLS
+// connects with implicit TLS (SMTPS) and none sends in the clear.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+// Events a channel can subscribe to. Interrupted backups count as failures.
+const (
+	EventSuccess = "success"
+	EventFailure = "failure"
+)
+
+// Channel is one notification destination. Which fields are used depends
+// on Type: To, From and FromName for email, URL for Slack and webhooks, TopicARN for
+// SNS, and the SMTP fields for smtp. On lists the events routed to the
+// channel. SES and SNS channels assume Role if it is set.
+type Channel struct {
+	Name     string
+	Type     string
+	On       []string
+	To       []string
+	From     string
+	FromName string
+	URL      Secret
+	TopicARN string
+	Region   string
+	Role     Role
+	SMTP     SMTP
+}
+
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password Secret
+	TLS      string
+}
+
+// IsEmail reports whether the channel sends email.
+func (c Channel) IsEmail() bool {
+	return c.Type == ChannelSES || c.Type == ChannelSMTP
+}
+
+// Wants reports whether the channel subscribes to event.
+func (c Channel) Wants(event string) bool {
+	return slices.Contains(c.On, event)
+}
+
+// globalChannelEnv maps channel keys to the global variables they fall back
+// to. The default channel is configured by these variables alone.
+var globalChannelEnv = map[string]string{
+	"FROM":      "EMAIL_FROM",
+	"FROM_NAME": "EMAIL_FROM_NAME",
+	"HOST":      "SMTP_HOST",
+	"PORT":      "SMTP_PORT",
+	"USERNAME":  "SMTP_USERNAME",
+	"PASSWORD":  "SMTP_PASSWORD",
+	"TLS":       "SMTP_TLS",
+
+	"ROLE_ARN":          "NOTIFY_ROLE_ARN",
+	"ROLE_EXTERNAL_ID":  "NOTIFY_ROLE_EXTERNAL_ID",
+	"ROLE_SESSION_NAME": "NOTIFY_ROLE_SESSION_NAME",
+}
+
+// loadChannels reads the channels named in NOTIFY_CHANNELS. Each channel is
+// configured with NOTIFY_<NAME>_<KEY> variables, for example
+// NOTIFY_ONCALL_TYPE=slack and NOTIFY_ONCALL_ON=failure. Without
+// NOTIFY_CHANNELS a single email channel sends every event to ADMIN_EMAILS,
+// through SES or, with EMAIL_TRANSPORT=smtp, through the SMTP_* server.
+func (l *loader) loadChannels(sourceRegion string, emails []string) []Channel {
+	names := SplitList(l.getenv("NOTIFY_CHANNELS"))
+	if len(names) == 0 {
+		return []Channel{l.loadChannel("", sourceRegion, emails)}
+	}
+
+	channels := make([]Channel, 0, len(names))
+	for _, name := range names {
+		channels = append(channels, l.loadChannel(name, sourceRegion, emails))
+	}
+	return channels
+}
+
+// loadChannel reads one channel. An empty name loads the default email
+// channel from the global variables.
+func (l *loader) loadChannel(name, sourceRegion string, emails []string) Channel {
+	// keys returns the env vars of key, the channel's own before the global
+	keys := func(key string) []string {
+		var keys []string
+		if name != "" {
+			keys = append(keys, "NOTIFY_"+EnvPrefix(name)+"_"+key)
+		}
+		if global := globalChannelEnv[key]; global != "" {
+			keys = append(keys, global)
+		}
+		return keys
+	}
+	// variable names the settings of key in error messages
+	variable := func(key string) string {
+		return strings.Join(keys(key), " or ") + l.fromFile(keys(key)...)
+	}
+	env := func(key string) string {
+		return l.lookup(keys(key)...)
+	}
+
+	channel := Channel{
+		Name:     name,
+		Type:     env("TYPE"),
+		On:       SplitList(env("ON")),
+		To:       SplitList(env("TO")),
+		From:     env("FROM"),
+		FromName: env("FROM_NAME"),
+		URL:      Secret(env("URL")),
+		TopicARN: env("TOPIC_ARN"),
+		Region:   env("REGION"),
+		Role: Role{
+			ARN:         env("ROLE_ARN"),
+			ExternalID:  env("ROLE_EXTERNAL_ID"),
+			SessionName: env("ROLE_SESSION_NAME"),
+		},
+	}
+	l.validateRole(channel.Role, func(key string) string { return variable("ROLE_" + key) })
+	if name == "" {
+		channel.Name = "email"
+		channel.Type = l.getenv("EMAIL_TRANSPORT")
+		if channel.Type == "" {
+			channel.Type = ChannelSES
+		}
+		if !channel.IsEmail() {
+			l.errorf("Invalid %s: %q (expected ses or smtp)", l.setting("EMAIL_TRANSPORT"), channel.Type)
+		}
+	}
+	if len(channel.On) == 0 {
+		channel.On = []string{EventSuccess, EventFailure}
+	}
+	for _, event := range channel.On {
+		if event != EventSuccess && event != EventFailure {
+			l.errorf("Invalid %s: %q (expected %q or %q)", variable("ON"), event, EventSuccess, EventFailure)
+		}
+	}
+	if channel.Region == "" {
+		channel.Region = sourceRegion
+	}
+	if len(channel.To) == 0 && channel.IsEmail() {
+		channel.To = emails
+	}
+
+	var required []string
+	switch channel.Type {
+	case ChannelSES:
+		// Uses To, From and Region only
+	case ChannelSMTP:
+		required = []string{"HOST"}
+		channel.SMTP = SMTP{
+			Host:     env("HOST"),
+			Username: env("USERNAME"),
+			Password: Secret(env("PASSWORD")),
+			TLS:      env("TLS"),
+		}
+		switch channel.SMTP.TLS {
+		case "", SMTPStartTLS:
+			channel.SMTP.TLS = SMTPStartTLS
+			channel.SMTP.Port = 587
+		case SMTPTLS:
+			channel.SMTP.Port = 465
+		case SMTPNone:
+			channel.SMTP.Port = 25
+		default:
+			l.errorf("Invalid %s: %q (expected starttls, tls or none)", variable("TLS"), channel.SMTP.TLS)
+		}
+		if channel.SMTP.TLS == SMTPNone && channel.SMTP.Username != "" {
+			l.errorf("Invalid %s: %q cannot be used with %s, credentials are only sent over TLS (expected starttls or tls)", variable("TLS"), SMTPNone, variable("USERNAME"))
+		}
+		if v := env("PORT"); v != "" {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				l.errorf("Invalid %s: %q", variable("PORT"), v)
+			}
+			channel.SMTP.Port = port
+		}
+	case ChannelSlack, ChannelWebhook:
+		required = []string{"URL"}
+	case ChannelSNS:
+		required = []string{"TOPIC_ARN"}
+	default:
+		l.errorf("Invalid %s: %q (expected ses, smtp, slack, webhook or sns)", variable("TYPE"), channel.Type)
+	}
+
+	for _, key := range required {
+		if env(key) == "" {
+			l.errorf("Missing required environment variable for channel %s: %s", channel.Name, variable(key))
+		}
+	}
+	if channel.IsEmail() {
+		if len(channel.To) == 0 {
+			l.errorf("Missing recipients for channel %s: set %s", channel.Name, strings.TrimPrefix(variable("TO")+" or ADMIN_EMAILS", " or "))
+		}
+		if channel.From == "" {
+			l.errorf("Missing sender for channel %s: set %s", channel.Name, variable("FROM"))
+		} else if !validEmail(channel.From) {
+			l.errorf("Invalid %s: %q is not an email address", variable("FROM"), channel.From)
+		}
+		for _, to := range channel.To {
+			if !validEmail(to) {
+				l.errorf("Invalid recipient for channel %s: %q is not an email address", channel.Name, to)
+			}
+		}
+	}
+	if channel.TopicARN != "" && !validTopicARN(channel.TopicARN) {
+		l.errorf("Invalid %s: %q (expected arn:aws:sns:<region>:<account>:<topic>)", variable("TOPIC_ARN"), channel.TopicARN)
+	}
+	if region := env("REGION"); region != "" && !validRegion(region) {
+		l.errorf("Invalid %s: %q is not an AWS region", variable("REGION"), region)
+	}
+	return channel
+}
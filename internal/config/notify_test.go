@@ -0,0 +1,122 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+// settings returns a valid configuration of one database, without
+// notification settings, overridden by extra.
+func settings(extra map[string]string) map[string]string {
+	values := map[string]string{
+		"SOURCE_REGION":        "us-east-1",
+		"TARGET_REGION":        "us-west-2",
+		"DB_IDENTIFIER":        "orders",
+		"SOURCE_BUCKET":        "source-backups",
+		"TARGET_BUCKET":        "target-backups",
+		"EXPORT_ROLE_ARN":      "arn:aws:iam::123456789012:role/rds-export",
+		"KEEP_SOURCE_SNAPSHOT": "false",
+		"STORE_TO_SOURCE_S3":   "false",
+		"TARGET_KMS_KEY_ID":    "alias/rds-backup",
+	}
+	maps.Copy(values, extra)
+	return values
+}
+
+func TestLoadChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+		to       []string
+	}{
+		{
+			name: "default email channel",
+			env:  map[string]string{"ADMIN_EMAILS": "dba@example.com,ops@example.com", "EMAIL_FROM": "backups@example.com"},
+			to:   []string{"dba@example.com", "ops@example.com"},
+		},
+		{
+			name:     "default email channel without recipients",
+			env:      map[string]string{"EMAIL_FROM": "backups@example.com"},
+			problems: []string{"Missing recipients for channel email: set ADMIN_EMAILS"},
+		},
+		{
+			name:     "default email channel without sender",
+			env:      map[string]string{"ADMIN_EMAILS": "dba@example.com", "ADMIN_EMAIL": "admin@example.com"},
+			problems: []string{"Missing sender for channel email: set EMAIL_FROM"},
+		},
+		{
+			name: "slack only needs no email settings",
+			env:  map[string]string{"NOTIFY_CHANNELS": "ops", "NOTIFY_OPS_TYPE": "slack", "NOTIFY_OPS_URL": "https://hooks.slack.com/services/T0/B0/x"},
+		},
+		{
+			name: "email channel with its own recipients",
+			env: map[string]string{
+				"NOTIFY_CHANNELS":      "team,ops",
+				"NOTIFY_TEAM_TYPE":     "ses",
+				"NOTIFY_TEAM_TO":       "team@example.com",
+				"NOTIFY_TEAM_FROM":     "backups@example.com",
+				"NOTIFY_OPS_TYPE":      "sns",
+				"NOTIFY_OPS_TOPIC_ARN": "arn:aws:sns:us-east-1:123456789012:backups",
+			},
+			to: []string{"team@example.com"},
+		},
+		{
+			name: "email channel falls back to the global settings",
+			env: map[string]string{
+				"NOTIFY_CHANNELS":   "relay",
+				"NOTIFY_RELAY_TYPE": "smtp",
+				"NOTIFY_RELAY_HOST": "smtp.example.com",
+				"ADMIN_EMAILS":      "dba@example.com",
+				"EMAIL_FROM":        "backups@example.com",
+			},
+			to: []string{"dba@example.com"},
+		},
+		{
+			name: "email channel without recipients",
+			env: map[string]string{
+				"NOTIFY_CHANNELS":   "relay",
+				"NOTIFY_RELAY_TYPE": "smtp",
+				"NOTIFY_RELAY_HOST": "smtp.example.com",
+				"EMAIL_FROM":        "backups@example.com",
+			},
+			problems: []string{"Missing recipients for channel relay: set NOTIFY_RELAY_TO or ADMIN_EMAILS"},
+		},
+		{
+			name: "email channel without sender",
+			env: map[string]string{
+				"NOTIFY_CHANNELS":   "relay",
+				"NOTIFY_RELAY_TYPE": "smtp",
+				"NOTIFY_RELAY_HOST": "smtp.example.com",
+				"NOTIFY_RELAY_TO":   "dba@example.com",
+			},
+			problems: []string{"Missing sender for channel relay: set NOTIFY_RELAY_FROM or EMAIL_FROM"},
+		},
+		{
+			name: "smtp credentials without TLS",
+			env: map[string]string{
+				"EMAIL_TRANSPORT": "smtp",
+				"SMTP_HOST":       "smtp.example.com",
+				"SMTP_TLS":        "none",
+				"SMTP_USERNAME":   "backups",
+				"ADMIN_EMAILS":    "dba@example.com",
+				"EMAIL_FROM":      "backups@example.com",
+			},
+			problems: []string{`Invalid SMTP_TLS: "none" cannot be used with SMTP_USERNAME, credentials are only sent over TLS (expected starttls or tls)`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &loader{file: settings(tt.env)}
+			cfg := l.load()
+			if !slices.Equal(l.problems, tt.problems) {
+				t.Fatalf("problems %q, want %q", l.problems, tt.problems)
+			}
+			if tt.to != nil && !slices.Equal(cfg.Channels[0].To, tt.to) {
+				t.Errorf("recipients %q, want %q", cfg.Channels[0].To, tt.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// prefixPlaceholders are the placeholders of an S3 prefix template.
+var prefixPlaceholders = []string{"db", "region", "snapshot", "yyyy", "mm", "dd", "hh"}
+
+var placeholderPattern = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// ExpandS3Prefix fills in an S3 prefix template such as
+// "rds/{{db}}/{{yyyy}}/{{mm}}/{{dd}}" or, for Athena partitions,
+// "rds/db={{db}}/year={{yyyy}}/month={{mm}}/day={{dd}}". {{db}} is the
+// database identifier, {{region}} the region of the export, {{snapshot}}
+// the snapshot ID and {{yyyy}}, {{mm}}, {{dd}} and {{hh}} are the UTC time
+// the snapshot was taken. Leading and trailing slashes are removed.
+func ExpandS3Prefix(template, db, region, snapshotID string, t time.Time) string {
+	t = t.UTC()
+	values := map[string]string{
+		"db":       db,
+		"region":   region,
+		"snapshot": snapshotID,
+		"yyyy":     t.Format("2006"),
+		"mm":       t.Format("01"),
+		"dd":       t.Format("02"),
+		"hh":       t.Format("15"),
+	}
+	prefix := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return values[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+	})
+	return strings.Trim(prefix, "/")
+}
+
+// validateS3Prefix checks that template only uses known placeholders.
+func validateS3Prefix(template string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(prefixPlaceholders, match[1]) {
+			return fmt.Errorf("unknown placeholder %s (expected one of {{%s}})", match[0], strings.Join(prefixPlaceholders, "}}, {{"))
+		}
+	}
+	if strings.Contains(placeholderPattern.ReplaceAllString(template, ""), "{{") {
+		return fmt.Errorf("unterminated placeholder")
+	}
+	if strings.Contains(template, "//") {
+		return fmt.Errorf("empty path segment")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandS3Prefix(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2025-01-01 00:30 in Berlin is 23:30 on the day before in UTC
+	taken := time.Date(2025, 1, 1, 0, 30, 0, 0, berlin)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", ""},
+		{"rds-exports", "rds-exports"},
+		{"rds/{{db}}/{{yyyy}}/{{mm}}/{{dd}}", "rds/orders-db/2024/12/31"},
+		{"rds/db={{db}}/year={{yyyy}}/month={{mm}}/day={{dd}}/hour={{hh}}", "rds/db=orders-db/year=2024/month=12/day=31/hour=23"},
+		{"{{region}}/{{snapshot}}", "us-west-2/copy-backup-orders-db-2024-12-31-23-30-00"},
+		{"{{ db }}/{{db}}-{{yyyy}}{{mm}}{{dd}}", "orders-db/orders-db-20241231"},
+		{"/rds/{{db}}/", "rds/orders-db"},
+		{"//rds/{{db}}//", "rds/orders-db"},
+		{"{{db}}/", "orders-db"},
+		{"/", ""},
+	}
+
+	for _, tt := range tests {
+		got := ExpandS3Prefix(tt.template, "orders-db", "us-west-2", "copy-backup-orders-db-2024-12-31-23-30-00", taken)
+		if got != tt.want {
+			t.Errorf("ExpandS3Prefix(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestValidateS3Prefix(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{template: ""},
+		{template: "rds-exports"},
+		{template: "rds/{{db}}/{{region}}/{{snapshot}}/{{yyyy}}/{{mm}}/{{dd}}/{{hh}}"},
+		{template: "rds/{{ db }}"},
+		{template: "/rds/{{db}}/"},
+		{template: "rds/{{database}}", wantErr: "unknown placeholder {{database}} (expected one of {{db}}, {{region}}, {{snapshot}}, {{yyyy}}, {{mm}}, {{dd}}, {{hh}})"},
+		{template: "rds/{{DB}}", wantErr: "unknown placeholder {{DB}} (expected one of {{db}}, {{region}}, {{snapshot}}, {{yyyy}}, {{mm}}, {{dd}}, {{hh}})"},
+		{template: "rds/{{}}", wantErr: "unknown placeholder {{}} (expected one of {{db}}, {{region}}, {{snapshot}}, {{yyyy}}, {{mm}}, {{dd}}, {{hh}})"},
+		{template: "rds/{{db}}/{{yyyy", wantErr: "unterminated placeholder"},
+		{template: "rds/{db}", wantErr: ""},
+		{template: "rds//{{db}}", wantErr: "empty path segment"},
+		{template: "rds/{{db}}//", wantErr: "empty path segment"},
+	}
+
+	for _, tt := range tests {
+		err := validateS3Prefix(tt.template)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("validateS3Prefix(%q): %v", tt.template, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("validateS3Prefix(%q) = %v, want %q", tt.template, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import "regexp"
+
+var sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// Role is an IAM role that is assumed through STS instead of using the
+// default credentials directly. The zero value uses the default
+// credentials.
+type Role struct {
+	ARN         string
+	ExternalID  string
+	SessionName string
+}
+
+// loadRole reads the role of one side from <side>_ROLE_ARN,
+// <side>_ROLE_EXTERNAL_ID and <side>_ROLE_SESSION_NAME.
+func (l *loader) loadRole(side string) Role {
+	role := Role{
+		ARN:         l.getenv(side + "_ROLE_ARN"),
+		ExternalID:  l.getenv(side + "_ROLE_EXTERNAL_ID"),
+		SessionName: l.getenv(side + "_ROLE_SESSION_NAME"),
+	}
+	l.validateRole(role, func(key string) string { return l.setting(side + "_ROLE_" + key) })
+	return role
+}
+
+// validateRole checks role; variable names the setting of key ("ARN",
+// "EXTERNAL_ID" or "SESSION_NAME") in error messages.
+func (l *loader) validateRole(role Role, variable func(key string) string) {
+	if role.ARN == "" {
+		for key, value := range map[string]string{"EXTERNAL_ID": role.ExternalID, "SESSION_NAME": role.SessionName} {
+			if value != "" {
+				l.errorf("Missing required environment variable: %s must be set with %s", variable("ARN"), variable(key))
+			}
+		}
+		return
+	}
+	if !validRoleARN(role.ARN) {
+		l.errorf("Invalid %s: %q (expected arn:aws:iam::<account>:role/<name>)", variable("ARN"), role.ARN)
+	}
+	if role.SessionName != "" && !sessionNamePattern.MatchString(role.SessionName) {
+		l.errorf("Invalid %s: %q (expected 2 to 64 letters, digits or +=,.@_-)", variable("SESSION_NAME"), role.SessionName)
+	}
+}
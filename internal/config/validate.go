@@ -0,0 +1,58 @@
+package config
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+var (
+	// regionPattern matches region names such as us-east-1, eu-central-2,
+	// us-gov-west-1 and cn-north-1.
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+$`)
+
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+	partition  = `arn:aws(-cn|-us-gov|-iso|-iso-[a-z])?`
+	keyPattern = regexp.MustCompile(`^([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|mrk-[0-9a-f]{32})$`)
+	aliasName  = regexp.MustCompile(`^alias/[\w/-]+$`)
+	kmsARN     = regexp.MustCompile(`^` + partition + `:kms:[a-z0-9-]+:[0-9]{12}:(key/(mrk-)?[0-9a-f-]+|alias/[\w/-]+)$`)
+	roleARN    = regexp.MustCompile(`^` + partition + `:iam::[0-9]{12}:role/[\w+=,.@/-]+$`)
+	topicARN   = regexp.MustCompile(`^` + partition + `:sns:[a-z0-9-]+:[0-9]{12}:[\w-]+(\.fifo)?$`)
+)
+
+func validRegion(region string) bool {
+	return regionPattern.MatchString(region)
+}
+
+// validBucket checks the S3 general purpose bucket naming rules.
+func validBucket(bucket string) bool {
+	return bucketPattern.MatchString(bucket) &&
+		!strings.Contains(bucket, "..") &&
+		net.ParseIP(bucket) == nil &&
+		!strings.HasPrefix(bucket, "xn--") &&
+		!strings.HasSuffix(bucket, "-s3alias") &&
+		!strings.HasSuffix(bucket, "--ol-s3")
+}
+
+// validKMSKey accepts what the KMS key settings may hold: a key ID, a
+// multi-Region key ID, a key ARN, an alias name or an alias ARN.
+func validKMSKey(key string) bool {
+	return keyPattern.MatchString(key) || aliasName.MatchString(key) || kmsARN.MatchString(key)
+}
+
+func validRoleARN(arn string) bool {
+	return roleARN.MatchString(arn)
+}
+
+func validTopicARN(arn string) bool {
+	return topicARN.MatchString(arn)
+}
+
+// validEmail accepts an address with or without a display name, such as
+// "Backups <backups@example.com>".
+func validEmail(address string) bool {
+	_, err := mail.ParseAddress(address)
+	return err == nil
+}
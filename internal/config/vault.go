@@ -0,0 +1,78 @@
+package config
+
+import "strings"
+
+// Vault is a separate AWS account that holds the target region copies, so
+// that credentials of the source account cannot delete them. RDS cannot
+// copy an encrypted snapshot shared from another account across regions,
+// so the source snapshot is first copied to the target region in the
+// source account, encrypted with SourceKMSKeyID, a customer managed key of
+// the source account that the vault may use. That copy is shared with the
+// vault account and copied there under a role assumed in the vault through
+// STS; the vault's copies are encrypted with KMSKeyID, a key of the vault,
+// exported with its ExportRoleARN and expired by the retention policy with
+// the vault credentials. Vault is configured when RoleARN is set; the vault
+// role is then the role of the target region.
+type Vault struct {
+	RoleARN        string
+	ExternalID     string
+	KMSKeyID       string
+	SourceKMSKeyID string
+	ExportRoleARN  string
+}
+
+// Enabled reports whether a vault account is configured.
+func (v Vault) Enabled() bool {
+	return v.RoleARN != ""
+}
+
+// awsManagedRDSKey is the alias of the key RDS encrypts with by default. It
+// is AWS managed, so snapshots encrypted with it cannot be shared.
+const awsManagedRDSKey = "alias/aws/rds"
+
+func (l *loader) loadVault(targetRegion string) Vault {
+	vault := Vault{
+		RoleARN:        l.getenv("VAULT_ROLE_ARN"),
+		ExternalID:     l.getenv("VAULT_EXTERNAL_ID"),
+		KMSKeyID:       l.getenv("VAULT_KMS_KEY_ID"),
+		SourceKMSKeyID: l.getenv("VAULT_SOURCE_KMS_KEY_ID"),
+		ExportRoleARN:  l.getenv("VAULT_EXPORT_ROLE_ARN"),
+	}
+	if !vault.Enabled() {
+		for _, key := range []string{"VAULT_EXTERNAL_ID", "VAULT_KMS_KEY_ID", "VAULT_SOURCE_KMS_KEY_ID", "VAULT_EXPORT_ROLE_ARN"} {
+			if l.getenv(key) != "" {
+				l.errorf("Missing required environment variable: VAULT_ROLE_ARN must be set with %s", l.setting(key))
+			}
+		}
+		return vault
+	}
+
+	if !validRoleARN(vault.RoleARN) {
+		l.errorf("Invalid %s: %q (expected arn:aws:iam::<account>:role/<name>)", l.setting("VAULT_ROLE_ARN"), vault.RoleARN)
+	}
+	switch {
+	case vault.KMSKeyID == "":
+		l.errorf("Missing required environment variable: VAULT_KMS_KEY_ID must be set with VAULT_ROLE_ARN")
+	case !validKMSKey(vault.KMSKeyID):
+		l.errorf("Invalid %s: %q (expected a key ID, key ARN, alias name or alias ARN)", l.setting("VAULT_KMS_KEY_ID"), vault.KMSKeyID)
+	case strings.HasPrefix(vault.KMSKeyID, "arn:") && targetRegion != "" && strings.Split(vault.KMSKeyID, ":")[3] != targetRegion:
+		l.errorf("Invalid %s: %q is not in %s", l.setting("VAULT_KMS_KEY_ID"), vault.KMSKeyID, targetRegion)
+	}
+	switch {
+	case vault.SourceKMSKeyID == "":
+		l.errorf("Missing required environment variable: VAULT_SOURCE_KMS_KEY_ID must be set with VAULT_ROLE_ARN")
+	case !validKMSKey(vault.SourceKMSKeyID):
+		l.errorf("Invalid %s: %q (expected a key ID, key ARN, alias name or alias ARN)", l.setting("VAULT_SOURCE_KMS_KEY_ID"), vault.SourceKMSKeyID)
+	case strings.HasPrefix(vault.SourceKMSKeyID, "arn:") && targetRegion != "" && strings.Split(vault.SourceKMSKeyID, ":")[3] != targetRegion:
+		l.errorf("Invalid %s: %q is not in %s", l.setting("VAULT_SOURCE_KMS_KEY_ID"), vault.SourceKMSKeyID, targetRegion)
+	case vault.SourceKMSKeyID == awsManagedRDSKey || strings.HasSuffix(vault.SourceKMSKeyID, ":"+awsManagedRDSKey):
+		l.errorf("Invalid %s: %q is AWS managed and cannot be shared with the vault (expected a customer managed key)", l.setting("VAULT_SOURCE_KMS_KEY_ID"), vault.SourceKMSKeyID)
+	}
+	switch {
+	case vault.ExportRoleARN == "":
+		l.errorf("Missing required environment variable: VAULT_EXPORT_ROLE_ARN must be set with VAULT_ROLE_ARN")
+	case !validRoleARN(vault.ExportRoleARN):
+		l.errorf("Invalid %s: %q (expected arn:aws:iam::<account>:role/<name>)", l.setting("VAULT_EXPORT_ROLE_ARN"), vault.ExportRoleARN)
+	}
+	return vault
+}
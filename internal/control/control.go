@@ -0,0 +1,153 @@
+// Package control serves an HTTP API to trigger, inspect and cancel the
+// backups of a running scheduler. Every request must carry the configured
+// token as "Authorization: Bearer <token>".
+//
+//	GET  /runs              runs in flight and recently finished
+//	POST /runs              start a backup: {"database": "...", "skipExport": false, "dryRun": false}
+//	GET  /runs/{id}         one run with its stage state and report
+//	POST /runs/{id}/cancel  cancel a run in flight
+//	GET  /schedules         scheduled jobs and their next run time
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/httpserver"
+	"github.com/unplank/rds-backup-lambda/internal/scheduler"
+)
+
+type server struct {
+	scheduler *scheduler.Scheduler
+	token     string
+}
+
+// TriggerRequest is the body of POST /runs.
+type TriggerRequest struct {
+	Database   string `json:"database"`
+	SkipExport bool   `json:"skipExport,omitempty"`
+	DryRun     bool   `json:"dryRun,omitempty"`
+}
+
+// Handler returns the API of s, protected by token.
+func Handler(s *scheduler.Scheduler, token string) http.Handler {
+	srv := &server{scheduler: s, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", srv.listRuns)
+	mux.HandleFunc("POST /runs", srv.trigger)
+	mux.HandleFunc("GET /runs/{id}", srv.getRun)
+	mux.HandleFunc("POST /runs/{id}/cancel", srv.cancel)
+	mux.HandleFunc("GET /schedules", srv.schedules)
+	return srv.authenticate(mux)
+}
+
+// Serve serves the API on addr until ctx is done.
+func Serve(ctx context.Context, addr string, s *scheduler.Scheduler, token string) error {
+	listening, err := httpserver.Serve(ctx, "Control API", addr, Handler(s, token))
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving control API on %s", listening)
+	return nil
+}
+
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) listRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.scheduler.Runs())
+}
+
+func (s *server) getRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.scheduler.LookupRun(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", scheduler.ErrRunNotFound, r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (s *server) trigger(w http.ResponseWriter, r *http.Request) {
+	var req TriggerRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Database == "" {
+		writeError(w, http.StatusBadRequest, errors.New("database is required"))
+		return
+	}
+
+	log.Printf("Backup of %s requested through the control API from %s", req.Database, r.RemoteAddr)
+	run, err := s.scheduler.Trigger(req.Database, backup.Options{
+		SkipExport: req.SkipExport,
+		DryRun:     req.DryRun,
+	})
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *server) cancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	log.Printf("Cancellation of run %s requested through the control API from %s", id, r.RemoteAddr)
+	if err := s.scheduler.Cancel(id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	run, _ := s.scheduler.LookupRun(id)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *server) schedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.scheduler.Schedules())
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownDatabase), errors.Is(err, scheduler.ErrRunNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, scheduler.ErrAlreadyRunning), errors.Is(err, scheduler.ErrRunFinished):
+		return http.StatusConflict
+	case errors.Is(err, scheduler.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Printf("Failed to write control API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,256 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/scheduler"
+	"github.com/unplank/rds-backup-lambda/internal/state"
+)
+
+const testToken = "s3cr3t"
+
+// perform is a backup that checkpoints, reports that it started and then
+// waits for release or for its context to be done.
+type perform struct {
+	started chan string
+	release chan struct{}
+}
+
+func newPerform() *perform {
+	return &perform{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (p *perform) run(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+	result.State.SourceSnapshotID = "backup-" + db.DBIdentifier + "-2025-01-10-12-00-00"
+	if err := opts.Checkpoint(result); err != nil {
+		return err
+	}
+	p.started <- result.RunID
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("interrupted while waiting: %w", ctx.Err())
+	}
+}
+
+func testConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		SourceRegion:   "us-east-1",
+		TargetRegion:   "us-west-2",
+		MaxConcurrency: 2,
+		StateStore:     "file://" + t.TempDir(),
+		Databases: []config.Database{{
+			DBIdentifier: "orders",
+			Schedules:    []config.Schedule{{Name: "daily", Spec: "0 2 * * *", Timezone: "Europe/Berlin"}},
+		}},
+	}
+}
+
+// newServer returns a control API over a scheduler of cfg whose backups
+// are run by p.
+func newServer(t *testing.T, cfg *config.Config, p *perform) (*httptest.Server, *scheduler.Scheduler) {
+	t.Helper()
+	s, err := scheduler.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s.Perform = p.run
+	server := httptest.NewServer(Handler(s, testToken))
+	t.Cleanup(func() {
+		server.Close()
+		s.Shutdown(5 * time.Second)
+	})
+	return server, s
+}
+
+// call makes a request with token and decodes the JSON response into out,
+// if it is not nil. It returns the status code.
+func call(t *testing.T, server *httptest.Server, method, path, token string, body any, out any) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: invalid response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitForStatus polls run id until it has status.
+func waitForStatus(t *testing.T, server *httptest.Server, id, status string) *state.Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var run state.Run
+		if code := call(t, server, http.MethodGet, "/runs/"+id, testToken, nil, &run); code == http.StatusOK && run.Status == status {
+			return &run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s did not become %s, it is %s", id, status, run.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server, _ := newServer(t, testConfig(t), newPerform())
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "missing token", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "guess", want: http.StatusUnauthorized},
+		{name: "token", token: testToken, want: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/runs", "/schedules"} {
+				if code := call(t, server, http.MethodGet, path, tt.token, nil, nil); code != tt.want {
+					t.Errorf("GET %s: status %d, want %d", path, code, tt.want)
+				}
+			}
+			body := TriggerRequest{Database: "orders", DryRun: true}
+			if tt.want == http.StatusUnauthorized {
+				if code := call(t, server, http.MethodPost, "/runs", tt.token, body, nil); code != tt.want {
+					t.Errorf("POST /runs: status %d, want %d", code, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTriggerUnknownDatabase(t *testing.T) {
+	server, _ := newServer(t, testConfig(t), newPerform())
+
+	var resp map[string]string
+	code := call(t, server, http.MethodPost, "/runs", testToken, TriggerRequest{Database: "users"}, &resp)
+	if code != http.StatusNotFound || resp["error"] != "database is not configured: users" {
+		t.Errorf("status %d, response %v", code, resp)
+	}
+}
+
+func TestTriggerWhileRunning(t *testing.T) {
+	p := newPerform()
+	server, _ := newServer(t, testConfig(t), p)
+
+	var run state.Run
+	if code := call(t, server, http.MethodPost, "/runs", testToken, TriggerRequest{Database: "orders"}, &run); code != http.StatusAccepted {
+		t.Fatalf("status %d", code)
+	}
+	<-p.started
+
+	var resp map[string]string
+	if code := call(t, server, http.MethodPost, "/runs", testToken, TriggerRequest{Database: "orders"}, &resp); code != http.StatusConflict {
+		t.Errorf("second trigger: status %d, response %v", code, resp)
+	}
+
+	close(p.release)
+	waitForStatus(t, server, run.ID, state.StatusComplete)
+	if code := call(t, server, http.MethodPost, "/runs", testToken, TriggerRequest{Database: "orders", DryRun: true}, nil); code != http.StatusAccepted {
+		t.Errorf("trigger after the run finished: status %d", code)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cfg := testConfig(t)
+	p := newPerform()
+	server, _ := newServer(t, cfg, p)
+
+	var run state.Run
+	if code := call(t, server, http.MethodPost, "/runs", testToken, TriggerRequest{Database: "orders"}, &run); code != http.StatusAccepted {
+		t.Fatalf("status %d", code)
+	}
+	<-p.started
+
+	if code := call(t, server, http.MethodPost, "/runs/"+run.ID+"/cancel", testToken, nil, nil); code != http.StatusAccepted {
+		t.Fatalf("cancel: status %d", code)
+	}
+	canceled := waitForStatus(t, server, run.ID, state.StatusCanceled)
+	if canceled.Result.ErrorMessage == "" || canceled.Result.Interrupted {
+		t.Errorf("result %+v", canceled.Result)
+	}
+	if code := call(t, server, http.MethodPost, "/runs/"+run.ID+"/cancel", testToken, nil, nil); code != http.StatusConflict {
+		t.Errorf("second cancel: status %d", code)
+	}
+	if code := call(t, server, http.MethodPost, "/runs/unknown/cancel", testToken, nil, nil); code != http.StatusNotFound {
+		t.Errorf("cancel of an unknown run: status %d", code)
+	}
+
+	// The next start does not resume the canceled run
+	next, err := scheduler.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Perform = func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+		t.Errorf("run %s resumed", result.RunID)
+		return nil
+	}
+	if results := next.Resume(); len(results) != 0 {
+		t.Errorf("resumed %d run(s)", len(results))
+	}
+	store, err := state.Open(context.Background(), cfg.StateStore, cfg.SourceRegion, cfg.SourceRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != run.ID || runs[0].Status != state.StatusCanceled {
+		t.Errorf("saved runs %+v", runs)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	server, s := newServer(t, testConfig(t), newPerform())
+	s.Start()
+	defer s.Stop()
+
+	var jobs []scheduler.ScheduledJob
+	if code := call(t, server, http.MethodGet, "/schedules", testToken, nil, &jobs); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("jobs %+v", jobs)
+	}
+	job := jobs[0]
+	if job.Name != "daily" || job.Spec != "CRON_TZ=Europe/Berlin 0 2 * * *" || len(job.Databases) != 1 || job.Databases[0] != "orders" {
+		t.Errorf("job %+v", job)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	next := job.Next.In(berlin)
+	if !next.After(time.Now()) || next.Sub(time.Now()) > 25*time.Hour || next.Hour() != 2 || next.Minute() != 0 {
+		t.Errorf("next run at %s, want the next 02:00 in Berlin", next)
+	}
+}
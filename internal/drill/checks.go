@@ -0,0 +1,171 @@
+package drill
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/restore"
+)
+
+// checkTimeout bounds each check query.
+const checkTimeout = 5 * time.Minute
+
+// CheckResult is the outcome of one check. Value is the value returned by
+// the query.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Value  string `json:"value,omitempty"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// openDB connects to the restored database. The driver is chosen by engine.
+var openDB = func(engine, address string, drill config.Drill) (*sql.DB, error) {
+	switch {
+	case strings.Contains(engine, "postgres"):
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(drill.Username, string(drill.Password)),
+			Host:     address,
+			Path:     "/" + drill.DBName,
+			RawQuery: "sslmode=require",
+		}
+		return sql.Open("pgx", dsn.String())
+	case strings.Contains(engine, "mysql"), engine == "mariadb":
+		dsn := mysql.NewConfig()
+		dsn.User = drill.Username
+		dsn.Passwd = string(drill.Password)
+		dsn.Net = "tcp"
+		dsn.Addr = address
+		dsn.DBName = drill.DBName
+		dsn.TLSConfig = "preferred"
+		dsn.ParseTime = true
+		return sql.Open("mysql", dsn.FormatDSN())
+	default:
+		return nil, fmt.Errorf("SQL checks are not supported for engine %q", engine)
+	}
+}
+
+// runChecks runs the checks of db against the restored instance. An error
+// is returned only if the instance cannot be connected to; failing checks
+// are reported in the results.
+func runChecks(ctx context.Context, db *config.Database, restored *restore.Result) ([]CheckResult, error) {
+	address := net.JoinHostPort(restored.Endpoint, strconv.Itoa(int(restored.Port)))
+	conn, err := openDB(restored.Engine, address, db.Drill)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	pingCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := conn.PingContext(pingCtx); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	results := make([]CheckResult, 0, len(db.Drill.Checks))
+	for _, check := range db.Drill.Checks {
+		result := runCheck(ctx, conn, check, restored.SnapshotTime)
+		if result.Passed {
+			log.Printf("Drill check %q of %s passed: %s", check.Name, db.DBIdentifier, result.Value)
+		} else {
+			log.Printf("Drill check %q of %s failed: %s", check.Name, db.DBIdentifier, result.Error)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runCheck(ctx context.Context, conn *sql.DB, check config.Check, snapshotTime time.Time) CheckResult {
+	result := CheckResult{Name: check.Name, Query: check.Query}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var value any
+	if err := conn.QueryRowContext(ctx, check.Query).Scan(&value); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	switch v := value.(type) {
+	case []byte:
+		value = string(v)
+		result.Value = string(v)
+	case time.Time:
+		result.Value = v.Format(time.RFC3339)
+	default:
+		result.Value = fmt.Sprint(value)
+	}
+
+	if err := evaluate(check, value, snapshotTime); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// evaluate checks value against the bounds of check.
+func evaluate(check config.Check, value any, snapshotTime time.Time) error {
+	if check.Min != nil || check.Max != nil {
+		n, err := number(value)
+		if err != nil {
+			return err
+		}
+		if check.Min != nil && n < *check.Min {
+			return fmt.Errorf("%v is below the minimum of %v", value, *check.Min)
+		}
+		if check.Max != nil && n > *check.Max {
+			return fmt.Errorf("%v is above the maximum of %v", value, *check.Max)
+		}
+	}
+
+	if check.MaxAge > 0 {
+		t, err := timestamp(value)
+		if err != nil {
+			return err
+		}
+		if age := snapshotTime.Sub(t); age > check.MaxAge {
+			return fmt.Errorf("%s is %s older than the snapshot, more than %s", t.Format(time.RFC3339), age.Round(time.Second), check.MaxAge)
+		}
+	}
+	return nil
+}
+
+func number(value any) (float64, error) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not a number", value)
+}
+
+func timestamp(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.DateTime, "2006-01-02 15:04:05.999999999-07"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("%v is not a timestamp", value)
+}
@@ -0,0 +1,185 @@
+// Package drill verifies that backups can be restored. A drill restores the
+// latest copy-backup-* snapshot, made by the copy stage of the backup
+// pipeline, in the target region to a throwaway instance, runs SQL checks
+// against it and deletes it again.
+package drill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/restore"
+)
+
+const (
+	// instancePrefix starts the name of every drill instance, so that one
+	// left behind by a crash is easy to find.
+	instancePrefix = "drill-"
+
+	// teardownTimeout bounds the deletion of the drill instance, which goes
+	// on after the drill is cancelled.
+	teardownTimeout = time.Hour
+)
+
+var (
+	// newClients is replaced in tests to run drills against fakes.
+	newClients = awsinternal.NewClients
+
+	// Waiter delays, replaced in tests.
+	waiterMinDelay = 30 * time.Second
+	waiterMaxDelay = 2 * time.Minute
+)
+
+// Result is the outcome of a drill. Passed is true when the snapshot was
+// restored and every check passed; a failure to delete the instance does
+// not fail the drill but is reported as TeardownError.
+type Result struct {
+	DBIdentifier  string          `json:"dbIdentifier"`
+	Restore       *restore.Result `json:"restore,omitempty"`
+	Checks        []CheckResult   `json:"checks,omitempty"`
+	Passed        bool            `json:"passed"`
+	ErrorMessage  string          `json:"errorMessage,omitempty"`
+	TornDown      bool            `json:"tornDown"`
+	TeardownError string          `json:"teardownError,omitempty"`
+	Started       time.Time       `json:"started"`
+	Finished      time.Time       `json:"finished"`
+}
+
+// Duration returns how long the drill took, from restore to teardown.
+func (r *Result) Duration() time.Duration {
+	return r.Finished.Sub(r.Started).Round(time.Second)
+}
+
+// Run runs the drill of db and returns its result. Errors are reported in
+// the result.
+func Run(ctx context.Context, cfg *config.Config, db *config.Database) *Result {
+	result := &Result{DBIdentifier: db.DBIdentifier, Started: time.Now()}
+	defer func() {
+		result.Finished = time.Now()
+	}()
+
+	clients, err := newClients(ctx, cfg)
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	if err := run(ctx, clients, db, result); err != nil {
+		result.ErrorMessage = err.Error()
+		log.Printf("Restore drill of %s failed: %v", db.DBIdentifier, err)
+	} else {
+		result.Passed = true
+		log.Printf("Restore drill of %s passed", db.DBIdentifier)
+	}
+	return result
+}
+
+func run(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, result *Result) error {
+	restored, err := restore.Restore(ctx, clients, db, restore.Options{
+		Region:         restore.RegionTarget,
+		InstanceID:     instanceID(db.DBIdentifier, time.Now()),
+		InstanceClass:  db.Drill.InstanceClass,
+		SubnetGroup:    db.Drill.SubnetGroup,
+		SecurityGroups: db.Drill.SecurityGroups,
+		ParameterGroup: db.Drill.ParameterGroup,
+	})
+	result.Restore = restored
+	if restored != nil {
+		// The instance is deleted even if the drill is cancelled.
+		defer func() {
+			teardownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), teardownTimeout)
+			defer cancel()
+			if err := teardown(teardownCtx, clients.TargetRDS, restored); err != nil {
+				result.TeardownError = err.Error()
+				log.Printf("Warning: Failed to delete drill instance %s: %v", restored.InstanceID, err)
+				return
+			}
+			result.TornDown = true
+		}()
+	}
+	if err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	if len(db.Drill.Checks) == 0 {
+		return nil
+	}
+	result.Checks, err = runChecks(ctx, db, restored)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, check := range result.Checks {
+		if !check.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(result.Checks))
+	}
+	return nil
+}
+
+// instanceID names the drill instance of a database. RDS identifiers are at
+// most 63 characters, including the suffix of a cluster's writer instance.
+func instanceID(database string, now time.Time) string {
+	suffix := "-" + now.UTC().Format("20060102-150405")
+	const maxLength = 63 - len("-instance-1")
+	if max := maxLength - len(instancePrefix) - len(suffix); len(database) > max {
+		database = strings.TrimRight(database[:max], "-")
+	}
+	return instancePrefix + database + suffix
+}
+
+// teardown deletes the restored instance without a final snapshot and, for
+// a cluster, the cluster once its instance is gone.
+func teardown(ctx context.Context, rdsClient awsinternal.RDSAPI, restored *restore.Result) error {
+	if restored.Status != "" {
+		log.Printf("Deleting drill instance %s", restored.InstanceID)
+		_, err := rdsClient.DeleteDBInstance(ctx, &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier:   aws.String(restored.InstanceID),
+			SkipFinalSnapshot:      aws.Bool(true),
+			DeleteAutomatedBackups: aws.Bool(true),
+		})
+		var notFound *types.DBInstanceNotFoundFault
+		if err != nil && !errors.As(err, &notFound) {
+			return fmt.Errorf("failed to delete instance %s: %w", restored.InstanceID, err)
+		}
+	}
+	if restored.ClusterID == "" {
+		return nil
+	}
+
+	if restored.Status != "" {
+		waiter := rds.NewDBInstanceDeletedWaiter(rdsClient, func(o *rds.DBInstanceDeletedWaiterOptions) {
+			o.MinDelay = waiterMinDelay
+			o.MaxDelay = waiterMaxDelay
+		})
+		err := waiter.Wait(ctx, &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(restored.InstanceID),
+		}, teardownTimeout)
+		if err != nil {
+			return fmt.Errorf("instance %s was not deleted: %w", restored.InstanceID, err)
+		}
+	}
+
+	log.Printf("Deleting drill cluster %s", restored.ClusterID)
+	_, err := rdsClient.DeleteDBCluster(ctx, &rds.DeleteDBClusterInput{
+		DBClusterIdentifier: aws.String(restored.ClusterID),
+		SkipFinalSnapshot:   aws.Bool(true),
+	})
+	var notFound *types.DBClusterNotFoundFault
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("failed to delete cluster %s: %w", restored.ClusterID, err)
+	}
+	return nil
+}
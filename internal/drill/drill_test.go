@@ -0,0 +1,102 @@
+package drill
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// useFakes runs the drills of the test against cloud.
+func useFakes(t *testing.T, cloud *fake.Cloud) {
+	t.Helper()
+	clients, minDelay, maxDelay := newClients, waiterMinDelay, waiterMaxDelay
+	newClients = func(ctx context.Context, cfg *config.Config) (*awsinternal.AWSClients, error) {
+		return cloud.Clients(), nil
+	}
+	waiterMinDelay, waiterMaxDelay = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() {
+		newClients, waiterMinDelay, waiterMaxDelay = clients, minDelay, maxDelay
+	})
+}
+
+func TestRun(t *testing.T) {
+	created := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		mode    string
+		setup   func(cloud *fake.Cloud)
+		wantErr string
+	}{
+		{
+			name: "instance",
+			mode: config.ModeInstance,
+			setup: func(cloud *fake.Cloud) {
+				cloud.TargetRDS.AddSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", created)
+			},
+		},
+		{
+			name: "cluster",
+			mode: config.ModeCluster,
+			setup: func(cloud *fake.Cloud) {
+				cloud.TargetRDS.AddClusterSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", created)
+			},
+		},
+		{
+			name:    "no snapshot",
+			mode:    config.ModeInstance,
+			setup:   func(cloud *fake.Cloud) {},
+			wantErr: "no available backup snapshot of orders in us-west-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			tt.setup(cloud)
+			useFakes(t, cloud)
+
+			db := &config.Database{
+				DBIdentifier: "orders",
+				Mode:         tt.mode,
+				Drill:        config.Drill{InstanceClass: "db.t4g.medium"},
+			}
+			result := Run(context.Background(), &config.Config{}, db)
+
+			if tt.wantErr != "" {
+				if result.Passed || !strings.Contains(result.ErrorMessage, tt.wantErr) {
+					t.Fatalf("passed %v with error %q, want %q", result.Passed, result.ErrorMessage, tt.wantErr)
+				}
+				return
+			}
+			if !result.Passed {
+				t.Fatalf("drill failed: %s", result.ErrorMessage)
+			}
+			if !result.TornDown {
+				t.Errorf("drill not torn down: %s", result.TeardownError)
+			}
+			if got := cloud.TargetRDS.Instances(); len(got) != 0 {
+				t.Errorf("instances %q left behind", got)
+			}
+			if got := cloud.TargetRDS.Clusters(); len(got) != 0 {
+				t.Errorf("clusters %q left behind", got)
+			}
+		})
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	if got, want := instanceID("orders", now), "drill-orders-20250110-120000"; got != want {
+		t.Errorf("instanceID = %q, want %q", got, want)
+	}
+	long := instanceID(strings.Repeat("a", 60)+"-b", now)
+	if len(long)+len("-instance-1") > 63 {
+		t.Errorf("instanceID %q is too long", long)
+	}
+}
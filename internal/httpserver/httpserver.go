@@ -0,0 +1,43 @@
+// Package httpserver runs the HTTP endpoints of the scheduler, such as the
+// metrics and the control API, for the lifetime of a context.
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds the wait for requests in flight once the context
+// is done.
+const shutdownTimeout = 5 * time.Second
+
+// Serve listens on addr and serves handler in the background until ctx is
+// done, then shuts the server down. It returns the address listened on,
+// which differs from addr if addr has port 0. name identifies the server in
+// log messages.
+func Serve(ctx context.Context, name, addr string, handler http.Handler) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("%s stopped: %v", name, err)
+		}
+	}()
+	return listener.Addr(), nil
+}
@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	addr, err := Serve(ctx, "Test server", "127.0.0.1:0", handler)
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body %q, want %q", body, "ok")
+	}
+
+	// Once ctx is done the server stops listening
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server still listening after the context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, err := Serve(ctx, "Test server", "127.0.0.1:0", http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	_, err = Serve(ctx, "Test server", addr.String(), http.NotFoundHandler())
+	if err == nil || !strings.Contains(err.Error(), "failed to listen on "+addr.String()) {
+		t.Errorf("error %v, want a listen error", err)
+	}
+}
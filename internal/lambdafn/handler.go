@@ -0,0 +1,184 @@
+package lambdafn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/notification"
+	"github.com/unplank/rds-backup-lambda/internal/scheduler"
+	"github.com/unplank/rds-backup-lambda/internal/state"
+)
+
+const (
+	StatusComplete = "complete"
+	StatusPending  = "pending"
+	StatusFailed   = "failed"
+)
+
+// Event is the Lambda payload. Database may be omitted when only one
+// database is configured. Steps limits the run to some stages, by default all
+// of them. Result is empty on the first invocation; a pending response
+// returns it and it must be passed back unchanged to continue the run.
+type Event struct {
+	Database   string         `json:"database,omitempty"`
+	Steps      []string       `json:"steps,omitempty"`
+	SkipExport bool           `json:"skipExport,omitempty"`
+	DryRun     bool           `json:"dryRun,omitempty"`
+	Overrides  Overrides      `json:"overrides,omitempty"`
+	Result     *backup.Result `json:"result,omitempty"`
+}
+
+// Overrides replace the configured settings of the database for one run.
+// They are validated like the configuration.
+type Overrides struct {
+	SourceBucket       string `json:"sourceBucket,omitempty"`
+	TargetBucket       string `json:"targetBucket,omitempty"`
+	KMSKeyID           string `json:"kmsKeyId,omitempty"`
+	SourceKMSKeyID     string `json:"sourceKmsKeyId,omitempty"`
+	TargetKMSKeyID     string `json:"targetKmsKeyId,omitempty"`
+	ExportRoleARN      string `json:"exportRoleArn,omitempty"`
+	KeepSourceSnapshot *bool  `json:"keepSourceSnapshot,omitempty"`
+	StoreToSourceS3    *bool  `json:"storeToSourceS3,omitempty"`
+}
+
+// Response reports the outcome of an invocation. While Status is pending,
+// Next holds the event for the next invocation, which a Step Functions state
+// machine can pass back after a Wait state.
+type Response struct {
+	Status string         `json:"status"`
+	Result *backup.Result `json:"result"`
+	Next   *Event         `json:"next,omitempty"`
+}
+
+var (
+	// loadConfig and newClients are replaced in tests to run invocations
+	// against fakes.
+	loadConfig = config.Load
+	newClients = awsinternal.NewClients
+)
+
+func Start() {
+	lambda.Start(Handle)
+}
+
+// Handle runs the requested steps without blocking on long AWS operations.
+// Snapshots, copies and exports that are still in progress end the
+// invocation with a pending response instead of polling past the Lambda
+// timeout. Notifications are sent once the run completes or fails.
+//
+// A backup that fails is reported with a failed response and a nil error, so
+// that a state machine can branch on Status and the result is not lost. An
+// error is returned only when the run cannot start: the configuration or the
+// event is invalid.
+func Handle(ctx context.Context, event Event) (*Response, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := selectDatabase(cfg, event.Database)
+	if err != nil {
+		return nil, err
+	}
+	if event.Overrides != (Overrides{}) {
+		event.Overrides.apply(&db)
+		if err := db.Validate(cfg); err != nil {
+			return nil, fmt.Errorf("invalid overrides: %w", err)
+		}
+	}
+
+	steps, err := backup.ParseStages(event.Steps)
+	if err != nil {
+		return nil, err
+	}
+
+	result := event.Result
+	if result == nil {
+		started := time.Now()
+		result = &backup.Result{
+			RunID:        state.NewRunID(db.DBIdentifier, started),
+			DBIdentifier: db.DBIdentifier,
+			BackupTime:   started.Format(time.RFC3339),
+		}
+	}
+
+	opts := backup.Options{
+		SkipExport: event.SkipExport,
+		DryRun:     event.DryRun,
+		NoWait:     true,
+		Steps:      steps,
+		CheckNotifiers: func(ctx context.Context) []error {
+			return notification.Check(ctx, cfg)
+		},
+	}
+
+	log.Printf("Running backup steps %v for %s", event.Steps, db.DBIdentifier)
+	clients, err := newClients(ctx, cfg)
+	if err == nil {
+		err = backup.PerformWith(ctx, clients, cfg, &db, opts, result)
+	}
+	switch {
+	case errors.Is(err, backup.ErrPending):
+		log.Printf("Backup of %s is still in progress: %v", db.DBIdentifier, err)
+		next := event
+		next.Result = result
+		return &Response{Status: StatusPending, Result: result, Next: &next}, nil
+	case err != nil:
+		result.ErrorMessage = err.Error()
+		scheduler.Notify(cfg, []*backup.Result{result})
+		log.Printf("Backup failed for %s: %v", db.DBIdentifier, err)
+		return &Response{Status: StatusFailed, Result: result}, nil
+	}
+
+	scheduler.Notify(cfg, []*backup.Result{result})
+	return &Response{Status: StatusComplete, Result: result}, nil
+}
+
+func selectDatabase(cfg *config.Config, id string) (config.Database, error) {
+	if id == "" {
+		if len(cfg.Databases) != 1 {
+			return config.Database{}, fmt.Errorf("event must name the database, %d are configured", len(cfg.Databases))
+		}
+		return cfg.Databases[0], nil
+	}
+
+	db, ok := cfg.Database(id)
+	if !ok {
+		return config.Database{}, fmt.Errorf("database %q is not configured", id)
+	}
+	return *db, nil
+}
+
+func (o Overrides) apply(db *config.Database) {
+	if o.SourceBucket != "" {
+		db.SourceBucket = o.SourceBucket
+	}
+	if o.TargetBucket != "" {
+		db.TargetBucket = o.TargetBucket
+	}
+	if o.KMSKeyID != "" {
+		db.SourceKMSKeyID, db.TargetKMSKeyID = o.KMSKeyID, o.KMSKeyID
+	}
+	if o.SourceKMSKeyID != "" {
+		db.SourceKMSKeyID = o.SourceKMSKeyID
+	}
+	if o.TargetKMSKeyID != "" {
+		db.TargetKMSKeyID = o.TargetKMSKeyID
+	}
+	if o.ExportRoleARN != "" {
+		db.ExportRoleARN = o.ExportRoleARN
+	}
+	if o.KeepSourceSnapshot != nil {
+		db.KeepSourceSnapshot = *o.KeepSourceSnapshot
+	}
+	if o.StoreToSourceS3 != nil {
+		db.StoreToSourceS3 = *o.StoreToSourceS3
+	}
+}
@@ -0,0 +1,177 @@
+package lambdafn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/notification"
+)
+
+// setup runs invocations against cloud, whose RDS operations stay in
+// progress for pending describe calls. It returns the subjects of the
+// notifications sent.
+func setup(t *testing.T, pending int) (*fake.Cloud, func() []string) {
+	t.Helper()
+	cloud := fake.New("us-east-1", "us-west-2")
+	cloud.SourceRDS.Pending = pending
+	cloud.TargetRDS.Pending = pending
+	cloud.SourceRDS.AddInstance("orders", "available")
+	cloud.TargetS3.AddBucket("target-backups")
+	cloud.TargetKMS.AddKey("target-key", true)
+	cloud.TargetKMS.AddAlias("alias/rds-backup", "target-key")
+	roleArn := cloud.IAM.AddRole("rds-export", "export.rds.amazonaws.com")
+
+	var mu sync.Mutex
+	var subjects []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg notification.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		mu.Lock()
+		subjects = append(subjects, msg.Subject)
+		mu.Unlock()
+	}))
+	t.Cleanup(webhook.Close)
+
+	cfg := &config.Config{
+		SourceRegion: "us-east-1",
+		TargetRegion: "us-west-2",
+		Databases: []config.Database{{
+			DBIdentifier:   "orders",
+			Mode:           config.ModeInstance,
+			TargetBucket:   "target-backups",
+			ExportRoleARN:  roleArn,
+			TargetKMSKeyID: "alias/rds-backup",
+		}},
+		Channels: []config.Channel{{
+			Name: "hook",
+			Type: config.ChannelWebhook,
+			URL:  config.Secret(webhook.URL),
+			On:   []string{config.EventSuccess, config.EventFailure},
+		}},
+	}
+
+	load, clients := loadConfig, newClients
+	loadConfig = func() (*config.Config, error) { return cfg, nil }
+	newClients = func(ctx context.Context, cfg *config.Config) (*awsinternal.AWSClients, error) {
+		return cloud.Clients(), nil
+	}
+	t.Cleanup(func() { loadConfig, newClients = load, clients })
+
+	return cloud, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(subjects)
+	}
+}
+
+// invoke calls Handle with event, passing it through JSON like the Lambda
+// runtime does.
+func invoke(t *testing.T, event Event) (*Response, error) {
+	t.Helper()
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Event
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return Handle(context.Background(), decoded)
+}
+
+func TestHandleResumesPendingRuns(t *testing.T) {
+	cloud, notifications := setup(t, 2)
+
+	resp, err := invoke(t, Event{})
+	if err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	runID := resp.Result.RunID
+	var invocations, creates int
+	for resp.Status == StatusPending {
+		if invocations++; invocations > 20 {
+			t.Fatalf("still pending after %d invocations: %+v", invocations, resp.Result.State)
+		}
+		if resp.Next == nil || resp.Next.Result.RunID != runID {
+			t.Fatalf("pending response %+v does not continue run %s", resp, runID)
+		}
+		if got := notifications(); len(got) != 0 {
+			t.Fatalf("notifications %q while the run is pending", got)
+		}
+		if resp, err = invoke(t, *resp.Next); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	if resp.Status != StatusComplete || resp.Result.ErrorMessage != "" {
+		t.Fatalf("response %s: %+v", resp.Status, resp.Result)
+	}
+	if invocations == 0 {
+		t.Errorf("the run never returned a pending response")
+	}
+	for _, stage := range []backup.Stage{backup.StagePreflight, backup.StageSnapshot, backup.StageCopy, backup.StageExportTarget, backup.StageCleanup} {
+		if !resp.Result.State.Done(stage) {
+			t.Errorf("stage %s not completed", stage)
+		}
+	}
+	for _, call := range cloud.SourceRDS.Calls() {
+		if call == "CreateDBSnapshot" {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Errorf("%d CreateDBSnapshot calls, want 1", creates)
+	}
+	if got := cloud.TargetRDS.SnapshotIDs(); !slices.Equal(got, []string{resp.Result.State.TargetSnapshotID}) {
+		t.Errorf("target snapshots %q, want %q", got, resp.Result.State.TargetSnapshotID)
+	}
+	if got := notifications(); !slices.Equal(got, []string{"RDS Backup Successful"}) {
+		t.Errorf("notifications %q", got)
+	}
+}
+
+func TestHandleReportsFailures(t *testing.T) {
+	cloud, notifications := setup(t, 0)
+	cloud.SourceRDS.FailNext("CreateDBSnapshot", errors.New("throttled"))
+
+	resp, err := invoke(t, Event{Database: "orders"})
+	if err != nil {
+		t.Fatalf("Handle returned the error of a failed run: %v", err)
+	}
+	if resp.Status != StatusFailed || resp.Next != nil || resp.Result.ErrorMessage == "" {
+		t.Errorf("response %s: %+v", resp.Status, resp.Result)
+	}
+	if got := notifications(); !slices.Equal(got, []string{"RDS Backup Failed"}) {
+		t.Errorf("notifications %q", got)
+	}
+}
+
+func TestHandleRejectsInvalidEvents(t *testing.T) {
+	_, notifications := setup(t, 0)
+
+	for _, event := range []Event{
+		{Database: "users"},
+		{Steps: []string{"snapshot", "vacuum"}},
+		{Overrides: Overrides{TargetKMSKeyID: "not a key"}},
+	} {
+		resp, err := invoke(t, event)
+		if err == nil || resp != nil {
+			t.Errorf("event %+v: response %+v, error %v", event, resp, err)
+		}
+	}
+	if got := notifications(); len(got) != 0 {
+		t.Errorf("notifications %q", got)
+	}
+}
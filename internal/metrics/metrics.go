@@ -0,0 +1,93 @@
+// Package metrics exposes the health of the backups to Prometheus, so that
+// missed backups can be alerted on without relying on notifications.
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/unplank/rds-backup-lambda/internal/httpserver"
+)
+
+const namespace = "rds_backup"
+
+var (
+	LastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time of the last successful backup of the database that exported it to S3.",
+	}, []string{"database"})
+
+	Runs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Backup runs by outcome: complete, failed, canceled or interrupted.",
+	}, []string{"database", "status"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the stages of a backup.",
+		// 10s to about 11h
+		Buckets: prometheus.ExponentialBuckets(10, 2, 13),
+	}, []string{"database", "stage"})
+
+	SnapshotRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshot_retries_total",
+		Help:      "Retries while waiting for the database to be available and taking its snapshot.",
+	}, []string{"database"})
+
+	ExportTasks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "export_tasks_total",
+		Help:      "Finished S3 export tasks by final status: COMPLETE, FAILED or CANCELED.",
+	}, []string{"database", "region", "status"})
+
+	IncompleteExports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incomplete_exports_total",
+		Help:      "Export tasks reported COMPLETE whose files in S3 are empty or partial.",
+	}, []string{"database", "region"})
+
+	SnapshotsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshots_deleted_total",
+		Help:      "Snapshots deleted by the retention policy or because the source snapshot is not kept.",
+	}, []string{"database", "region"})
+
+	Drills = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drills_total",
+		Help:      "Restore drills by outcome: passed or failed.",
+	}, []string{"database", "status"})
+
+	LastDrillSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_drill_success_timestamp_seconds",
+		Help:      "Time of the last passed restore drill of the database.",
+	}, []string{"database"})
+
+	NotificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_failures_total",
+		Help:      "Notifications that could not be delivered.",
+	}, []string{"channel", "type"})
+)
+
+// Serve exposes the metrics on addr at /metrics until ctx is done.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	listening, err := httpserver.Serve(ctx, "Metrics server", addr, mux)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving metrics on %s/metrics", listening)
+	return nil
+}
@@ -2,53 +2,240 @@ package notification
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"os"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ses"
-	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
 	"github.com/unplank/rds-backup-lambda/internal/config"
 )
 
-type EmailParams struct {
-	// To      string
-	Emails  []string
-	Subject string
-	Body    string
+// reportAttachment is the file name of the JSON report attached to emails.
+const reportAttachment = "rds-backup-report.json"
+
+// newSESClient is replaced in tests to capture emails instead of sending them.
+var newSESClient = func(ctx context.Context, region string, role config.Role) (awsinternal.SESAPI, error) {
+	cfg, err := awsinternal.LoadConfig(ctx, region, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SES config: %w", err)
+	}
+	return ses.NewFromConfig(cfg), nil
+}
+
+// SESNotifier sends email through Amazon SES.
+type SESNotifier struct {
+	client awsinternal.SESAPI
+	from   string
+	to     []string
+}
+
+func NewSES(client awsinternal.SESAPI, from string, to []string) *SESNotifier {
+	return &SESNotifier{client: client, from: from, to: to}
 }
 
-func SendSuccessEmail(cfg *config.Config, result *backup.Result) error {
-	body, err := generateEmailContent(successEmailTemplate, *result)
+func (n *SESNotifier) Notify(ctx context.Context, msg *Message) error {
+	data, err := composeEmail(n.from, n.to, msg)
 	if err != nil {
 		return err
 	}
 
-	return sendEmail(EmailParams{
-		// To:      cfg.AdminEmail,
-		Emails:  cfg.Emails,
-		Subject: "RDS Backup Successful",
-		Body:    body,
+	_, err = n.client.SendRawEmail(ctx, &ses.SendRawEmailInput{
+		RawMessage: &types.RawMessage{Data: data},
 	})
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
 }
 
-func SendFailureEmail(cfg *config.Config, result *backup.Result) error {
-	body, err := generateEmailContent(failureEmailTemplate, *result)
+// Check verifies that the account may send email and that the sender
+// address, or its domain, is a verified SES identity.
+func (n *SESNotifier) Check(ctx context.Context) error {
+	sending, err := n.client.GetAccountSendingEnabled(ctx, &ses.GetAccountSendingEnabledInput{})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to check SES sending: %w", err)
+	}
+	if !sending.Enabled {
+		return errors.New("SES sending is disabled for the account")
 	}
 
-	return sendEmail(EmailParams{
-		// To:      cfg.AdminEmail,
-		Emails:  cfg.Emails,
-		Subject: "RDS Backup Failed",
-		Body:    body,
+	address := envelopeAddress(n.from)
+	identities := []string{address}
+	if _, domain, ok := strings.Cut(address, "@"); ok {
+		identities = append(identities, domain)
+	}
+	output, err := n.client.GetIdentityVerificationAttributes(ctx, &ses.GetIdentityVerificationAttributesInput{Identities: identities})
+	if err != nil {
+		return fmt.Errorf("failed to check SES identity %s: %w", address, err)
+	}
+	for _, identity := range identities {
+		if output.VerificationAttributes[identity].VerificationStatus == types.VerificationStatusSuccess {
+			return nil
+		}
+	}
+	return fmt.Errorf("sender %s is not a verified SES identity", address)
+}
+
+// composeEmail builds the MIME message of msg: the plain text and HTML
+// renderings as alternatives, and the results with their reports, or the
+// drill result, attached as JSON.
+func composeEmail(from string, to []string, msg *Message) ([]byte, error) {
+	html, err := renderHTML(msg)
+	if err != nil {
+		return nil, err
+	}
+	var attachment any = msg.Results
+	if msg.Drill != nil {
+		attachment = msg.Drill
+	}
+	report, err := json.MarshalIndent(attachment, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	var alternatives bytes.Buffer
+	bodies := multipart.NewWriter(&alternatives)
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain", renderText(msg)},
+		{"text/html", html},
+	} {
+		w, err := bodies.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(body.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := bodies.Close(); err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	parts := multipart.NewWriter(&content)
+	w, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + bodies.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(alternatives.Bytes()); err != nil {
+		return nil, err
+	}
+	w, err = parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json; name=" + reportAttachment},
+		"Content-Disposition":       {"attachment; filename=" + reportAttachment},
+		"Content-Transfer-Encoding": {"base64"},
 	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(base64Lines(report)); err != nil {
+		return nil, err
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&data, "%s: %s\r\n", key, value)
+	}
+	header("From", from)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(envelopeAddress(from)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	data.WriteString("\r\n")
+	data.Write(content.Bytes())
+	return data.Bytes(), nil
+}
+
+// base64Lines encodes data in base64 with lines of 76 characters.
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines bytes.Buffer
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\r\n")
+	return lines.Bytes()
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	random := make([]byte, 8)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
+
+// envelopeAddress extracts the bare address from "Name <address>".
+func envelopeAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return from
 }
 
-func generateEmailContent(templateStr string, data backup.Result) (string, error) {
+// sender formats the From header of a channel. A display name replaces any
+// name already in from.
+func sender(from, name string) string {
+	if name == "" {
+		return from
+	}
+	return (&mail.Address{Name: name, Address: envelopeAddress(from)}).String()
+}
+
+// renderHTML renders the email body of a message from the templates.
+func renderHTML(msg *Message) (string, error) {
+	if msg.Drill != nil {
+		return generateEmailContent(drillEmailTemplate, msg.Drill)
+	}
+	if msg.Digest {
+		failed, interrupted, _ := countResults(msg.Results)
+		return generateEmailContent(digestEmailTemplate, struct {
+			Results     any
+			Failed      int
+			Interrupted int
+		}{msg.Results, failed, interrupted})
+	}
+
+	result := msg.Results[0]
+	if result.ErrorMessage != "" {
+		return generateEmailContent(failureEmailTemplate, result)
+	}
+	return generateEmailContent(successEmailTemplate, result)
+}
+
+func generateEmailContent(templateStr string, data any) (string, error) {
 	tmpl, err := template.New("email").Parse(templateStr)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
@@ -61,35 +248,3 @@ func generateEmailContent(templateStr string, data backup.Result) (string, error
 
 	return body.String(), nil
 }
-
-func sendEmail(params EmailParams) error {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("SOURCE_REGION")),
-	}))
-	svc := ses.New(sess)
-
-	input := &ses.SendEmailInput{
-		Message: &ses.Message{
-			Body: &ses.Body{
-				Html: &ses.Content{
-					Charset: aws.String("UTF-8"),
-					Data:    aws.String(params.Body),
-				},
-			},
-			Subject: &ses.Content{
-				Charset: aws.String("UTF-8"),
-				Data:    aws.String(params.Subject),
-			},
-		},
-		Source: aws.String(fmt.Sprintf("%s <noreply@uniplank.com>", "Uniplank")),
-		Destination: &ses.Destination{
-			ToAddresses: aws.StringSlice(params.Emails),
-		},
-	}
-
-	_, err := svc.SendEmail(input)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-	return nil
-}
@@ -0,0 +1,279 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/drill"
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+)
+
+// Message is one notification. It covers a single result, every result of a
+// run in digest mode, or a restore drill. Event is config.EventSuccess or
+// config.EventFailure.
+type Message struct {
+	Event   string           `json:"event"`
+	Subject string           `json:"subject"`
+	Digest  bool             `json:"digest"`
+	Results []*backup.Result `json:"results"`
+	Drill   *drill.Result    `json:"drill,omitempty"`
+}
+
+// Notifier delivers messages to one channel.
+type Notifier interface {
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// New returns the notifier of a configured channel.
+func New(ctx context.Context, channel config.Channel) (Notifier, error) {
+	switch channel.Type {
+	case config.ChannelSES:
+		client, err := newSESClient(ctx, channel.Region, channel.Role)
+		if err != nil {
+			return nil, err
+		}
+		return NewSES(client, sender(channel.From, channel.FromName), channel.To), nil
+	case config.ChannelSMTP:
+		return NewSMTP(channel.SMTP, sender(channel.From, channel.FromName), channel.To), nil
+	case config.ChannelSlack:
+		return NewSlack(string(channel.URL)), nil
+	case config.ChannelWebhook:
+		return NewWebhook(string(channel.URL)), nil
+	case config.ChannelSNS:
+		client, err := newSNSClient(ctx, channel.Region, channel.Role)
+		if err != nil {
+			return nil, err
+		}
+		return NewSNS(client, channel.TopicARN), nil
+	default:
+		return nil, fmt.Errorf("unknown notification channel type %q", channel.Type)
+	}
+}
+
+// checker is implemented by notifiers that can check their credentials and
+// destination without sending anything.
+type checker interface {
+	Check(ctx context.Context) error
+}
+
+// Check checks every configured channel that can be checked without
+// sending a message: SES and SMTP credentials and SNS topics. Slack and
+// webhook channels are not checked.
+func Check(ctx context.Context, cfg *config.Config) []error {
+	var errs []error
+	for _, channel := range cfg.Channels {
+		notifier, err := New(ctx, channel)
+		if err == nil {
+			if c, ok := notifier.(checker); ok {
+				err = c.Check(ctx)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", channel.Name, err))
+		}
+	}
+	return errs
+}
+
+// Send notifies every configured channel about the results. In digest mode
+// one message covers all results, otherwise there is one message per
+// result. A channel only receives the messages whose event it subscribes to.
+// Delivery errors are logged and do not stop the other channels.
+func Send(ctx context.Context, cfg *config.Config, results []*backup.Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	var messages []*Message
+	if cfg.NotificationMode == config.NotifyDigest {
+		messages = append(messages, digestMessage(results))
+	} else {
+		for _, result := range results {
+			messages = append(messages, resultMessage(result))
+		}
+	}
+
+	deliver(ctx, cfg, messages)
+}
+
+// SendDrill notifies every configured channel about a restore drill. A
+// failed drill is a failure event.
+func SendDrill(ctx context.Context, cfg *config.Config, result *drill.Result) {
+	msg := &Message{Event: config.EventSuccess, Subject: "RDS Restore Drill Passed", Drill: result}
+	if !result.Passed {
+		msg.Event = config.EventFailure
+		msg.Subject = "RDS Restore Drill Failed"
+	}
+	deliver(ctx, cfg, []*Message{msg})
+}
+
+func deliver(ctx context.Context, cfg *config.Config, messages []*Message) {
+	for _, channel := range cfg.Channels {
+		var notifier Notifier
+		for _, msg := range messages {
+			if !channel.Wants(msg.Event) {
+				continue
+			}
+			if notifier == nil {
+				var err error
+				if notifier, err = New(ctx, channel); err != nil {
+					log.Printf("Failed to set up notification channel %s: %v", channel.Name, err)
+					metrics.NotificationFailures.WithLabelValues(channel.Name, channel.Type).Inc()
+					break
+				}
+			}
+			if err := notifier.Notify(ctx, msg); err != nil {
+				log.Printf("Failed to send %s notification to %s: %v", msg.Event, channel.Name, err)
+				metrics.NotificationFailures.WithLabelValues(channel.Name, channel.Type).Inc()
+			}
+		}
+	}
+}
+
+func resultMessage(result *backup.Result) *Message {
+	msg := &Message{Event: config.EventSuccess, Subject: "RDS Backup Successful", Results: []*backup.Result{result}}
+	if result.DryRun {
+		msg.Subject = "RDS Backup Dry Run Successful"
+	}
+
+	if result.ErrorMessage != "" {
+		msg.Event = config.EventFailure
+		switch {
+		case result.Interrupted:
+			msg.Subject = "RDS Backup Interrupted"
+		case result.DryRun:
+			msg.Subject = "RDS Backup Dry Run Failed"
+		default:
+			msg.Subject = "RDS Backup Failed"
+		}
+	}
+	return msg
+}
+
+func digestMessage(results []*backup.Result) *Message {
+	failed, interrupted, dryRun := countResults(results)
+
+	msg := &Message{Event: config.EventSuccess, Digest: true, Results: results}
+	msg.Subject = fmt.Sprintf("RDS Backup Report: %d succeeded", len(results)-failed-interrupted)
+	if failed > 0 {
+		msg.Subject = fmt.Sprintf("RDS Backup Report: %d of %d failed", failed, len(results))
+	}
+	if interrupted > 0 {
+		msg.Subject = fmt.Sprintf("RDS Backup Report: %d of %d interrupted, %d failed", interrupted, len(results), failed)
+	}
+	if dryRun {
+		msg.Subject = "[Dry Run] " + msg.Subject
+	}
+	if failed+interrupted > 0 {
+		msg.Event = config.EventFailure
+	}
+	return msg
+}
+
+func countResults(results []*backup.Result) (failed, interrupted int, dryRun bool) {
+	for _, result := range results {
+		if result.Interrupted {
+			interrupted++
+		} else if result.ErrorMessage != "" {
+			failed++
+		}
+		dryRun = dryRun || result.DryRun
+	}
+	return failed, interrupted, dryRun
+}
+
+// status describes the outcome of one result in a word.
+func status(result *backup.Result) string {
+	switch {
+	case result.Interrupted:
+		return "Interrupted"
+	case result.ErrorMessage != "":
+		return "Failed"
+	default:
+		return "Successful"
+	}
+}
+
+// renderText formats a message as plain text for chat and SNS channels.
+func renderText(msg *Message) string {
+	var b strings.Builder
+	b.WriteString(msg.Subject + "\n")
+	if msg.Drill != nil {
+		writeDrillText(&b, msg.Drill)
+		return b.String()
+	}
+	for _, result := range msg.Results {
+		fmt.Fprintf(&b, "\n%s: %s\n", result.DBIdentifier, status(result))
+		if result.SnapshotID != "" {
+			fmt.Fprintf(&b, "  Snapshot: %s\n", result.SnapshotID)
+		}
+		fmt.Fprintf(&b, "  Backup time: %s\n", result.BackupTime)
+		if result.ErrorMessage != "" {
+			fmt.Fprintf(&b, "  Error: %s\n", result.ErrorMessage)
+		} else if locations := result.S3Locations(); len(locations) > 0 {
+			fmt.Fprintf(&b, "  S3 location: %s\n", strings.Join(locations, ", "))
+		}
+		report := result.Report
+		if report.Snapshot != nil {
+			fmt.Fprintf(&b, "  Engine: %s %s, %d GiB\n", report.Snapshot.Engine, report.Snapshot.EngineVersion, report.Snapshot.SizeGB)
+		}
+		for _, export := range report.Exports {
+			if export.Verification != nil {
+				fmt.Fprintf(&b, "  Export %s: %s\n", export.TaskID, export.Verification.Summary())
+			}
+		}
+		for _, stage := range report.Stages {
+			fmt.Fprintf(&b, "  Stage %s: %s\n", stage.Stage, stage.Duration())
+		}
+		if len(report.Deleted) > 0 {
+			fmt.Fprintf(&b, "  Snapshots deleted: %d\n", len(report.Deleted))
+		}
+		for _, warning := range report.Warnings {
+			fmt.Fprintf(&b, "  Warning: %s\n", warning)
+		}
+		if result.DryRun {
+			fmt.Fprintf(&b, "  Dry run: %d call(s) planned\n", len(result.PlannedActions))
+			for _, action := range result.PlannedActions {
+				fmt.Fprintf(&b, "    %s %s %s (%s)\n", action.Service, action.Operation, action.Resource, action.Region)
+			}
+		}
+	}
+	return b.String()
+}
+
+func writeDrillText(b *strings.Builder, result *drill.Result) {
+	fmt.Fprintf(b, "\n%s: %s\n", result.DBIdentifier, drillStatus(result))
+	if restored := result.Restore; restored != nil {
+		fmt.Fprintf(b, "  Snapshot: %s (%s)\n", restored.SnapshotID, restored.Region)
+		fmt.Fprintf(b, "  Instance: %s\n", restored.InstanceID)
+		if restored.Finished != nil {
+			fmt.Fprintf(b, "  Restore time: %s\n", restored.Finished.Sub(restored.Started).Round(time.Second))
+		}
+	}
+	for _, check := range result.Checks {
+		if check.Passed {
+			fmt.Fprintf(b, "  Check %s: passed (%s)\n", check.Name, check.Value)
+		} else {
+			fmt.Fprintf(b, "  Check %s: FAILED: %s\n", check.Name, check.Error)
+		}
+	}
+	if result.ErrorMessage != "" {
+		fmt.Fprintf(b, "  Error: %s\n", result.ErrorMessage)
+	}
+	if result.TeardownError != "" {
+		fmt.Fprintf(b, "  Warning: the drill instance was not deleted: %s\n", result.TeardownError)
+	}
+	fmt.Fprintf(b, "  Duration: %s\n", result.Duration())
+}
+
+func drillStatus(result *drill.Result) string {
+	if result.Passed {
+		return "Passed"
+	}
+	return "Failed"
+}
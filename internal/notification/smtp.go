@@ -0,0 +1,159 @@
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// smtpTimeout bounds a whole SMTP conversation unless the context expires
+// sooner.
+const smtpTimeout = time.Minute
+
+// SMTPNotifier sends email through an SMTP server.
+type SMTPNotifier struct {
+	server config.SMTP
+	from   string
+	to     []string
+
+	// TLSConfig is used for STARTTLS and implicit TLS. It defaults to
+	// verifying the server certificate against the system roots; tests set
+	// it to trust a local stand-in.
+	TLSConfig *tls.Config
+}
+
+// NewSMTP returns a notifier for server. from may include a display name,
+// as in "Backups <backups@example.com>".
+func NewSMTP(server config.SMTP, from string, to []string) *SMTPNotifier {
+	return &SMTPNotifier{server: server, from: from, to: to}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg *Message) error {
+	envelope := envelopeAddress(n.from)
+	data, err := composeEmail(n.from, n.to, msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(n.server.Host, strconv.Itoa(n.server.Port))
+	if err := n.send(ctx, addr, envelope, data); err != nil {
+		return fmt.Errorf("failed to send email via %s: %w", addr, err)
+	}
+	return nil
+}
+
+func (n *SMTPNotifier) send(ctx context.Context, addr, envelope string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	client, err := n.connect(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(envelope); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(envelopeAddress(to)); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Check connects to the server and authenticates without sending anything.
+func (n *SMTPNotifier) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(n.server.Host, strconv.Itoa(n.server.Port))
+	client, err := n.connect(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+// connect dials the server, secures the connection and authenticates. The
+// connection is closed when ctx is done.
+func (n *SMTPNotifier) connect(ctx context.Context, addr string) (*smtp.Client, error) {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if n.server.TLS == config.SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: n.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The SMTP client has no context support, so the deadline bounds every
+	// read and write and cancellation closes the connection.
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	client, err := smtp.NewClient(conn, n.server.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if n.server.TLS == config.SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(n.tlsConfig()); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if n.server.Username != "" {
+		// PlainAuth only refuses cleartext to remote hosts; credentials
+		// are never sent without TLS, not even to localhost
+		if n.server.TLS == config.SMTPNone {
+			client.Close()
+			return nil, errors.New("refusing to authenticate over a connection without TLS")
+		}
+		auth := smtp.PlainAuth("", n.server.Username, string(n.server.Password), n.server.Host)
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	return client, nil
+}
+
+func (n *SMTPNotifier) tlsConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if n.TLSConfig != nil {
+		cfg = n.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = n.server.Host
+	}
+	return cfg
+}
@@ -0,0 +1,342 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// selfSigned returns a certificate for 127.0.0.1 and a pool that trusts it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smtp.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// smtpServer is a local SMTP server that accepts every message. It offers
+// STARTTLS, or speaks TLS from the start, depending on the mode it is
+// started in, and records what the client sent.
+type smtpServer struct {
+	mode     string
+	tls      *tls.Config
+	listener net.Listener
+
+	mu       sync.Mutex
+	auth     []string
+	from     string
+	to       []string
+	data     string
+	startTLS bool
+}
+
+func startSMTPServer(t *testing.T, mode string, cert tls.Certificate) *smtpServer {
+	t.Helper()
+	s := &smtpServer{mode: mode, tls: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	var err error
+	if mode == config.SMTPTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tls)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.listener.Close() })
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for i, line := range lines {
+			sep := " "
+			if i < len(lines)-1 {
+				sep = "-"
+			}
+			io.WriteString(conn, line[:3]+sep+line[4:]+"\r\n")
+		}
+	}
+
+	_, secure := conn.(*tls.Conn)
+	reply("220 smtp.test ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"250 smtp.test"}
+			if s.mode == config.SMTPStartTLS && !secure {
+				lines = append(lines, "250 STARTTLS")
+			}
+			reply(append(lines, "250 AUTH PLAIN")...)
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+			s.mu.Lock()
+			s.startTLS = true
+			s.mu.Unlock()
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = append(s.auth, arg)
+			s.mu.Unlock()
+			reply("235 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.to = append(s.to, arg)
+			s.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	cert, roots := selfSigned(t)
+
+	tests := []struct {
+		name     string
+		tls      string
+		username string
+		wantErr  string
+	}{
+		{name: "starttls", tls: config.SMTPStartTLS, username: "backups"},
+		{name: "implicit tls", tls: config.SMTPTLS, username: "backups"},
+		{name: "no tls", tls: config.SMTPNone},
+		{name: "no tls with credentials", tls: config.SMTPNone, username: "backups",
+			wantErr: "refusing to authenticate over a connection without TLS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startSMTPServer(t, tt.tls, cert)
+			n := NewSMTP(config.SMTP{
+				Host:     "127.0.0.1",
+				Port:     server.port(),
+				Username: tt.username,
+				Password: "secret",
+				TLS:      tt.tls,
+			}, "Backups <backups@example.com>", []string{"dba@example.com", "ops@example.com"})
+			n.TLSConfig = &tls.Config{RootCAs: roots}
+
+			msg := &Message{
+				Event:   config.EventSuccess,
+				Subject: "Backup of orders succeeded",
+				Results: []*backup.Result{{DBIdentifier: "orders", SnapshotID: "backup-orders-2025-01-10-12-00-00"}},
+			}
+			err := n.Notify(context.Background(), msg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				server.mu.Lock()
+				defer server.mu.Unlock()
+				if len(server.auth) != 0 || server.data != "" {
+					t.Errorf("credentials or message sent: auth %q", server.auth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.startTLS != (tt.tls == config.SMTPStartTLS) {
+				t.Errorf("STARTTLS used: %v", server.startTLS)
+			}
+			if tt.username != "" {
+				want := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00backups\x00secret"))
+				if len(server.auth) != 1 || server.auth[0] != want {
+					t.Errorf("auth %q, want %q", server.auth, want)
+				}
+			} else if len(server.auth) != 0 {
+				t.Errorf("authenticated without credentials: %q", server.auth)
+			}
+			if server.from != "FROM:<backups@example.com>" {
+				t.Errorf("envelope sender %q", server.from)
+			}
+			if want := []string{"TO:<dba@example.com>", "TO:<ops@example.com>"}; !slices.Equal(server.to, want) {
+				t.Errorf("envelope recipients %q, want %q", server.to, want)
+			}
+			checkEmail(t, server.data, msg.Subject)
+		})
+	}
+}
+
+// checkEmail checks that data is a multipart/mixed email with the plain
+// text and HTML bodies as alternatives and the report attached.
+func checkEmail(t *testing.T, data, subject string) {
+	t.Helper()
+	email, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse email: %v", err)
+	}
+	if got, err := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject")); err != nil || got != subject {
+		t.Errorf("subject %q, want %q", got, subject)
+	}
+	if got := email.Header.Get("From"); got != `Backups <backups@example.com>` {
+		t.Errorf("From %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", mediaType, err)
+	}
+	parts := multipart.NewReader(email.Body, params["boundary"])
+
+	alternative, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err = mime.ParseMediaType(alternative.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("first part is %q: %v", mediaType, err)
+	}
+	bodies := multipart.NewReader(alternative, params["boundary"])
+	for _, want := range []string{"text/plain", "text/html"} {
+		body, err := bodies.NextPart()
+		if err != nil {
+			t.Fatalf("missing %s body: %v", want, err)
+		}
+		if mediaType, _, _ := mime.ParseMediaType(body.Header.Get("Content-Type")); mediaType != want {
+			t.Errorf("body is %q, want %q", mediaType, want)
+		}
+		content, err := io.ReadAll(quotedprintable.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "backup-orders-2025-01-10-12-00-00") {
+			t.Errorf("%s body does not name the snapshot:\n%s", want, content)
+		}
+	}
+	if _, err := bodies.NextPart(); err != io.EOF {
+		t.Errorf("unexpected third alternative: %v", err)
+	}
+
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatalf("missing attachment: %v", err)
+	}
+	if got := attachment.FileName(); got != reportAttachment {
+		t.Errorf("attachment %q, want %q", got, reportAttachment)
+	}
+	report, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), `"dbIdentifier": "orders"`) {
+		t.Errorf("report %s", report)
+	}
+}
+
+func TestSMTPNotifierCheck(t *testing.T) {
+	cert, roots := selfSigned(t)
+	server := startSMTPServer(t, config.SMTPStartTLS, cert)
+
+	n := NewSMTP(config.SMTP{Host: "127.0.0.1", Port: server.port(), TLS: config.SMTPStartTLS}, "backups@example.com", nil)
+	if err := n.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Check with an untrusted certificate: %v", err)
+	}
+
+	n.TLSConfig = &tls.Config{RootCAs: roots}
+	if err := n.Check(context.Background()); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+
+	plain := startSMTPServer(t, config.SMTPNone, cert)
+	n = NewSMTP(config.SMTP{Host: "127.0.0.1", Port: plain.port(), TLS: config.SMTPStartTLS}, "backups@example.com", nil)
+	if err := n.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+		t.Errorf("Check of a server without STARTTLS: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// SNS subjects are limited to 100 characters.
+const maxSNSSubject = 100
+
+// newSNSClient is replaced in tests to capture messages instead of
+// publishing them.
+var newSNSClient = func(ctx context.Context, region string, role config.Role) (awsinternal.SNSAPI, error) {
+	cfg, err := awsinternal.LoadConfig(ctx, region, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SNS config: %w", err)
+	}
+	return sns.NewFromConfig(cfg), nil
+}
+
+// SNSNotifier publishes a text summary to an SNS topic.
+type SNSNotifier struct {
+	client   awsinternal.SNSAPI
+	topicARN string
+}
+
+func NewSNS(client awsinternal.SNSAPI, topicARN string) *SNSNotifier {
+	return &SNSNotifier{client: client, topicARN: topicARN}
+}
+
+func (n *SNSNotifier) Notify(ctx context.Context, msg *Message) error {
+	subject := msg.Subject
+	if len(subject) > maxSNSSubject {
+		subject = subject[:maxSNSSubject]
+	}
+
+	_, err := n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(renderText(msg)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", n.topicARN, err)
+	}
+	return nil
+}
+
+// Check verifies that the topic exists and can be read.
+func (n *SNSNotifier) Check(ctx context.Context) error {
+	_, err := n.client.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: aws.String(n.topicARN)})
+	if err != nil {
+		return fmt.Errorf("failed to check topic %s: %w", n.topicARN, err)
+	}
+	return nil
+}
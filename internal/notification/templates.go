@@ -1,6 +1,58 @@
 package notification
 
 const (
+	reportSection = `
+    {{with .Report}}
+    {{with .Snapshot}}
+    <p><strong>Snapshot:</strong> {{.Engine}} {{.EngineVersion}}, {{.SizeGB}} GiB</p>
+    {{end}}
+    {{if .Stages}}
+    <h3>Stages</h3>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Stage</th><th align="left">Region</th><th align="left">Duration</th></tr>
+        {{range .Stages}}
+        <tr><td>{{.Stage}}</td><td>{{.Region}}</td><td>{{.Duration}}{{if not .Finished}} (not finished){{end}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .Exports}}
+    <h3>Exports</h3>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Export task</th><th align="left">Region</th><th align="left">Location</th><th align="left">Verified</th></tr>
+        {{range .Exports}}
+        <tr><td>{{.TaskID}}</td><td>{{.Region}}</td><td>{{.Location}}</td>{{with .Verification}}{{if .Complete}}<td>{{.Summary}}</td>{{else}}<td style="color: #ff8c00;">{{.Summary}}</td>{{end}}{{else}}<td>not verified</td>{{end}}</tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .Deleted}}
+    <h3>Deleted snapshots</h3>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Snapshot</th><th align="left">Region</th><th align="left">Reason</th></tr>
+        {{range .Deleted}}
+        <tr><td>{{.SnapshotID}}</td><td>{{.Region}}</td><td>{{.Reason}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .Warnings}}
+    <h3 style="color: #ff8c00;">Warnings</h3>
+    <ul>
+        {{range .Warnings}}<li>{{.}}</li>{{end}}
+    </ul>
+    {{end}}
+    {{end}}`
+
+	dryRunSection = `
+    {{if .DryRun}}
+    <h3>Dry run: planned changes</h3>
+    <p>Nothing was changed. A real run would make the following calls:</p>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Call</th><th align="left">Region</th><th align="left">Resource</th><th align="left">Details</th></tr>
+        {{range .PlannedActions}}
+        <tr><td>{{.Service}} {{.Operation}}</td><td>{{.Region}}</td><td>{{.Resource}}</td><td>{{.Detail}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}`
+
 	successEmailTemplate = `
 <!DOCTYPE html>
 <html>
@@ -11,8 +63,8 @@ const (
         <li><strong>Database:</strong> {{.DBIdentifier}}</li>
         <li><strong>Snapshot ID:</strong> {{.SnapshotID}}</li>
         <li><strong>Backup Time:</strong> {{.BackupTime}}</li>
-        <li><strong>S3 Location:</strong> {{.S3Location}}</li>
-    </ul>
+        {{range .S3Locations}}<li><strong>S3 Location:</strong> {{.}}</li>{{end}}
+    </ul>` + reportSection + dryRunSection + `
     <p>This is an automated message. Please do not reply.</p>
 </body>
 </html>`
@@ -21,16 +73,82 @@ const (
 <!DOCTYPE html>
 <html>
 <body style="font-family: Arial, sans-serif;">
+    {{if .Interrupted}}
+    <h2 style="color: #ff8c00;">RDS Backup Interrupted</h2>
+    <p>The RDS backup was cut short because the backup service shut down. Snapshots, copies and exports that were already started keep running in AWS; the backup resumes from its last completed step when the service starts again with a state store configured.</p>
+    {{else}}
     <h2 style="color: #ff0000;">RDS Backup Failed</h2>
     <p>The RDS backup operation has encountered an error.</p>
+    {{end}}
     <ul>
         <li><strong>Database:</strong> {{.DBIdentifier}}</li>
         <li><strong>Attempted Snapshot ID:</strong> {{.SnapshotID}}</li>
         <li><strong>Error Time:</strong> {{.BackupTime}}</li>
         <li><strong>Error Message:</strong> {{.ErrorMessage}}</li>
-    </ul>
+    </ul>` + reportSection + dryRunSection + `
     <p>Please check the AWS console and logs for more details.</p>
     <p>This is an automated message. Please do not reply.</p>
 </body>
 </html>`
-)
\ No newline at end of file
+
+	digestEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif;">
+    <h2{{if .Failed}} style="color: #ff0000;"{{end}}>RDS Backup Report</h2>
+    <p>{{len .Results}} database(s) backed up, {{.Failed}} failed{{if .Interrupted}}, {{.Interrupted}} interrupted by shutdown{{end}}.</p>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Database</th><th align="left">Status</th><th align="left">Snapshot ID</th><th align="left">Details</th></tr>
+        {{range .Results}}
+        <tr>
+            <td>{{.DBIdentifier}}</td>
+            {{if .Interrupted}}<td style="color: #ff8c00;">Interrupted</td>{{else if .ErrorMessage}}<td style="color: #ff0000;">Failed</td>{{else}}<td>Successful</td>{{end}}
+            <td>{{.SnapshotID}}</td>
+            <td>{{if .ErrorMessage}}{{.ErrorMessage}}{{else}}{{range $i, $location := .S3Locations}}{{if $i}}<br>{{end}}{{$location}}{{end}}{{end}}{{range .Report.Warnings}}<br><span style="color: #ff8c00;">Warning: {{.}}</span>{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{range .Results}}{{if .DryRun}}
+    <h3>{{.DBIdentifier}}</h3>` + dryRunSection + `
+    {{end}}{{end}}
+    <p>This is an automated message. Please do not reply.</p>
+</body>
+</html>`
+
+	drillEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif;">
+    {{if .Passed}}
+    <h2>RDS Restore Drill Passed</h2>
+    <p>The latest backup of {{.DBIdentifier}} was restored and passed its checks.</p>
+    {{else}}
+    <h2 style="color: #ff0000;">RDS Restore Drill Failed</h2>
+    <p>The latest backup of {{.DBIdentifier}} could not be verified.</p>
+    {{end}}
+    <ul>
+        <li><strong>Database:</strong> {{.DBIdentifier}}</li>
+        {{with .Restore}}
+        <li><strong>Snapshot ID:</strong> {{.SnapshotID}} ({{.Region}})</li>
+        <li><strong>Drill Instance:</strong> {{.InstanceID}}</li>
+        {{end}}
+        <li><strong>Duration:</strong> {{.Duration}}</li>
+        {{if .ErrorMessage}}<li><strong>Error Message:</strong> {{.ErrorMessage}}</li>{{end}}
+    </ul>
+    {{if .Checks}}
+    <h3>Checks</h3>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr><th align="left">Check</th><th align="left">Result</th><th align="left">Value</th></tr>
+        {{range .Checks}}
+        <tr><td>{{.Name}}</td>{{if .Passed}}<td>Passed</td>{{else}}<td style="color: #ff0000;">Failed: {{.Error}}</td>{{end}}<td>{{.Value}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+    {{if .TeardownError}}
+    <h3 style="color: #ff8c00;">Warnings</h3>
+    <p>The drill instance was not deleted and must be removed by hand: {{.TeardownError}}</p>
+    {{end}}
+    <p>This is an automated message. Please do not reply.</p>
+</body>
+</html>`
+)
@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// SlackNotifier posts a text summary to a Slack incoming webhook.
+type SlackNotifier struct {
+	url string
+}
+
+func NewSlack(url string) *SlackNotifier {
+	return &SlackNotifier{url: url}
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, msg *Message) error {
+	return postJSON(ctx, n.url, map[string]string{"text": renderText(msg)})
+}
+
+// WebhookNotifier posts the message, including the full results, as JSON.
+type WebhookNotifier struct {
+	url string
+}
+
+func NewWebhook(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, msg *Message) error {
+	return postJSON(ctx, n.url, msg)
+}
+
+// withoutURL strips the URL from the *url.Error of a request, which would
+// put the token of a Slack or webhook URL in the logs.
+func withoutURL(err error) error {
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+func postJSON(ctx context.Context, url string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
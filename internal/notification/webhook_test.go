@@ -0,0 +1,44 @@
+package notification
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+func TestWebhookErrorsOmitTheURL(t *testing.T) {
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{name: "unreachable", url: "http://" + addr + "/services/T000/B000/s3cr3t-token", wantErr: "failed to post notification: Post: "},
+		{name: "invalid", url: "http://exa mple.com/services/T000/B000/s3cr3t-token", wantErr: "invalid webhook URL: parse: "},
+	}
+
+	msg := &Message{Event: config.EventFailure, Subject: "Backup of orders failed"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, n := range []Notifier{NewSlack(tt.url), NewWebhook(tt.url)} {
+				err := n.Notify(context.Background(), msg)
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "s3cr3t-token") || strings.Contains(err.Error(), "/services/") {
+					t.Errorf("error %q contains the URL", err)
+				}
+			}
+		})
+	}
+}
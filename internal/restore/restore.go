@@ -0,0 +1,324 @@
+// Package restore provisions a new DB instance, or Aurora DB cluster, from a
+// backup snapshot.
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+const (
+	RegionSource = "source"
+	RegionTarget = "target"
+
+	// DefaultMaxWait bounds the wait for the restored database to become
+	// available. Restores of large snapshots can take hours.
+	DefaultMaxWait = 3 * time.Hour
+)
+
+// Waiter delays, replaced in tests.
+var (
+	waiterMinDelay = 30 * time.Second
+	waiterMaxDelay = 2 * time.Minute
+)
+
+type Options struct {
+	// Region is RegionSource to restore a backup-* snapshot in the source
+	// region or RegionTarget to restore a copy-backup-* snapshot in the
+	// target region.
+	Region string
+	// SnapshotID selects the snapshot to restore. Without it, the newest
+	// available snapshot taken on Date (UTC) is restored or, if Date is zero
+	// too, the newest available snapshot.
+	SnapshotID string
+	Date       time.Time
+
+	// InstanceID names the new DB instance, or the new DB cluster whose
+	// writer instance is then named InstanceID-instance-1.
+	InstanceID string
+	// InstanceClass defaults to the snapshot's for DB instances and is
+	// required for DB clusters.
+	InstanceClass  string
+	SubnetGroup    string
+	SecurityGroups []string
+	// ParameterGroup is a DB parameter group, or a DB cluster parameter
+	// group for DB clusters.
+	ParameterGroup string
+
+	// NoWait returns as soon as the restore has started.
+	NoWait  bool
+	MaxWait time.Duration
+}
+
+// Result describes a restored database.
+type Result struct {
+	Database   string `json:"database"`
+	SnapshotID string `json:"snapshotId"`
+	// SnapshotTime is when the restored snapshot was taken.
+	SnapshotTime time.Time  `json:"snapshotTime"`
+	Engine       string     `json:"engine"`
+	Region       string     `json:"region"`
+	InstanceID   string     `json:"instanceId"`
+	ClusterID    string     `json:"clusterId,omitempty"`
+	Status       string     `json:"status"`
+	Endpoint     string     `json:"endpoint,omitempty"`
+	Port         int32      `json:"port,omitempty"`
+	Started      time.Time  `json:"started"`
+	Finished     *time.Time `json:"finished,omitempty"`
+}
+
+// Address returns the endpoint as host:port.
+func (r *Result) Address() string {
+	if r.Endpoint == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", r.Endpoint, r.Port)
+}
+
+// Restore restores a backup snapshot of db to a new DB instance or cluster
+// and, unless opts.NoWait, waits for it to become available.
+func Restore(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, opts Options) (*Result, error) {
+	if opts.InstanceID == "" {
+		return nil, errors.New("an identifier for the new instance is required")
+	}
+	cluster := db.Mode == config.ModeCluster
+	if cluster && opts.InstanceClass == "" {
+		return nil, errors.New("an instance class is required to restore a DB cluster")
+	}
+
+	rdsClient, region := clients.TargetRDS, clients.TargetRegion
+	switch opts.Region {
+	case RegionTarget:
+	case RegionSource:
+		rdsClient, region = clients.SourceRDS, clients.SourceRegion
+	default:
+		return nil, fmt.Errorf("invalid region %q (expected %s or %s)", opts.Region, RegionSource, RegionTarget)
+	}
+
+	snapshot, err := selectSnapshot(ctx, clients, db, region, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Database:     db.DBIdentifier,
+		SnapshotID:   snapshot.ID,
+		SnapshotTime: snapshot.Created,
+		Engine:       snapshot.Engine,
+		Region:       region,
+		InstanceID:   opts.InstanceID,
+		Started:      time.Now(),
+	}
+	if cluster {
+		err = restoreCluster(ctx, rdsClient, snapshot, opts, result)
+	} else {
+		err = restoreInstance(ctx, rdsClient, snapshot, opts, result)
+	}
+	if err != nil {
+		return result, err
+	}
+	if opts.NoWait {
+		return result, nil
+	}
+
+	if err := waitAvailable(ctx, rdsClient, opts, result); err != nil {
+		return result, err
+	}
+	finished := time.Now()
+	result.Finished = &finished
+	log.Printf("Restored %s to %s in %s, available at %s",
+		result.SnapshotID, opts.InstanceID, finished.Sub(result.Started).Round(time.Second), result.Address())
+	return result, nil
+}
+
+// selectSnapshot picks the available backup snapshot of db in region
+// selected by opts.
+func selectSnapshot(ctx context.Context, clients *awsinternal.AWSClients, db *config.Database, region string, opts Options) (*backup.SnapshotInfo, error) {
+	snapshots, err := backup.ListSnapshots(ctx, clients, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// ListSnapshots returns the newest first, so the first match is the one.
+	for _, snapshot := range snapshots {
+		if snapshot.Region != region {
+			continue
+		}
+		switch {
+		case opts.SnapshotID != "":
+			if snapshot.ID != opts.SnapshotID {
+				continue
+			}
+			if snapshot.Status != "available" {
+				return nil, fmt.Errorf("snapshot %s is %s, not available", snapshot.ID, snapshot.Status)
+			}
+		case snapshot.Status != "available":
+			continue
+		case !opts.Date.IsZero():
+			if snapshot.Created.UTC().Format(time.DateOnly) != opts.Date.Format(time.DateOnly) {
+				continue
+			}
+		}
+		return &snapshot, nil
+	}
+
+	switch {
+	case opts.SnapshotID != "":
+		return nil, fmt.Errorf("no backup snapshot %s of %s in %s", opts.SnapshotID, db.DBIdentifier, region)
+	case !opts.Date.IsZero():
+		return nil, fmt.Errorf("no available backup snapshot of %s taken on %s in %s", db.DBIdentifier, opts.Date.Format(time.DateOnly), region)
+	default:
+		return nil, fmt.Errorf("no available backup snapshot of %s in %s", db.DBIdentifier, region)
+	}
+}
+
+func restoreInstance(ctx context.Context, rdsClient awsinternal.RDSAPI, snapshot *backup.SnapshotInfo, opts Options, result *Result) error {
+	input := &rds.RestoreDBInstanceFromDBSnapshotInput{
+		DBSnapshotIdentifier: aws.String(snapshot.ID),
+		DBInstanceIdentifier: aws.String(opts.InstanceID),
+		VpcSecurityGroupIds:  opts.SecurityGroups,
+	}
+	if opts.InstanceClass != "" {
+		input.DBInstanceClass = aws.String(opts.InstanceClass)
+	}
+	if opts.SubnetGroup != "" {
+		input.DBSubnetGroupName = aws.String(opts.SubnetGroup)
+	}
+	if opts.ParameterGroup != "" {
+		input.DBParameterGroupName = aws.String(opts.ParameterGroup)
+	}
+
+	log.Printf("Restoring snapshot %s to new instance %s in %s", snapshot.ID, opts.InstanceID, result.Region)
+	output, err := rdsClient.RestoreDBInstanceFromDBSnapshot(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot %s: %w", snapshot.ID, err)
+	}
+	result.Status = aws.ToString(output.DBInstance.DBInstanceStatus)
+	return nil
+}
+
+// restoreCluster restores the cluster snapshot and adds a writer instance,
+// without which the cluster cannot be connected to. If the instance cannot
+// be created, the cluster is deleted again.
+func restoreCluster(ctx context.Context, rdsClient awsinternal.RDSAPI, snapshot *backup.SnapshotInfo, opts Options, result *Result) error {
+	input := &rds.RestoreDBClusterFromSnapshotInput{
+		SnapshotIdentifier:  aws.String(snapshot.ID),
+		DBClusterIdentifier: aws.String(opts.InstanceID),
+		Engine:              aws.String(snapshot.Engine),
+		VpcSecurityGroupIds: opts.SecurityGroups,
+	}
+	if opts.SubnetGroup != "" {
+		input.DBSubnetGroupName = aws.String(opts.SubnetGroup)
+	}
+	if opts.ParameterGroup != "" {
+		input.DBClusterParameterGroupName = aws.String(opts.ParameterGroup)
+	}
+
+	log.Printf("Restoring cluster snapshot %s to new cluster %s in %s", snapshot.ID, opts.InstanceID, result.Region)
+	if _, err := rdsClient.RestoreDBClusterFromSnapshot(ctx, input); err != nil {
+		return fmt.Errorf("failed to restore cluster snapshot %s: %w", snapshot.ID, err)
+	}
+	result.ClusterID = opts.InstanceID
+	result.InstanceID = opts.InstanceID + "-instance-1"
+
+	log.Printf("Creating writer instance %s in cluster %s", result.InstanceID, result.ClusterID)
+	output, err := rdsClient.CreateDBInstance(ctx, &rds.CreateDBInstanceInput{
+		DBInstanceIdentifier: aws.String(result.InstanceID),
+		DBClusterIdentifier:  aws.String(result.ClusterID),
+		DBInstanceClass:      aws.String(opts.InstanceClass),
+		Engine:               aws.String(snapshot.Engine),
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to create instance %s in cluster %s: %w", result.InstanceID, result.ClusterID, err)
+		if cleanupErr := deleteCluster(ctx, rdsClient, result.ClusterID, opts); cleanupErr != nil {
+			return fmt.Errorf("%w; cluster %s was left behind and must be deleted by hand: %v", err, result.ClusterID, cleanupErr)
+		}
+		result.ClusterID = ""
+		return err
+	}
+	result.Status = aws.ToString(output.DBInstance.DBInstanceStatus)
+	return nil
+}
+
+// deleteCluster deletes a restored cluster without a final snapshot. A
+// cluster cannot be deleted while it is being created, so it is waited for
+// first. The cluster is deleted even if ctx is cancelled.
+func deleteCluster(ctx context.Context, rdsClient awsinternal.RDSAPI, clusterID string, opts Options) error {
+	maxWait := opts.MaxWait
+	if maxWait == 0 {
+		maxWait = DefaultMaxWait
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), maxWait)
+	defer cancel()
+
+	log.Printf("Deleting cluster %s", clusterID)
+	waiter := rds.NewDBClusterAvailableWaiter(rdsClient, func(o *rds.DBClusterAvailableWaiterOptions) {
+		o.MinDelay = waiterMinDelay
+		o.MaxDelay = waiterMaxDelay
+	})
+	err := waiter.Wait(ctx, &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(clusterID),
+	}, maxWait)
+	if err != nil {
+		return fmt.Errorf("cluster did not become available: %w", err)
+	}
+	_, err = rdsClient.DeleteDBCluster(ctx, &rds.DeleteDBClusterInput{
+		DBClusterIdentifier: aws.String(clusterID),
+		SkipFinalSnapshot:   aws.Bool(true),
+	})
+	return err
+}
+
+// waitAvailable waits for the restored instance, and its cluster, to become
+// available and records the endpoint to connect to.
+func waitAvailable(ctx context.Context, rdsClient awsinternal.RDSAPI, opts Options, result *Result) error {
+	maxWait := opts.MaxWait
+	if maxWait == 0 {
+		maxWait = DefaultMaxWait
+	}
+	deadline := time.Now().Add(maxWait)
+
+	log.Printf("Waiting up to %s for %s to become available", maxWait, result.InstanceID)
+	if result.ClusterID != "" {
+		waiter := rds.NewDBClusterAvailableWaiter(rdsClient, func(o *rds.DBClusterAvailableWaiterOptions) {
+			o.MinDelay = waiterMinDelay
+			o.MaxDelay = waiterMaxDelay
+		})
+		output, err := waiter.WaitForOutput(ctx, &rds.DescribeDBClustersInput{
+			DBClusterIdentifier: aws.String(result.ClusterID),
+		}, maxWait)
+		if err != nil {
+			return fmt.Errorf("cluster %s did not become available: %w", result.ClusterID, err)
+		}
+		cluster := output.DBClusters[0]
+		result.Endpoint = aws.ToString(cluster.Endpoint)
+		result.Port = aws.ToInt32(cluster.Port)
+	}
+
+	waiter := rds.NewDBInstanceAvailableWaiter(rdsClient, func(o *rds.DBInstanceAvailableWaiterOptions) {
+		o.MinDelay = waiterMinDelay
+		o.MaxDelay = waiterMaxDelay
+	})
+	output, err := waiter.WaitForOutput(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(result.InstanceID),
+	}, time.Until(deadline))
+	if err != nil {
+		return fmt.Errorf("instance %s did not become available: %w", result.InstanceID, err)
+	}
+	instance := output.DBInstances[0]
+	result.Status = aws.ToString(instance.DBInstanceStatus)
+	if result.ClusterID == "" && instance.Endpoint != nil {
+		result.Endpoint = aws.ToString(instance.Endpoint.Address)
+		result.Port = aws.ToInt32(instance.Endpoint.Port)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/aws/fake"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+func TestRestore(t *testing.T) {
+	minDelay, maxDelay := waiterMinDelay, waiterMaxDelay
+	waiterMinDelay, waiterMaxDelay = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { waiterMinDelay, waiterMaxDelay = minDelay, maxDelay })
+
+	older := time.Date(2025, 1, 9, 12, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		mode     string
+		setup    func(rds *fake.RDS)
+		opts     Options
+		wantErr  string
+		snapshot string
+		instance string
+		cluster  string
+		left     []string
+	}{
+		{
+			name: "newest instance snapshot",
+			mode: config.ModeInstance,
+			setup: func(rds *fake.RDS) {
+				rds.AddSnapshot("copy-backup-orders-2025-01-09-12-00-00", "orders", "available", older)
+				rds.AddSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", newer)
+			},
+			snapshot: "copy-backup-orders-2025-01-10-12-00-00",
+			instance: "orders-restored",
+		},
+		{
+			name: "instance snapshot of a date",
+			mode: config.ModeInstance,
+			setup: func(rds *fake.RDS) {
+				rds.AddSnapshot("copy-backup-orders-2025-01-09-12-00-00", "orders", "available", older)
+				rds.AddSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", newer)
+			},
+			opts:     Options{Date: older},
+			snapshot: "copy-backup-orders-2025-01-09-12-00-00",
+			instance: "orders-restored",
+		},
+		{
+			name: "snapshot that is not available",
+			mode: config.ModeInstance,
+			setup: func(rds *fake.RDS) {
+				rds.AddSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "failed", newer)
+			},
+			opts:    Options{SnapshotID: "copy-backup-orders-2025-01-10-12-00-00"},
+			wantErr: "snapshot copy-backup-orders-2025-01-10-12-00-00 is failed, not available",
+		},
+		{
+			name: "cluster",
+			mode: config.ModeCluster,
+			setup: func(rds *fake.RDS) {
+				rds.AddClusterSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", newer)
+			},
+			snapshot: "copy-backup-orders-2025-01-10-12-00-00",
+			instance: "orders-restored-instance-1",
+			cluster:  "orders-restored",
+		},
+		{
+			name: "cluster is deleted if its instance cannot be created",
+			mode: config.ModeCluster,
+			setup: func(rds *fake.RDS) {
+				rds.AddClusterSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", newer)
+				rds.FailNext("CreateDBInstance", errors.New("instance quota exceeded"))
+			},
+			wantErr: "failed to create instance orders-restored-instance-1 in cluster orders-restored: instance quota exceeded",
+		},
+		{
+			name: "cluster that cannot be deleted is reported",
+			mode: config.ModeCluster,
+			setup: func(rds *fake.RDS) {
+				rds.AddClusterSnapshot("copy-backup-orders-2025-01-10-12-00-00", "orders", "available", newer)
+				rds.FailNext("CreateDBInstance", errors.New("instance quota exceeded"))
+				rds.FailNext("DeleteDBCluster", errors.New("throttled"))
+			},
+			wantErr: "cluster orders-restored was left behind and must be deleted by hand: throttled",
+			cluster: "orders-restored",
+			left:    []string{"orders-restored"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := fake.New("us-east-1", "us-west-2")
+			tt.setup(cloud.TargetRDS)
+			db := &config.Database{DBIdentifier: "orders", Mode: tt.mode}
+			opts := tt.opts
+			opts.Region = RegionTarget
+			opts.InstanceID = "orders-restored"
+			opts.InstanceClass = "db.r6g.large"
+
+			result, err := Restore(context.Background(), cloud.Clients(), db, opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				if got := cloud.TargetRDS.Clusters(); !slices.Equal(got, tt.left) {
+					t.Errorf("clusters %q left, want %q", got, tt.left)
+				}
+				if result != nil && result.ClusterID != tt.cluster {
+					t.Errorf("cluster %q, want %q", result.ClusterID, tt.cluster)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+
+			if result.ClusterID != tt.cluster {
+				t.Errorf("cluster %q, want %q", result.ClusterID, tt.cluster)
+			}
+			if result.SnapshotID != tt.snapshot || result.InstanceID != tt.instance {
+				t.Errorf("restored %s to %s, want %s to %s", result.SnapshotID, result.InstanceID, tt.snapshot, tt.instance)
+			}
+			if result.Status != "available" || result.Address() == "" || result.Finished == nil {
+				t.Errorf("result %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a grandfather-father-son retention policy. A snapshot is kept
+// if any rule selects it:
+//   - KeepLast keeps the newest N snapshots.
+//   - KeepWithin keeps every snapshot younger than the duration.
+//   - Daily, Weekly, Monthly and Yearly keep the newest snapshot of each of
+//     the last N days, ISO weeks, months and years that have a snapshot.
+//
+// Periods are evaluated in UTC.
+type Policy struct {
+	KeepLast   int
+	KeepWithin time.Duration
+	Daily      int
+	Weekly     int
+	Monthly    int
+	Yearly     int
+}
+
+// DefaultPolicy keeps every snapshot from the last 45 days.
+var DefaultPolicy = Policy{KeepWithin: 45 * 24 * time.Hour}
+
+type Snapshot struct {
+	ID      string
+	Created time.Time
+}
+
+type Decision struct {
+	Snapshot Snapshot
+	Keep     bool
+	Reason   string
+}
+
+// Parse reads a policy written as comma separated key=value pairs, for
+// example "daily=7,weekly=4,monthly=12,yearly=3". Valid keys are last,
+// within, daily, weekly, monthly and yearly. within takes a Go duration or
+// a number of days such as "45d".
+func Parse(s string) (Policy, error) {
+	var p Policy
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Policy{}, fmt.Errorf("invalid retention rule %q (expected key=value)", field)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "within" {
+			d, err := parseDuration(value)
+			if err != nil {
+				return Policy{}, fmt.Errorf("invalid retention rule %q: %w", field, err)
+			}
+			p.KeepWithin = d
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return Policy{}, fmt.Errorf("invalid retention rule %q: count must be a non-negative integer", field)
+		}
+		switch key {
+		case "last":
+			p.KeepLast = n
+		case "daily":
+			p.Daily = n
+		case "weekly":
+			p.Weekly = n
+		case "monthly":
+			p.Monthly = n
+		case "yearly":
+			p.Yearly = n
+		default:
+			return Policy{}, fmt.Errorf("unknown retention rule %q", key)
+		}
+	}
+
+	if p.IsZero() {
+		return Policy{}, fmt.Errorf("retention policy %q keeps no snapshots", s)
+	}
+	return p, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// IsZero reports whether the policy has no rules. Parse rejects such a
+// policy and Apply keeps every snapshot under it.
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+func (p Policy) String() string {
+	var rules []string
+	if p.KeepLast > 0 {
+		rules = append(rules, fmt.Sprintf("last=%d", p.KeepLast))
+	}
+	if p.KeepWithin > 0 {
+		rules = append(rules, "within="+formatDuration(p.KeepWithin))
+	}
+	for _, r := range []struct {
+		name  string
+		count int
+	}{{"daily", p.Daily}, {"weekly", p.Weekly}, {"monthly", p.Monthly}, {"yearly", p.Yearly}} {
+		if r.count > 0 {
+			rules = append(rules, fmt.Sprintf("%s=%d", r.name, r.count))
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+func formatDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+type period struct {
+	name  string
+	count int
+	key   func(t time.Time) string
+}
+
+// Apply decides for every snapshot whether it is kept under the policy at
+// time now. Decisions are returned newest first. A zero policy keeps
+// everything rather than deleting every snapshot.
+func (p Policy) Apply(snapshots []Snapshot, now time.Time) []Decision {
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+
+	reasons := make([][]string, len(sorted))
+
+	if p.IsZero() {
+		for i := range sorted {
+			reasons[i] = append(reasons[i], "no retention rules configured")
+		}
+	}
+
+	for i, snap := range sorted {
+		if i < p.KeepLast {
+			reasons[i] = append(reasons[i], fmt.Sprintf("last %d/%d", i+1, p.KeepLast))
+		}
+		if p.KeepWithin > 0 && now.Sub(snap.Created) < p.KeepWithin {
+			reasons[i] = append(reasons[i], "within "+formatDuration(p.KeepWithin))
+		}
+	}
+
+	periods := []period{
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, per := range periods {
+		if per.count <= 0 {
+			continue
+		}
+		seen := 0
+		last := ""
+		for i, snap := range sorted {
+			key := per.key(snap.Created.UTC())
+			if key == last {
+				continue
+			}
+			last = key
+			seen++
+			if seen > per.count {
+				break
+			}
+			reasons[i] = append(reasons[i], fmt.Sprintf("%s %d/%d (%s)", per.name, seen, per.count, key))
+		}
+	}
+
+	decisions := make([]Decision, len(sorted))
+	for i, snap := range sorted {
+		decisions[i] = Decision{Snapshot: snap, Keep: len(reasons[i]) > 0}
+		if decisions[i].Keep {
+			decisions[i].Reason = "kept: " + strings.Join(reasons[i], ", ")
+		} else {
+			decisions[i].Reason = fmt.Sprintf("deleted: not selected by policy %s", p)
+		}
+	}
+
+	return decisions
+}
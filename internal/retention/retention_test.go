@@ -0,0 +1,302 @@
+package retention
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// snapshots returns a snapshot per creation time, named after it.
+func snapshots(created ...string) []Snapshot {
+	var snaps []Snapshot
+	for _, c := range created {
+		snaps = append(snaps, Snapshot{ID: c, Created: date(c)})
+	}
+	return snaps
+}
+
+func TestApply(t *testing.T) {
+	now := date("2025-01-10 12:00")
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		snapshots []Snapshot
+		kept      []string
+	}{
+		{
+			name:   "daily keeps the newest snapshot of each day",
+			policy: Policy{Daily: 3},
+			snapshots: snapshots(
+				"2025-01-10 06:00", "2025-01-10 00:30",
+				"2025-01-09 18:00", "2025-01-09 06:00",
+				"2025-01-07 06:00",
+				"2025-01-06 06:00",
+			),
+			kept: []string{"2025-01-10 06:00", "2025-01-09 18:00", "2025-01-07 06:00"},
+		},
+		{
+			name:   "daily counts days that have a snapshot",
+			policy: Policy{Daily: 2},
+			snapshots: snapshots(
+				"2025-01-01 00:00", "2024-12-20 00:00", "2024-12-01 00:00",
+			),
+			kept: []string{"2025-01-01 00:00", "2024-12-20 00:00"},
+		},
+		{
+			name:   "weekly splits at the ISO week boundary on Monday",
+			policy: Policy{Weekly: 2},
+			snapshots: snapshots(
+				"2025-01-07 00:00", // Tuesday, 2025-W02
+				"2025-01-06 00:00", // Monday, 2025-W02
+				"2025-01-05 23:59", // Sunday, 2025-W01
+				"2025-01-04 00:00", // Saturday, 2025-W01
+				"2024-12-29 00:00", // Sunday, 2024-W52
+			),
+			kept: []string{"2025-01-07 00:00", "2025-01-05 23:59"},
+		},
+		{
+			name:   "weekly puts the last days of December in week 1 of the next year",
+			policy: Policy{Weekly: 3},
+			snapshots: snapshots(
+				"2025-01-02 00:00", // 2025-W01
+				"2024-12-30 00:00", // 2025-W01
+				"2024-12-29 00:00", // 2024-W52
+				"2024-12-23 00:00", // 2024-W52
+				"2024-12-22 00:00", // 2024-W51
+				"2024-12-16 00:00", // 2024-W51
+			),
+			kept: []string{"2025-01-02 00:00", "2024-12-29 00:00", "2024-12-22 00:00"},
+		},
+		{
+			name:   "weekly puts the first days of January in the last week of the previous year",
+			policy: Policy{Weekly: 2},
+			snapshots: snapshots(
+				"2021-01-04 00:00", // 2021-W01
+				"2021-01-03 00:00", // 2020-W53
+				"2021-01-01 00:00", // 2020-W53
+				"2020-12-28 00:00", // 2020-W53
+			),
+			kept: []string{"2021-01-04 00:00", "2021-01-03 00:00"},
+		},
+		{
+			name:   "monthly rolls over the year",
+			policy: Policy{Monthly: 3},
+			snapshots: snapshots(
+				"2025-01-01 00:00",
+				"2024-12-31 23:59", "2024-12-15 00:00",
+				"2024-11-30 00:00",
+				"2024-10-31 00:00",
+			),
+			kept: []string{"2025-01-01 00:00", "2024-12-31 23:59", "2024-11-30 00:00"},
+		},
+		{
+			name:   "yearly keeps the newest snapshot of each year",
+			policy: Policy{Yearly: 2},
+			snapshots: snapshots(
+				"2025-01-01 00:00",
+				"2024-12-31 23:59", "2024-06-01 00:00",
+				"2023-12-31 00:00",
+			),
+			kept: []string{"2025-01-01 00:00", "2024-12-31 23:59"},
+		},
+		{
+			name:   "last keeps the newest snapshots",
+			policy: Policy{KeepLast: 2},
+			snapshots: snapshots(
+				"2024-01-01 00:00", "2025-01-09 00:00", "2025-01-10 00:00", "2024-06-01 00:00",
+			),
+			kept: []string{"2025-01-10 00:00", "2025-01-09 00:00"},
+		},
+		{
+			name:   "last larger than the number of snapshots keeps them all",
+			policy: Policy{KeepLast: 10},
+			snapshots: snapshots(
+				"2025-01-10 00:00", "2024-01-01 00:00",
+			),
+			kept: []string{"2025-01-10 00:00", "2024-01-01 00:00"},
+		},
+		{
+			name:   "within keeps snapshots younger than the duration",
+			policy: Policy{KeepWithin: 48 * time.Hour},
+			snapshots: snapshots(
+				"2025-01-10 11:00",
+				"2025-01-08 12:01",
+				"2025-01-08 12:00", // exactly 48h old
+				"2025-01-01 00:00",
+			),
+			kept: []string{"2025-01-10 11:00", "2025-01-08 12:01"},
+		},
+		{
+			name:   "rules combine",
+			policy: Policy{KeepLast: 1, Daily: 2, Monthly: 2},
+			snapshots: snapshots(
+				"2025-01-10 06:00", "2025-01-10 00:00",
+				"2025-01-09 00:00",
+				"2025-01-08 00:00",
+				"2024-12-31 00:00",
+				"2024-11-30 00:00",
+			),
+			kept: []string{"2025-01-10 06:00", "2025-01-09 00:00", "2024-12-31 00:00"},
+		},
+		{
+			name:   "zero policy keeps everything",
+			policy: Policy{},
+			snapshots: snapshots(
+				"2025-01-10 00:00", "2020-01-01 00:00",
+			),
+			kept: []string{"2025-01-10 00:00", "2020-01-01 00:00"},
+		},
+		{
+			name:      "no snapshots",
+			policy:    Policy{Daily: 7},
+			snapshots: nil,
+			kept:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := tt.policy.Apply(tt.snapshots, now)
+			if len(decisions) != len(tt.snapshots) {
+				t.Fatalf("got %d decisions for %d snapshots", len(decisions), len(tt.snapshots))
+			}
+
+			var kept []string
+			for i, d := range decisions {
+				if i > 0 && d.Snapshot.Created.After(decisions[i-1].Snapshot.Created) {
+					t.Errorf("decisions are not newest first: %s after %s", d.Snapshot.ID, decisions[i-1].Snapshot.ID)
+				}
+				if d.Keep {
+					kept = append(kept, d.Snapshot.ID)
+					if !strings.HasPrefix(d.Reason, "kept: ") {
+						t.Errorf("%s: reason %q of a kept snapshot", d.Snapshot.ID, d.Reason)
+					}
+				} else if !strings.HasPrefix(d.Reason, "deleted: ") {
+					t.Errorf("%s: reason %q of a deleted snapshot", d.Snapshot.ID, d.Reason)
+				}
+			}
+			if !slices.Equal(kept, tt.kept) {
+				t.Errorf("kept %q, want %q", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestApplyEvaluatesPeriodsInUTC(t *testing.T) {
+	// 2025-01-01 00:30 in Berlin is still 2024-12-31 in UTC
+	berlin := time.FixedZone("CET", 60*60)
+	snaps := []Snapshot{
+		{ID: "new-year-berlin", Created: time.Date(2025, 1, 1, 0, 30, 0, 0, berlin)},
+		{ID: "new-year-eve", Created: time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC)},
+		{ID: "december", Created: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	decisions := Policy{Daily: 2}.Apply(snaps, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+	var kept []string
+	for _, d := range decisions {
+		if d.Keep {
+			kept = append(kept, d.Snapshot.ID)
+		}
+	}
+	if want := []string{"new-year-berlin", "december"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+}
+
+func TestApplyReasons(t *testing.T) {
+	now := date("2025-01-10 12:00")
+	decisions := Policy{KeepLast: 1, KeepWithin: 24 * time.Hour, Weekly: 1}.Apply(snapshots(
+		"2025-01-10 00:00", "2025-01-01 00:00",
+	), now)
+
+	if got, want := decisions[0].Reason, "kept: last 1/1, within 1d, weekly 1/1 (2025-W02)"; got != want {
+		t.Errorf("reason %q, want %q", got, want)
+	}
+	if got, want := decisions[1].Reason, "deleted: not selected by policy last=1,within=1d,weekly=1"; got != want {
+		t.Errorf("reason %q, want %q", got, want)
+	}
+
+	zero := Policy{}.Apply(snapshots("2025-01-10 00:00"), now)
+	if got, want := zero[0].Reason, "kept: no retention rules configured"; got != want {
+		t.Errorf("reason %q, want %q", got, want)
+	}
+}
+
+func TestApplyDoesNotReorderInput(t *testing.T) {
+	snaps := snapshots("2025-01-01 00:00", "2025-01-03 00:00", "2025-01-02 00:00")
+	Policy{Daily: 1}.Apply(snaps, date("2025-01-10 00:00"))
+	if snaps[0].ID != "2025-01-01 00:00" || snaps[1].ID != "2025-01-03 00:00" {
+		t.Errorf("Apply reordered its input: %v", snaps)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Policy
+	}{
+		{"daily=7,weekly=4,monthly=12,yearly=3", Policy{Daily: 7, Weekly: 4, Monthly: 12, Yearly: 3}},
+		{"last=5, within=45d", Policy{KeepLast: 5, KeepWithin: 45 * 24 * time.Hour}},
+		{"within=36h", Policy{KeepWithin: 36 * time.Hour}},
+		{" daily = 1 ,,", Policy{Daily: 1}},
+		{"daily=0,weekly=2", Policy{Weekly: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+
+			// The policy is printed in a form that parses back to it
+			again, err := Parse(got.String())
+			if err != nil || again != got {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		message string
+	}{
+		{"", "keeps no snapshots"},
+		{"daily=0", "keeps no snapshots"},
+		{"daily", "expected key=value"},
+		{"daily=-1", "count must be a non-negative integer"},
+		{"daily=seven", "count must be a non-negative integer"},
+		{"hourly=24", `unknown retention rule "hourly"`},
+		{"within=forever", `invalid duration "forever"`},
+		{"within=-1h", `invalid duration "-1h"`},
+		{"within=xd", `invalid number of days "xd"`},
+		{"within=-3d", `invalid number of days "-3d"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := Parse(tt.in)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want an error", tt.in)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Parse(%q) error %q, want it to contain %q", tt.in, err, tt.message)
+			}
+		})
+	}
+}
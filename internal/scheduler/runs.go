@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/state"
+)
+
+// recentRuns is how many finished runs the scheduler remembers.
+const recentRuns = 50
+
+var (
+	ErrCanceled        = errors.New("canceled by operator")
+	ErrUnknownDatabase = errors.New("database is not configured")
+	ErrAlreadyRunning  = errors.New("a backup of the database is already in progress")
+	ErrRunNotFound     = errors.New("run not found")
+	ErrRunFinished     = errors.New("run has already finished")
+	ErrShuttingDown    = errors.New("scheduler is shutting down")
+)
+
+// registry tracks the runs in flight and the most recent finished ones. It
+// keeps copies, taken whenever a run checkpoints, so that they can be read
+// while the run goes on. update and finish are called by the goroutine of
+// the run.
+type registry struct {
+	mu     sync.Mutex
+	active map[string]*activeRun
+	recent []*state.Run
+}
+
+type activeRun struct {
+	run    *state.Run
+	cancel context.CancelCauseFunc
+}
+
+func newRegistry() *registry {
+	return &registry{active: make(map[string]*activeRun)}
+}
+
+// start registers a queued run and returns its context, which cancel
+// cancels with ErrCanceled. If exclusive, the run is refused while another
+// run of the same database is active. A run ID that is already taken, by two
+// schedules firing in the same second, gets a numbered suffix.
+func (r *registry) start(ctx context.Context, run *state.Run, exclusive bool) (context.Context, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if exclusive {
+		for _, active := range r.active {
+			if active.run.Database == run.Database {
+				return nil, fmt.Errorf("%w: %s (run %s)", ErrAlreadyRunning, run.Database, active.run.ID)
+			}
+		}
+	}
+	id := run.ID
+	for n := 2; r.active[run.ID] != nil; n++ {
+		run.ID = fmt.Sprintf("%s-%d", id, n)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	run.Status = state.StatusQueued
+	r.active[run.ID] = &activeRun{run: copyRun(run), cancel: cancel}
+	return ctx, nil
+}
+
+func (r *registry) update(run *state.Run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if active, ok := r.active[run.ID]; ok {
+		run.Updated = time.Now()
+		active.run = copyRun(run)
+	}
+}
+
+// finish moves a run to the recent runs, reported with status.
+func (r *registry) finish(run *state.Run, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if active, ok := r.active[run.ID]; ok {
+		active.cancel(nil)
+		delete(r.active, run.ID)
+	}
+
+	run.Updated = time.Now()
+	finished := copyRun(run)
+	finished.Status = status
+	r.recent = append(r.recent, finished)
+	if len(r.recent) > recentRuns {
+		r.recent = r.recent[len(r.recent)-recentRuns:]
+	}
+}
+
+func (r *registry) cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if active, ok := r.active[id]; ok {
+		active.cancel(ErrCanceled)
+		return nil
+	}
+	for _, run := range r.recent {
+		if run.ID == id {
+			return fmt.Errorf("%w: %s", ErrRunFinished, id)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrRunNotFound, id)
+}
+
+// list returns the active runs, oldest first, followed by the recent runs,
+// newest first.
+func (r *registry) list() []*state.Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := make([]*state.Run, 0, len(r.active)+len(r.recent))
+	for _, active := range r.active {
+		runs = append(runs, copyRun(active.run))
+	}
+	slices.SortFunc(runs, func(a, b *state.Run) int {
+		return a.Started.Compare(b.Started)
+	})
+	for i := len(r.recent) - 1; i >= 0; i-- {
+		runs = append(runs, copyRun(r.recent[i]))
+	}
+	return runs
+}
+
+func (r *registry) get(id string) (*state.Run, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if active, ok := r.active[id]; ok {
+		return copyRun(active.run), true
+	}
+	for _, run := range r.recent {
+		if run.ID == id {
+			return copyRun(run), true
+		}
+	}
+	return nil, false
+}
+
+// copyRun returns a deep copy of run.
+func copyRun(run *state.Run) *state.Run {
+	data, err := json.Marshal(run)
+	if err != nil {
+		panic(fmt.Sprintf("copying run %s: %v", run.ID, err))
+	}
+	var copied state.Run
+	if err := json.Unmarshal(data, &copied); err != nil {
+		panic(fmt.Sprintf("copying run %s: %v", run.ID, err))
+	}
+	return &copied
+}
+
+// Runs returns the runs in flight and the most recently finished ones.
+func (s *Scheduler) Runs() []*state.Run {
+	return s.runs.list()
+}
+
+// LookupRun returns a run in flight or recently finished.
+func (s *Scheduler) LookupRun(id string) (*state.Run, bool) {
+	return s.runs.get(id)
+}
+
+// Cancel stops a run in flight at its next wait. Unlike a shutdown, a
+// cancelled run is not resumed.
+func (s *Scheduler) Cancel(id string) error {
+	return s.runs.cancel(id)
+}
+
+// Trigger starts an on-demand backup of a configured database and returns
+// its run. The backup is notified like a scheduled one. It is refused while
+// another backup of the database is in progress.
+func (s *Scheduler) Trigger(database string, opts backup.Options) (*state.Run, error) {
+	db, ok := s.cfg.Database(database)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDatabase, database)
+	}
+	if !s.track() {
+		return nil, ErrShuttingDown
+	}
+
+	run := newRun(db.DBIdentifier, opts)
+	ctx, err := s.runs.start(s.ctx, run, true)
+	if err != nil {
+		s.wg.Done()
+		return nil, err
+	}
+	queued := copyRun(run)
+
+	go func() {
+		defer s.wg.Done()
+		log.Printf("Starting on-demand backup %s", run.ID)
+		result := s.runOne(ctx, db, run, opts)
+		Notify(s.cfg, []*backup.Result{result})
+	}()
+	return queued, nil
+}
+
+func newRun(database string, opts backup.Options) *state.Run {
+	started := time.Now()
+	return &state.Run{
+		ID:         state.NewRunID(database, started),
+		Database:   database,
+		SkipExport: opts.SkipExport,
+		Started:    started,
+		Result: &backup.Result{
+			DBIdentifier: database,
+			BackupTime:   started.Format(time.RFC3339),
+		},
+	}
+}
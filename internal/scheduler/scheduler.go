@@ -0,0 +1,499 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/drill"
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+	"github.com/unplank/rds-backup-lambda/internal/notification"
+	"github.com/unplank/rds-backup-lambda/internal/state"
+)
+
+type Scheduler struct {
+	cfg   *config.Config
+	cron  *cron.Cron
+	sem   chan struct{}
+	store state.Store
+
+	// ctx is the parent of every scheduled and resumed run and is cancelled
+	// by Shutdown. wg tracks the runs in flight; once closed no new run starts.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+
+	runs    *registry
+	entries []entry
+
+	// databases holds a slot per database, taken while one of its runs is
+	// in flight, so that runs of the same database wait for each other.
+	databases map[string]chan struct{}
+
+	// Perform backs up one database. It is backup.Perform; tests replace it
+	// to run the scheduler without AWS.
+	Perform func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error
+}
+
+// entry is a job registered with cron.
+type entry struct {
+	id  cron.EntryID
+	job *job
+}
+
+// job is one cron entry. Databases that share an identical schedule are
+// backed up by the same job so that digest notifications cover the whole run.
+// A drill job runs the restore drill of a single database instead.
+type job struct {
+	schedule  config.Schedule
+	databases []config.Database
+	drill     bool
+}
+
+func New(cfg *config.Config) (*Scheduler, error) {
+	c := cron.New(cron.WithLocation(time.UTC),
+		cron.WithChain(
+			cron.Recover(cron.DefaultLogger),            // Recover from panics
+			cron.SkipIfStillRunning(cron.DefaultLogger), // Skip if previous backup is still running
+		))
+
+	limit := cfg.MaxConcurrency
+	if limit < 1 {
+		limit = 1
+	}
+	store, err := state.Open(context.Background(), cfg.StateStore, cfg.SourceRegion, cfg.SourceRole)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{cfg: cfg, cron: c, sem: make(chan struct{}, limit), store: store, runs: newRegistry(),
+		databases: make(map[string]chan struct{}), Perform: backup.Perform}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	for _, j := range buildJobs(cfg.Databases) {
+		id, err := c.AddFunc(j.spec(), func() {
+			if j.drill {
+				s.runDrill(s.ctx, j)
+				return
+			}
+			s.runJob(s.ctx, j)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error scheduling backup %q: %w", j.schedule.Name, err)
+		}
+		s.entries = append(s.entries, entry{id: id, job: j})
+		log.Printf("Scheduled %q (%s) for %d database(s)", j.schedule.Name, j.spec(), len(j.databases))
+	}
+
+	return s, nil
+}
+
+// buildJobs groups the schedules of the databases into cron jobs: databases
+// with the same spec, timezone, jitter and export setting share a job.
+// config.Load has checked the schedules.
+func buildJobs(databases []config.Database) []*job {
+	type key struct {
+		spec       string
+		timezone   string
+		jitter     time.Duration
+		skipExport bool
+	}
+
+	var jobs []*job
+	byKey := make(map[key]*job)
+	for _, db := range databases {
+		for _, schedule := range db.Schedules {
+			k := key{schedule.Spec, schedule.Timezone, schedule.Jitter, schedule.SkipExport}
+			j, ok := byKey[k]
+			if !ok {
+				j = &job{schedule: schedule}
+				byKey[k] = j
+				jobs = append(jobs, j)
+			}
+			j.databases = append(j.databases, db)
+		}
+	}
+
+	for _, db := range databases {
+		if db.Drill.Schedule == "" {
+			continue
+		}
+		schedule := config.Schedule{Name: "drill-" + db.DBIdentifier, Spec: db.Drill.Schedule}
+		jobs = append(jobs, &job{schedule: schedule, databases: []config.Database{db}, drill: true})
+	}
+
+	return jobs
+}
+
+func (j *job) spec() string {
+	if j.schedule.Timezone == "" {
+		return j.schedule.Spec
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", j.schedule.Timezone, j.schedule.Spec)
+}
+
+// ScheduledJob is a cron entry and the next time it fires. Next is zero
+// until the scheduler is started.
+type ScheduledJob struct {
+	Name       string    `json:"name"`
+	Spec       string    `json:"spec"`
+	Databases  []string  `json:"databases"`
+	SkipExport bool      `json:"skipExport,omitempty"`
+	Drill      bool      `json:"drill,omitempty"`
+	Next       time.Time `json:"next"`
+}
+
+// Schedules returns the scheduled jobs in the order they were configured.
+func (s *Scheduler) Schedules() []ScheduledJob {
+	jobs := make([]ScheduledJob, 0, len(s.entries))
+	for _, e := range s.entries {
+		job := ScheduledJob{
+			Name:       e.job.schedule.Name,
+			Spec:       e.job.spec(),
+			SkipExport: e.job.schedule.SkipExport,
+			Drill:      e.job.drill,
+			Next:       s.cron.Entry(e.id).Next,
+		}
+		for _, db := range e.job.databases {
+			job.Databases = append(job.Databases, db.DBIdentifier)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+// Shutdown stops scheduling new backups and cancels the ones in flight.
+// Interrupted runs stop at their next wait, save their state so that they are
+// resumed on the next start, and send an interrupted notification. Shutdown
+// waits up to grace for them to do so.
+func (s *Scheduler) Shutdown(grace time.Duration) {
+	s.cron.Stop()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("All in-flight backups stopped")
+	case <-time.After(grace):
+		log.Printf("Shutdown grace period of %s expired before in-flight backups stopped", grace)
+	}
+}
+
+// track registers a run with the wait group unless the scheduler is
+// shutting down.
+func (s *Scheduler) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Scheduler) runJob(ctx context.Context, j *job) {
+	if !s.track() {
+		return
+	}
+	defer s.wg.Done()
+
+	if j.schedule.Jitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(j.schedule.Jitter)))
+		log.Printf("Delaying %q backup by %s", j.schedule.Name, delay.Round(time.Second))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("Skipping %q backup: scheduler is shutting down", j.schedule.Name)
+			return
+		}
+	}
+
+	results := s.Run(ctx, j.databases, backup.Options{SkipExport: j.schedule.SkipExport})
+	Notify(s.cfg, results)
+	s.prune(ctx)
+}
+
+// runDrill runs the restore drill of the database of j and notifies the
+// result. Drills do not count towards cfg.MaxConcurrency: they only touch the
+// target region.
+func (s *Scheduler) runDrill(ctx context.Context, j *job) {
+	if !s.track() {
+		return
+	}
+	defer s.wg.Done()
+
+	db := &j.databases[0]
+	log.Printf("Starting restore drill of %s", db.DBIdentifier)
+	result := drill.Run(ctx, s.cfg, db)
+	RecordDrill(result)
+	notification.SendDrill(context.Background(), s.cfg, result)
+}
+
+// RecordDrill updates the drill metrics with a result.
+func RecordDrill(result *drill.Result) {
+	status := "passed"
+	if !result.Passed {
+		status = "failed"
+	}
+	metrics.Drills.WithLabelValues(result.DBIdentifier, status).Inc()
+	if result.Passed {
+		metrics.LastDrillSuccess.WithLabelValues(result.DBIdentifier).SetToCurrentTime()
+	}
+}
+
+// Run backs up the given databases. At most cfg.MaxConcurrency backups are
+// in flight across all jobs of the scheduler, and at most one per database:
+// a run of a database that is already being backed up, for example by
+// another schedule that fired at the same time, waits for it to finish.
+// Results are returned in the same order as dbs.
+func (s *Scheduler) Run(ctx context.Context, dbs []config.Database, opts backup.Options) []*backup.Result {
+	results := make([]*backup.Result, len(dbs))
+	var wg sync.WaitGroup
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run := newRun(dbs[i].DBIdentifier, opts)
+			runCtx, _ := s.runs.start(ctx, run, false)
+			results[i] = s.runOne(runCtx, &dbs[i], run, opts)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Resume continues the runs that were in flight when the process last
+// stopped. Their snapshots, copies and export tasks are picked up again
+// rather than started over.
+func (s *Scheduler) Resume() []*backup.Result {
+	if s.store == nil || !s.track() {
+		return nil
+	}
+	defer s.wg.Done()
+	ctx := s.ctx
+
+	s.prune(ctx)
+	runs, err := state.InFlight(ctx, s.store)
+	if err != nil {
+		log.Printf("Failed to load in-flight runs: %v", err)
+		return nil
+	}
+
+	var results []*backup.Result
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		db, ok := s.cfg.Database(run.Database)
+		if !ok {
+			log.Printf("Not resuming run %s: database %s is no longer configured", run.ID, run.Database)
+			continue
+		}
+
+		runCtx, _ := s.runs.start(ctx, run, false)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("Resuming run %s (completed stages: %v)", run.ID, run.Result.State.Completed)
+			result := s.runOne(runCtx, db, run, backup.Options{SkipExport: run.SkipExport})
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(results) > 0 {
+		Notify(s.cfg, results)
+	}
+	return results
+}
+
+// SeedMetrics sets the last success metric of each database from the state
+// store, so that it survives restarts. Like in runOne, only runs that export
+// count, so that frequent no-export schedules cannot hide failing exports.
+func (s *Scheduler) SeedMetrics(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+	runs, err := s.store.List(ctx)
+	if err != nil {
+		log.Printf("Failed to load runs for metrics: %v", err)
+		return
+	}
+
+	last := make(map[string]time.Time)
+	for _, run := range runs {
+		if run.Status == state.StatusComplete && !run.SkipExport && run.Updated.After(last[run.Database]) {
+			last[run.Database] = run.Updated
+		}
+	}
+	for database, updated := range last {
+		metrics.LastSuccess.WithLabelValues(database).Set(float64(updated.Unix()))
+	}
+}
+
+// databaseSlot returns the slot of database id.
+func (s *Scheduler) databaseSlot(id string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, ok := s.databases[id]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		s.databases[id] = slot
+	}
+	return slot
+}
+
+// acquire takes a slot of sem, or returns an error if ctx is done first.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("before it started: %w", context.Cause(ctx))
+	}
+}
+
+// runOne waits for the slot of db and a backup slot and backs up db. ctx
+// must come from s.runs.start for run.
+func (s *Scheduler) runOne(ctx context.Context, db *config.Database, run *state.Run, opts backup.Options) *backup.Result {
+	result := run.Result
+	result.RunID = run.ID
+	// State is still saved after ctx is cancelled, so that an interrupted run
+	// can be resumed.
+	saveCtx := context.WithoutCancel(ctx)
+	persist := s.store != nil && !opts.DryRun
+
+	slot := s.databaseSlot(db.DBIdentifier)
+	if len(slot) > 0 {
+		log.Printf("Run %s waits for the backup of %s in progress", run.ID, db.DBIdentifier)
+	}
+	err := acquire(ctx, slot)
+	if err == nil {
+		defer func() { <-slot }()
+		err = acquire(ctx, s.sem)
+	}
+	if err == nil {
+		defer func() { <-s.sem }()
+
+		// A resumed run starts over without the error that interrupted it
+		run.Status = state.StatusRunning
+		result.ErrorMessage = ""
+		result.Interrupted = false
+		opts.Checkpoint = func(result *backup.Result) error {
+			s.runs.update(run)
+			if !persist {
+				return nil
+			}
+			return s.save(saveCtx, run)
+		}
+		if err := opts.Checkpoint(result); err != nil {
+			log.Printf("Warning: Failed to save run state for %s: %v", db.DBIdentifier, err)
+		}
+		if opts.CheckNotifiers == nil {
+			opts.CheckNotifiers = func(ctx context.Context) []error {
+				return notification.Check(ctx, s.cfg)
+			}
+		}
+
+		log.Printf("Starting database backup for %s", db.DBIdentifier)
+		err = s.Perform(ctx, s.cfg, db, opts, result)
+	}
+
+	outcome := state.StatusComplete
+	switch {
+	case err != nil && errors.Is(context.Cause(ctx), ErrCanceled):
+		result.ErrorMessage = fmt.Sprintf("%v: %v", ErrCanceled, err)
+		run.Status = state.StatusCanceled
+		outcome = state.StatusCanceled
+		log.Printf("Backup of %s was canceled: %v", db.DBIdentifier, err)
+	case err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)):
+		// The run stays in the running state so that it is resumed
+		result.ErrorMessage = err.Error()
+		result.Interrupted = true
+		outcome = state.StatusInterrupted
+		log.Printf("Backup of %s was interrupted: %v", db.DBIdentifier, err)
+	case err != nil:
+		result.ErrorMessage = err.Error()
+		run.Status = state.StatusFailed
+		outcome = state.StatusFailed
+		log.Printf("Backup failed for %s: %v", db.DBIdentifier, err)
+	default:
+		run.Status = state.StatusComplete
+		log.Printf("Backup completed successfully for %s", db.DBIdentifier)
+	}
+	if !opts.DryRun {
+		metrics.Runs.WithLabelValues(db.DBIdentifier, outcome).Inc()
+		if outcome == state.StatusComplete && !opts.SkipExport {
+			metrics.LastSuccess.WithLabelValues(db.DBIdentifier).SetToCurrentTime()
+		}
+	}
+
+	if persist && run.Status != state.StatusQueued {
+		if err := s.save(saveCtx, run); err != nil {
+			log.Printf("Warning: Failed to save run state for %s: %v", db.DBIdentifier, err)
+		}
+	}
+	if s.cfg.ReportDir != "" {
+		if err := backup.WriteReport(s.cfg.ReportDir, run.ID, result); err != nil {
+			log.Printf("Warning: Failed to write report for %s: %v", db.DBIdentifier, err)
+		}
+	}
+	s.runs.finish(run, outcome)
+
+	return result
+}
+
+// prune deletes the finished runs older than cfg.StateRetention from the
+// state store.
+func (s *Scheduler) prune(ctx context.Context) {
+	if s.store == nil || s.cfg.StateRetention <= 0 {
+		return
+	}
+	deleted, err := state.Prune(ctx, s.store, s.cfg.StateRetention, time.Now())
+	if err != nil {
+		log.Printf("Warning: Failed to prune the state store: %v", err)
+	}
+	if deleted > 0 {
+		log.Printf("Pruned %d finished run(s) older than %s from the state store", deleted, s.cfg.StateRetention)
+	}
+}
+
+func (s *Scheduler) save(ctx context.Context, run *state.Run) error {
+	run.Updated = time.Now()
+	return s.store.Save(ctx, run)
+}
+
+// Notify sends the results to the configured notification channels, one
+// message per result or a single digest depending on cfg.NotificationMode.
+func Notify(cfg *config.Config, results []*backup.Result) {
+	notification.Send(context.Background(), cfg, results)
+}
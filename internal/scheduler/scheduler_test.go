@@ -0,0 +1,235 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+	"github.com/unplank/rds-backup-lambda/internal/metrics"
+	"github.com/unplank/rds-backup-lambda/internal/notification"
+	"github.com/unplank/rds-backup-lambda/internal/state"
+)
+
+func testConfig(t *testing.T, databases ...config.Database) *config.Config {
+	return &config.Config{
+		SourceRegion:   "us-east-1",
+		TargetRegion:   "us-west-2",
+		MaxConcurrency: 4,
+		StateStore:     "file://" + t.TempDir(),
+		Databases:      databases,
+	}
+}
+
+func TestSchedulesOfADatabaseDoNotOverlap(t *testing.T) {
+	cfg := testConfig(t, config.Database{
+		DBIdentifier: "orders",
+		Schedules: []config.Schedule{
+			{Name: "hourly", Spec: "0 * * * *", Timezone: "UTC", SkipExport: true},
+			{Name: "daily", Spec: "0 2 * * *", Timezone: "UTC"},
+		},
+	})
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(5 * time.Second)
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight, runs int
+	s.Perform = func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+		mu.Lock()
+		inFlight++
+		runs++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	}
+
+	// Both schedules fire at 02:00
+	if len(s.entries) != 2 {
+		t.Fatalf("%d jobs, want 2", len(s.entries))
+	}
+	var wg sync.WaitGroup
+	for _, e := range s.entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJob(s.ctx, e.job)
+		}()
+	}
+	wg.Wait()
+
+	if runs != 2 || maxInFlight != 1 {
+		t.Errorf("%d run(s), at most %d in flight; want 2 runs, one at a time", runs, maxInFlight)
+	}
+	if len(s.Runs()) != 2 {
+		t.Errorf("runs %+v", s.Runs())
+	}
+	for _, run := range s.Runs() {
+		if run.Status != state.StatusComplete {
+			t.Errorf("run %s is %s", run.ID, run.Status)
+		}
+	}
+}
+
+func TestShutdownInterruptsAndResumesARun(t *testing.T) {
+	var mu sync.Mutex
+	var subjects []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg notification.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		mu.Lock()
+		subjects = append(subjects, msg.Subject)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	cfg := testConfig(t, config.Database{DBIdentifier: "orders"})
+	cfg.Channels = []config.Channel{{
+		Name: "hook",
+		Type: config.ChannelWebhook,
+		URL:  config.Secret(webhook.URL),
+		On:   []string{config.EventSuccess, config.EventFailure},
+	}}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s.Perform = func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+		result.State.SourceSnapshotID = "backup-orders-2025-01-10-12-00-00"
+		result.State.Completed = append(result.State.Completed, backup.StagePreflight, backup.StageSnapshot)
+		if err := opts.Checkpoint(result); err != nil {
+			return err
+		}
+		close(started)
+		<-ctx.Done()
+		return fmt.Errorf("waiting for the snapshot copy: %w", ctx.Err())
+	}
+
+	run, err := s.Trigger("orders", backup.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	s.Shutdown(5 * time.Second)
+
+	interrupted, ok := s.LookupRun(run.ID)
+	if !ok || !interrupted.Result.Interrupted || interrupted.Result.ErrorMessage == "" {
+		t.Errorf("run %+v", interrupted)
+	}
+	store, err := state.Open(context.Background(), cfg.StateStore, cfg.SourceRegion, cfg.SourceRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := state.InFlight(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].ID != run.ID || saved[0].Status != state.StatusRunning || !saved[0].Result.Interrupted {
+		t.Fatalf("saved runs %+v", saved)
+	}
+	mu.Lock()
+	if !slices.Equal(subjects, []string{"RDS Backup Interrupted"}) {
+		t.Errorf("notifications %q", subjects)
+	}
+	subjects = nil
+	mu.Unlock()
+
+	// The next start resumes the run from its saved state
+	next, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Shutdown(5 * time.Second)
+	next.Perform = func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+		if result.RunID != run.ID || result.State.SourceSnapshotID != "backup-orders-2025-01-10-12-00-00" || !result.State.Done(backup.StageSnapshot) || result.State.Done(backup.StageCopy) {
+			t.Errorf("resumed result %+v", result)
+		}
+		return nil
+	}
+	results := next.Resume()
+	if len(results) != 1 || results[0].ErrorMessage != "" || results[0].Interrupted {
+		t.Fatalf("resumed results %+v", results)
+	}
+	saved, err = state.InFlight(context.Background(), store)
+	if err != nil || len(saved) != 0 {
+		t.Errorf("in-flight runs after resuming %+v, %v", saved, err)
+	}
+	if resumed, ok := next.LookupRun(run.ID); !ok || resumed.Status != state.StatusComplete {
+		t.Errorf("resumed run %+v", resumed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(subjects, []string{"RDS Backup Successful"}) {
+		t.Errorf("notifications after resuming %q", subjects)
+	}
+}
+
+func TestLastSuccessCountsExportingRunsOnly(t *testing.T) {
+	db := config.Database{DBIdentifier: "billing", Schedules: []config.Schedule{{Name: "daily", Spec: "0 2 * * *", Timezone: "UTC"}}}
+	cfg := testConfig(t, db)
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(5 * time.Second)
+	s.Perform = func(ctx context.Context, cfg *config.Config, db *config.Database, opts backup.Options, result *backup.Result) error {
+		return nil
+	}
+	lastSuccess := func() float64 { return testutil.ToFloat64(metrics.LastSuccess.WithLabelValues("billing")) }
+
+	s.Run(s.ctx, cfg.Databases, backup.Options{SkipExport: true})
+	if got := lastSuccess(); got != 0 {
+		t.Errorf("last success %v after a run without export", got)
+	}
+	s.Run(s.ctx, cfg.Databases, backup.Options{})
+	if got := lastSuccess(); got < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("last success %v after a run with export", got)
+	}
+
+	// Seeded from the store, a newer run without export does not count
+	cfg = testConfig(t, db)
+	store, err := state.Open(context.Background(), cfg.StateStore, cfg.SourceRegion, cfg.SourceRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := time.Date(2025, 1, 10, 2, 0, 0, 0, time.UTC)
+	for _, run := range []*state.Run{
+		{ID: "billing-exported", Database: "billing", Status: state.StatusComplete, Updated: exported},
+		{ID: "billing-snapshot", Database: "billing", Status: state.StatusComplete, SkipExport: true, Updated: exported.Add(time.Hour)},
+	} {
+		run.Started = run.Updated
+		run.Result = &backup.Result{DBIdentifier: "billing"}
+		if err := store.Save(context.Background(), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seeded, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seeded.Shutdown(5 * time.Second)
+	metrics.LastSuccess.Reset()
+	seeded.SeedMetrics(context.Background())
+	if got := lastSuccess(); got != float64(exported.Unix()) {
+		t.Errorf("seeded last success %v, want %v", got, exported.Unix())
+	}
+}
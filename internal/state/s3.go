@@ -0,0 +1,140 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsinternal "github.com/unplank/rds-backup-lambda/internal/aws"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+// S3Store keeps one JSON object per run under a prefix of a bucket.
+type S3Store struct {
+	client awsinternal.S3API
+	bucket string
+	prefix string
+}
+
+func NewS3Store(ctx context.Context, region string, role config.Role, bucket, prefix string) (*S3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("state store bucket must not be empty")
+	}
+
+	cfg, err := awsinternal.LoadConfig(ctx, region, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state store config: %w", err)
+	}
+
+	return NewS3StoreWithClient(s3.NewFromConfig(cfg), bucket, prefix), nil
+}
+
+// NewS3StoreWithClient returns a store that uses the given S3 client.
+func NewS3StoreWithClient(client awsinternal.S3API, bucket, prefix string) *S3Store {
+	return &S3Store{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}
+}
+
+func (s *S3Store) Save(ctx context.Context, run *Run) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run %s: %w", run.ID, err)
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(run.ID)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context) ([]*Run, error) {
+	var runs []*Run
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key("")),
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list runs: %w", err)
+		}
+
+		for _, object := range output.Contents {
+			if !strings.HasSuffix(aws.ToString(object.Key), ".json") {
+				continue
+			}
+			// A record that cannot be read is skipped rather than hiding the
+			// others
+			run, err := s.get(ctx, aws.ToString(object.Key))
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				log.Printf("Warning: Skipping run record: %v", err)
+				continue
+			}
+			runs = append(runs, run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Started.After(runs[j].Started)
+	})
+	return runs, nil
+}
+
+func (s *S3Store) get(ctx context.Context, key string) (*Run, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return &run, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, id string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(id)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete run %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *S3Store) key(id string) string {
+	if id == "" {
+		if s.prefix == "" {
+			return ""
+		}
+		return s.prefix + "/"
+	}
+	return path.Join(s.prefix, id+".json")
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unplank/rds-backup-lambda/internal/backup"
+	"github.com/unplank/rds-backup-lambda/internal/config"
+)
+
+const (
+	StatusRunning  = "running"
+	StatusComplete = "complete"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+// Statuses that are reported for runs but never saved: a queued run waits for
+// a free backup slot, and an interrupted run is saved as running so that it
+// is resumed.
+const (
+	StatusQueued      = "queued"
+	StatusInterrupted = "interrupted"
+)
+
+// Run is the persisted record of one backup. Result carries the stage state
+// used to resume the run after a restart.
+type Run struct {
+	ID         string         `json:"id"`
+	Database   string         `json:"database"`
+	Status     string         `json:"status"`
+	SkipExport bool           `json:"skipExport,omitempty"`
+	Started    time.Time      `json:"started"`
+	Updated    time.Time      `json:"updated"`
+	Result     *backup.Result `json:"result"`
+}
+
+// Store persists runs. List returns them newest first and skips records
+// that cannot be read.
+type Store interface {
+	Save(ctx context.Context, run *Run) error
+	List(ctx context.Context) ([]*Run, error)
+	Delete(ctx context.Context, id string) error
+}
+
+func NewRunID(database string, started time.Time) string {
+	return fmt.Sprintf("%s-%s", database, started.UTC().Format("20060102T150405Z"))
+}
+
+// Open returns the store described by url: "file:///path/to/dir" or
+// "s3://bucket/prefix". An S3 store is accessed in region with the
+// credentials of role. An empty url disables persistence and returns nil.
+func Open(ctx context.Context, url, region string, role config.Role) (Store, error) {
+	switch {
+	case url == "":
+		return nil, nil
+	case strings.HasPrefix(url, "file://"):
+		return NewFileStore(strings.TrimPrefix(url, "file://"))
+	case strings.HasPrefix(url, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(url, "s3://"), "/")
+		return NewS3Store(ctx, region, role, bucket, prefix)
+	default:
+		return nil, fmt.Errorf("unsupported state store %q (expected file:// or s3://)", url)
+	}
+}
+
+// Prune deletes the finished runs last updated more than maxAge before now,
+// so that the store does not grow without bound. Runs still running are kept
+// to be resumed, and so is the newest complete run of each database, which
+// seeds the last success metric. It returns the number of runs deleted.
+func Prune(ctx context.Context, store Store, maxAge time.Duration, now time.Time) (int, error) {
+	runs, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	lastComplete := make(map[string]bool)
+	for _, run := range runs {
+		if run.Status == StatusRunning {
+			continue
+		}
+		if run.Status == StatusComplete && !lastComplete[run.Database] {
+			lastComplete[run.Database] = true
+			continue
+		}
+		if now.Sub(run.Updated) <= maxAge {
+			continue
+		}
+		if err := store.Delete(ctx, run.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// InFlight returns the runs that were still running when they were last saved.
+func InFlight(ctx context.Context, store Store) ([]*Run, error) {
+	runs, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var inFlight []*Run
+	for _, run := range runs {
+		if run.Status == StatusRunning {
+			inFlight = append(inFlight, run)
+		}
+	}
+	return inFlight, nil
+}
@@ -0,0 +1,103 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileStoreListSkipsUnreadableRecords(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	for _, run := range []*Run{
+		{ID: "orders-1", Database: "orders", Status: StatusRunning, Started: started},
+		{ID: "orders-2", Database: "orders", Status: StatusComplete, Started: started.Add(time.Hour)},
+	} {
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got, want := ids(runs), []string{"orders-2", "orders-1"}; !slices.Equal(got, want) {
+		t.Errorf("List returned %q, want %q", got, want)
+	}
+
+	inFlight, err := InFlight(ctx, store)
+	if err != nil {
+		t.Fatalf("InFlight: %v", err)
+	}
+	if got, want := ids(inFlight), []string{"orders-1"}; !slices.Equal(got, want) {
+		t.Errorf("InFlight returned %q, want %q", got, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-60 * 24 * time.Hour)
+	for _, run := range []*Run{
+		// orders has a recent complete run, so the old ones go
+		{ID: "orders-new", Database: "orders", Status: StatusComplete, Started: now.Add(-time.Hour), Updated: now.Add(-time.Hour)},
+		{ID: "orders-old-complete", Database: "orders", Status: StatusComplete, Started: old, Updated: old},
+		{ID: "orders-old-failed", Database: "orders", Status: StatusFailed, Started: old.Add(-time.Hour), Updated: old},
+		{ID: "orders-old-canceled", Database: "orders", Status: StatusCanceled, Started: old.Add(-2 * time.Hour), Updated: old},
+		// a run still running is resumed, however old
+		{ID: "orders-old-running", Database: "orders", Status: StatusRunning, Started: old.Add(-3 * time.Hour), Updated: old},
+		// the last complete run of analytics is old but kept for the metrics
+		{ID: "analytics-failed", Database: "analytics", Status: StatusFailed, Started: now.Add(-time.Hour), Updated: now.Add(-time.Hour)},
+		{ID: "analytics-old-complete", Database: "analytics", Status: StatusComplete, Started: old, Updated: old},
+		{ID: "analytics-older-complete", Database: "analytics", Status: StatusComplete, Started: old.Add(-time.Hour), Updated: old},
+	} {
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := Prune(ctx, store, 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if deleted != 4 {
+		t.Errorf("Prune deleted %d runs, want 4", deleted)
+	}
+
+	runs, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ids(runs)
+	slices.Sort(got)
+	want := []string{"analytics-failed", "analytics-old-complete", "orders-new", "orders-old-running"}
+	if !slices.Equal(got, want) {
+		t.Errorf("kept %q, want %q", got, want)
+	}
+}
+
+func ids(runs []*Run) []string {
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	return ids
+}